@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// ARCHIVES ////////////////////////////
+///////////////////////////////////////////////////////////
+
+// maxUnpackedBytes guards against decompression bombs.
+const maxUnpackedBytes = 256 << 20
+
+// unpackArchive replaces a gzip or zip source with the file inside it, so
+// the usual format detection sees the inner name (prices.csv.gz becomes
+// prices.csv). For zips, pattern is a glob matched against the entry's
+// base name or full path; the first match in name order wins.
+func unpackArchive(src *fetchedSource, pattern string) (*fetchedSource, error) {
+
+	name := strings.ToLower(src.URL)
+	if i := strings.IndexAny(name, "?#"); i != -1 {
+		name = name[:i]
+	}
+
+	switch {
+	case bytes.HasPrefix(src.Body, []byte{0x1f, 0x8b}):
+		return gunzipSource(src, name)
+	case bytes.HasPrefix(src.Body, []byte("PK\x03\x04")):
+		return unzipSource(src, pattern)
+	}
+
+	return src, nil
+}
+
+func gunzipSource(src *fetchedSource, name string) (*fetchedSource, error) {
+
+	if strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tar.gz") {
+		return nil, fmt.Errorf("tar archives are not supported")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(src.Body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip data: %w", err)
+	}
+	defer gz.Close()
+
+	body, err := readLimited(gz)
+	if err != nil {
+		return nil, err
+	}
+
+	inner := strings.TrimSuffix(name, ".gz")
+	if inner == name && gz.Name != "" {
+		inner = gz.Name
+	}
+
+	fmt.Printf("📦 Decompressed gzip: %d bytes\n", len(body))
+
+	return &fetchedSource{URL: inner, Body: body}, nil
+}
+
+func unzipSource(src *fetchedSource, pattern string) (*fetchedSource, error) {
+
+	zr, err := zip.NewReader(bytes.NewReader(src.Body), int64(len(src.Body)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip archive: %w", err)
+	}
+
+	var candidates []*zip.File
+
+	for _, f := range zr.File {
+
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+
+		if pattern != "" {
+			base, _ := path.Match(pattern, path.Base(f.Name))
+			full, _ := path.Match(pattern, f.Name)
+			if !base && !full {
+				continue
+			}
+		}
+
+		candidates = append(candidates, f)
+	}
+
+	if len(candidates) == 0 {
+		if pattern != "" {
+			return nil, fmt.Errorf("no file in archive matches %q", pattern)
+		}
+		return nil, fmt.Errorf("zip archive is empty")
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	f := candidates[0]
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s in archive: %w", f.Name, err)
+	}
+	defer rc.Close()
+
+	body, err := readLimited(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("📦 Extracted %s from zip (%d bytes)\n", f.Name, len(body))
+
+	return &fetchedSource{URL: f.Name, Body: body}, nil
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+
+	body, err := io.ReadAll(io.LimitReader(r, maxUnpackedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress: %w", err)
+	}
+
+	if len(body) > maxUnpackedBytes {
+		return nil, fmt.Errorf("archive expands beyond %d MB", maxUnpackedBytes>>20)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// AVRO SOURCE /////////////////////////
+///////////////////////////////////////////////////////////
+
+// avroField is one field of the writer schema embedded in the file.
+type avroField struct {
+	Name string          `json:"name"`
+	Type json.RawMessage `json:"type"`
+}
+
+// parseAvro reads an Avro object container file. Column types come from
+// the embedded writer schema rather than string inference.
+func parseAvro(body []byte) (Preview, error) {
+
+	ocf, err := goavro.NewOCFReader(bytes.NewReader(body))
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to open Avro file: %w", err)
+	}
+
+	var schema struct {
+		Type   string      `json:"type"`
+		Fields []avroField `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(ocf.Codec().Schema()), &schema); err != nil || schema.Type != "record" {
+		return Preview{}, fmt.Errorf("avro schema must be a record")
+	}
+
+	cols := make([]string, len(schema.Fields))
+	types := make([]string, len(schema.Fields))
+	scales := make([]int, len(schema.Fields))
+	unions := make([]bool, len(schema.Fields))
+
+	for i, f := range schema.Fields {
+		cols[i] = f.Name
+		types[i], scales[i] = avroSQLType(f.Type)
+		unions[i] = bytes.HasPrefix(bytes.TrimSpace(f.Type), []byte("["))
+	}
+
+	var rows [][]string
+
+	for ocf.Scan() {
+
+		datum, err := ocf.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return Preview{}, fmt.Errorf("failed to read Avro record: %w", err)
+		}
+
+		rec, ok := datum.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		row := make([]string, len(cols))
+		for i, f := range schema.Fields {
+			v := rec[f.Name]
+			if unions[i] {
+				v = unwrapUnion(v)
+			}
+			row[i] = avroCell(v, types[i], scales[i])
+		}
+
+		rows = append(rows, row)
+	}
+
+	if err := ocf.Err(); err != nil {
+		return Preview{}, fmt.Errorf("failed to read Avro file: %w", err)
+	}
+
+	return buildTypedPreview(cols, types, rows)
+}
+
+// avroSQLType maps an Avro field type to a column type, returning the
+// decimal scale where relevant. Nullable unions map to their other
+// branch; records, arrays and maps are stored as JSON text.
+func avroSQLType(raw json.RawMessage) (string, int) {
+
+	var name string
+	if json.Unmarshal(raw, &name) == nil {
+		return avroPrimitive(name), 0
+	}
+
+	var union []json.RawMessage
+	if json.Unmarshal(raw, &union) == nil {
+		var branches []json.RawMessage
+		for _, b := range union {
+			if string(b) != `"null"` {
+				branches = append(branches, b)
+			}
+		}
+		if len(branches) == 1 {
+			return avroSQLType(branches[0])
+		}
+		return "TEXT", 0
+	}
+
+	var complex struct {
+		Type        string `json:"type"`
+		LogicalType string `json:"logicalType"`
+		Precision   int    `json:"precision"`
+		Scale       int    `json:"scale"`
+	}
+	json.Unmarshal(raw, &complex)
+
+	switch complex.LogicalType {
+	case "date":
+		return "DATE", 0
+	case "timestamp-millis", "timestamp-micros", "local-timestamp-millis", "local-timestamp-micros":
+		return "DATETIME", 0
+	case "decimal":
+		return fmt.Sprintf("DECIMAL(%d,%d)", complex.Precision, complex.Scale), complex.Scale
+	}
+
+	return avroPrimitive(complex.Type), 0
+}
+
+func avroPrimitive(t string) string {
+
+	switch t {
+	case "int":
+		return "INT"
+	case "long":
+		return "BIGINT"
+	case "float":
+		return "FLOAT"
+	case "double":
+		return "DOUBLE"
+	case "boolean":
+		return "BOOLEAN"
+	default:
+		return "TEXT"
+	}
+}
+
+// unwrapUnion strips goavro's {"branch": value} wrapping of union values.
+func unwrapUnion(v interface{}) interface{} {
+
+	if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+		for _, inner := range m {
+			return inner
+		}
+	}
+	return v
+}
+
+func avroCell(v interface{}, sqlType string, scale int) string {
+
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case bool:
+		if t {
+			return "1"
+		}
+		return "0"
+	case int32:
+		return strconv.FormatInt(int64(t), 10)
+	case int64:
+		return strconv.FormatInt(t, 10)
+	case float32:
+		return strconv.FormatFloat(float64(t), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(t, 'g', -1, 64)
+	case time.Time:
+		if sqlType == "DATE" {
+			return t.UTC().Format("2006-01-02")
+		}
+		return t.UTC().Format("2006-01-02 15:04:05")
+	case *big.Rat:
+		return t.FloatString(scale)
+	case []byte:
+		return string(t)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// BATCH INGESTION /////////////////////
+///////////////////////////////////////////////////////////
+
+// BatchRequest ingests several URLs with shared options. Items name a
+// table per URL; plain URLs use the Table template, where {index},
+// {host} and {file} expand per URL.
+type BatchRequest struct {
+	IngestRequest
+	Items []BatchItem `json:"items"`
+	URLs  []string    `json:"urls"`
+}
+
+type BatchItem struct {
+	URL   string `json:"url"`
+	Table string `json:"table"`
+}
+
+type batchJob struct {
+	URL   string `json:"url"`
+	Table string `json:"table"`
+	JobID string `json:"job_id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// batchWorkers bounds how many batch URLs are fetched at once.
+const batchWorkers = 4
+
+func batchIngestHandler(w http.ResponseWriter, r *http.Request) {
+
+	if rejectWhenBusy(w) {
+		return
+	}
+
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	items := req.Items
+	for _, u := range req.URLs {
+		items = append(items, BatchItem{URL: u})
+	}
+
+	if len(items) == 0 {
+		http.Error(w, "items or urls required", http.StatusBadRequest)
+		return
+	}
+
+	batchID := uuid.New().String()
+
+	db.Exec(`INSERT INTO ingestion_batches (id, total_jobs) VALUES (?, ?)`,
+		batchID, len(items))
+
+	jobs := make([]batchJob, len(items))
+	sem := make(chan struct{}, batchWorkers)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+
+		table := item.Table
+		if table == "" {
+			table = batchTableName(req.Table, item.URL, i)
+		}
+
+		jobs[i] = batchJob{URL: item.URL, Table: table}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			child := req.IngestRequest
+			child.URL = jobs[i].URL
+			child.Table = jobs[i].Table
+			child.BatchID = batchID
+
+			p, err := loadPreview(child)
+			if err != nil {
+				jobs[i].JobID = recordFailedJob(child, err)
+				jobs[i].Error = err.Error()
+				return
+			}
+
+			jobs[i].JobID = startJob(p, child)
+		}(i)
+	}
+
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch_id": batchID,
+		"jobs":     jobs,
+	})
+}
+
+func batchTableName(template, rawURL string, index int) string {
+
+	if template == "" {
+		template = "batch_{index}"
+	}
+
+	host := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		host = strings.Trim(fileTableChars.ReplaceAllString(strings.ToLower(u.Hostname()), "_"), "_")
+	}
+
+	name := strings.ReplaceAll(template, "{index}", strconv.Itoa(index))
+	name = strings.ReplaceAll(name, "{host}", host)
+
+	if strings.Contains(name, "{file}") {
+		name = tableForFile(name, strings.SplitN(rawURL, "?", 2)[0])
+	}
+
+	return name
+}
+
+// batchStatusHandler aggregates the child jobs of a batch. The batch is
+// running while any child runs, then completed, failed (every child
+// failed) or partial.
+func batchStatusHandler(w http.ResponseWriter, r *http.Request) {
+
+	id := r.URL.Query().Get("id")
+
+	rows, err := db.Query(`
+	SELECT id, table_name, total_rows, inserted_rows, status
+	FROM ingestion_jobs
+	WHERE batch_id=?
+	ORDER BY created_at`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	var jobs []map[string]interface{}
+	var total, inserted, running, completed, failed int
+
+	for rows.Next() {
+
+		var jobID, table, status string
+		var t, n int
+		rows.Scan(&jobID, &table, &t, &n, &status)
+
+		total += t
+		inserted += n
+
+		switch status {
+		case "completed":
+			completed++
+		case "failed":
+			failed++
+		default:
+			running++
+		}
+
+		jobs = append(jobs, map[string]interface{}{
+			"job_id":   jobID,
+			"table":    table,
+			"total":    t,
+			"inserted": n,
+			"status":   status,
+		})
+	}
+
+	status := "completed"
+	switch {
+	case len(jobs) == 0:
+		http.Error(w, "batch not found", http.StatusNotFound)
+		return
+	case running > 0:
+		status = "running"
+	case failed == len(jobs):
+		status = "failed"
+	case failed > 0:
+		status = "partial"
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"batch_id":  id,
+		"status":    status,
+		"jobs":      jobs,
+		"running":   running,
+		"completed": completed,
+		"failed":    failed,
+		"total":     total,
+		"inserted":  inserted,
+	})
+}
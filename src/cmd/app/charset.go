@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// CHARSETS ////////////////////////////
+///////////////////////////////////////////////////////////
+
+// textFormats are the formats whose bytes are transcoded to UTF-8 before
+// parsing. Binary formats and JSON/XML (which declare their own encoding)
+// are left alone.
+var textFormats = map[string]bool{
+	"html": true, "csv": true, "tsv": true, "fixed": true, "fixed_width": true,
+}
+
+// sourceEncoding picks the encoding of a text source. An explicit charset
+// on the request wins, then a byte order mark, the Content-Type charset and
+// an HTML <meta> tag. Without any of those, bytes that are not valid UTF-8
+// are read as Windows-1252, the usual encoding of legacy Latin-1 pages.
+func sourceEncoding(body []byte, contentType, label string) (encoding.Encoding, string, error) {
+
+	if label != "" {
+		e, name := charset.Lookup(label)
+		if e == nil {
+			return nil, "", fmt.Errorf("unknown charset %q", label)
+		}
+		return e, name, nil
+	}
+
+	e, name, certain := charset.DetermineEncoding(body, contentType)
+
+	// DetermineEncoding only looks at the first 1KB; an ASCII head with
+	// UTF-8 further down must not be read as Windows-1252.
+	if !certain && utf8.Valid(body) {
+		return encoding.Nop, "utf-8", nil
+	}
+
+	return e, name, nil
+}
+
+// decodeSource transcodes a fetched text source to UTF-8.
+func decodeSource(src *fetchedSource, label string) (*fetchedSource, error) {
+
+	e, name, err := sourceEncoding(src.Body, src.ContentType, label)
+	if err != nil {
+		return nil, err
+	}
+
+	if e == encoding.Nop || name == "utf-8" && utf8.Valid(src.Body) {
+		return src, nil
+	}
+
+	body, _, err := transform.Bytes(e.NewDecoder(), src.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", name, err)
+	}
+
+	fmt.Printf("🔤 Transcoded source from %s to UTF-8\n", name)
+
+	out := *src
+	out.Body = body
+	return &out, nil
+}
+
+// decodeReader is decodeSource for streamed bodies: the encoding is picked
+// from the first 1KB.
+func decodeReader(body io.ReadCloser, contentType, label string) (io.ReadCloser, error) {
+
+	head := make([]byte, 1024)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		body.Close()
+		return nil, err
+	}
+	head = head[:n]
+
+	// Drop a trailing partial rune so a cut multi-byte character does not
+	// make a UTF-8 head look invalid.
+	check := head
+	for i := len(check) - 1; i >= 0 && i > len(check)-4; i-- {
+		if utf8.RuneStart(check[i]) {
+			if !utf8.FullRune(check[i:]) {
+				check = check[:i]
+			}
+			break
+		}
+	}
+
+	e, _, err := sourceEncoding(check, contentType, label)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	r := io.MultiReader(bytes.NewReader(head), body)
+	if e != encoding.Nop {
+		r = transform.NewReader(r, e.NewDecoder())
+	}
+
+	return &stackedCloser{Reader: r, closers: []io.Closer{body}}, nil
+}
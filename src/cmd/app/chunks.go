@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// KAFKA CHUNKS ////////////////////////
+///////////////////////////////////////////////////////////
+
+// A job is published as a schema message (the preview without its rows,
+// plus the number of chunks) followed by that many row messages, each of
+// at most KAFKA_CHUNK_BYTES of rows, so a big table never runs into the
+// broker's message.max.bytes (1 MB by default). The consumer collects a
+// job's chunks, counting them in ingestion_jobs.chunks_received, and
+// inserts the job once the last one is in. A job missing chunks after
+// chunkTimeout fails.
+
+var kafkaChunkBytes = chunkBytesFromEnv()
+
+const chunkTimeout = 10 * time.Minute
+
+func chunkBytesFromEnv() int {
+
+	if n, err := strconv.Atoi(os.Getenv("KAFKA_CHUNK_BYTES")); err == nil && n > 0 {
+		return n
+	}
+
+	// Half the max message leaves room for the envelope.
+	if n, err := strconv.Atoi(os.Getenv("KAFKA_MAX_MESSAGE_BYTES")); err == nil && n > 0 {
+		return n / 2
+	}
+
+	// Base64 grows SQS bodies by a third (sqs.go).
+	if messageBusKind == "sqs" || messageBusKind == "sns" {
+		return 128 << 10
+	}
+
+	return 512 << 10
+}
+
+// chunkRows splits rows into chunks of at most limit bytes of JSON. A
+// row larger than limit gets a chunk of its own.
+func chunkRows(rows [][]string, limit int) [][][]string {
+
+	var chunks [][][]string
+	var chunk [][]string
+	size := 0
+
+	for _, r := range rows {
+		b, _ := json.Marshal(r)
+		if len(chunk) > 0 && size+len(b)+1 > limit {
+			chunks = append(chunks, chunk)
+			chunk, size = nil, 0
+		}
+		chunk = append(chunk, r)
+		size += len(b) + 1
+	}
+
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks
+}
+
+// publishJob queues the schema message of a job and then its rows in
+// chunks on the message bus, keyed by the job's table, in the lane of its
+// priority. payload["preview"]
+// must not carry the rows. It returns once the messages are queued; a
+// message the broker turns down later fails the job.
+func publishJob(jobID string, payload map[string]interface{}, chunks [][][]string) error {
+
+	payload["chunks"] = len(chunks)
+
+	// Encode everything first, so a job is queued whole or not at all.
+	values := make([][]byte, 0, len(chunks)+1)
+
+	b, err := encodeMessage(payload)
+	if err != nil {
+		return fmt.Errorf("publish job: %w", err)
+	}
+	values = append(values, b)
+
+	for i, rows := range chunks {
+		b, err := encodeMessage(map[string]interface{}{
+			"job_id": jobID,
+			"chunk":  i,
+			"rows":   rows,
+		})
+		if err != nil {
+			return fmt.Errorf("publish chunk %d of %d: %w", i+1, len(chunks), err)
+		}
+		values = append(values, b)
+	}
+
+	key, _ := payload["table"].(string)
+
+	return bus.Publish(jobID, laneOf(payloadPriority(payload)), key, values)
+}
+
+// delivery identifies a queued message in the broker's callbacks:
+// message 0 is the schema message, then come the chunks.
+type delivery struct {
+	jobID    string
+	message  int
+	messages int
+}
+
+// pendingJob is a job whose chunks are still arriving. Buses that do not
+// keep order (SQS) may bring chunks before the schema message; until it
+// arrives payload is nil and total 0.
+type pendingJob struct {
+	payload map[string]interface{}
+	total   int
+	chunks  map[int][][]string
+	started time.Time
+	offset  int64 // of the job's first message, where a restart resumes
+}
+
+// assembleJob takes one consumed message. It returns the job's schema
+// message and rows when the job is ready to run: at once for jobs without
+// chunks (streamed, empty, or published before chunking, with the rows in
+// the preview), else with the last of its messages. offset is the
+// message's. Jobs replayed after a restart that had already finished are
+// skipped.
+func assembleJob(pending map[string]*pendingJob, payload map[string]interface{}, offset int64) (map[string]interface{}, [][]string, bool) {
+
+	jobID, _ := payload["job_id"].(string)
+
+	job, ok := pending[jobID]
+	if !ok {
+		if jobFinished(jobID) {
+			return nil, nil, false
+		}
+		job = &pendingJob{chunks: map[int][][]string{}, started: time.Now(), offset: offset}
+	}
+
+	index, chunk := payload["chunk"].(float64)
+	if !chunk {
+		n, _ := payload["chunks"].(float64)
+		if n == 0 {
+			return payload, nil, true
+		}
+		job.payload, job.total = payload, int(n)
+		db.Exec(`UPDATE ingestion_jobs SET chunks_total=? WHERE id=?`, job.total, jobID)
+	} else {
+		i := int(index)
+		if _, dup := job.chunks[i]; i < 0 || dup || (job.total > 0 && i >= job.total) {
+			return nil, nil, false
+		}
+
+		var rows [][]string
+		b, _ := json.Marshal(payload["rows"])
+		json.Unmarshal(b, &rows)
+		job.chunks[i] = rows
+
+		db.Exec(`UPDATE ingestion_jobs SET chunks_received=? WHERE id=?`, len(job.chunks), jobID)
+		of := "?"
+		if job.total > 0 {
+			of = strconv.Itoa(job.total)
+		}
+		fmt.Printf("📦 Job %s: chunk %d/%s\n", jobID, len(job.chunks), of)
+	}
+
+	pending[jobID] = job
+
+	if job.payload == nil || len(job.chunks) < job.total {
+		return nil, nil, false
+	}
+
+	delete(pending, jobID)
+
+	var rows [][]string
+	for i := 0; i < job.total; i++ {
+		rows = append(rows, job.chunks[i]...)
+	}
+
+	return job.payload, rows, true
+}
+
+// expireJobs fails the pending jobs that have waited chunkTimeout for
+// their chunks.
+func expireJobs(pending map[string]*pendingJob) {
+
+	for jobID, job := range pending {
+		if time.Since(job.started) < chunkTimeout {
+			continue
+		}
+		delete(pending, jobID)
+		if job.payload == nil {
+			logJob(jobID, fmt.Sprintf("%d chunks arrived but not the job message", len(job.chunks)))
+		} else {
+			logJob(jobID, fmt.Sprintf("only %d of %d chunks arrived", len(job.chunks), job.total))
+		}
+		db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+	}
+}
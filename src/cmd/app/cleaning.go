@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// CLEANING RULES //////////////////////
+///////////////////////////////////////////////////////////
+
+// CleaningRule is one step of a cell-cleaning pipeline. Rules run in
+// order; a request's cleaning list replaces the built-in cleaning for
+// every column, and column_cleaning replaces it for single columns.
+type CleaningRule struct {
+	Rule    string `json:"rule"`    // see cleaningRules, or "regex_replace"
+	Pattern string `json:"pattern"` // regex_replace only
+	Replace string `json:"replace"` // regex_replace only; $1 expands groups
+}
+
+var cleaningRules = map[string]func(string) string{
+	"trim": strings.TrimSpace,
+	"strip_refs": func(v string) string {
+		// "[citation needed]", "[1]" and everything after it.
+		if i := strings.Index(v, "["); i != -1 {
+			v = v[:i]
+		}
+		return strings.TrimSpace(v)
+	},
+	"strip_currency":      strings.NewReplacer("$", "", "£", "", "€", "").Replace,
+	"strip_commas":        strings.NewReplacer(",", "").Replace,
+	"strip_percent":       strings.NewReplacer("%", "").Replace,
+	"normalize_dash":      strings.NewReplacer("–", "-", "—", "-").Replace,
+	"accounting_negative": accountingNegative,
+	"expand_magnitude":    expandMagnitudeRule,
+	"lowercase":           strings.ToLower,
+	"uppercase":           strings.ToUpper,
+	// default is the built-in cleaning, so custom rules can run before it.
+	"default": cleanValue,
+}
+
+type cleaner func(string) string
+
+// cleaningPipeline holds the cleaner of every column of a table.
+type cleaningPipeline struct {
+	columns []cleaner
+	custom  []bool
+}
+
+func compileCleaning(rules []CleaningRule) (cleaner, error) {
+
+	var steps []cleaner
+
+	for _, r := range rules {
+
+		if r.Rule == "regex_replace" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("regex_replace %q: %w", r.Pattern, err)
+			}
+			replace := r.Replace
+			steps = append(steps, func(v string) string {
+				return re.ReplaceAllString(v, replace)
+			})
+			continue
+		}
+
+		fn, ok := cleaningRules[r.Rule]
+		if !ok {
+			return nil, fmt.Errorf("unknown cleaning rule %q", r.Rule)
+		}
+		steps = append(steps, fn)
+	}
+
+	return func(v string) string {
+		for _, step := range steps {
+			v = step(v)
+		}
+		return v
+	}, nil
+}
+
+// newCleaningPipeline resolves the cleaner for each column. Column names
+// in column_cleaning are matched after normalization.
+func newCleaningPipeline(req IngestRequest, cols []string) (*cleaningPipeline, error) {
+
+	p := &cleaningPipeline{
+		columns: make([]cleaner, len(cols)),
+		custom:  make([]bool, len(cols)),
+	}
+
+	base := cleaner(cleanValue)
+	if req.Cleaning != nil {
+		c, err := compileCleaning(req.Cleaning)
+		if err != nil {
+			return nil, err
+		}
+		base = c
+	}
+
+	perColumn := map[string]cleaner{}
+	for name, rules := range req.ColumnCleaning {
+		c, err := compileCleaning(rules)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		perColumn[renamedColumn(name, req.Rename)] = c
+	}
+
+	for i, name := range cols {
+		p.columns[i] = base
+		p.custom[i] = req.Cleaning != nil
+		if c, ok := perColumn[name]; ok {
+			p.columns[i] = c
+			p.custom[i] = true
+		}
+	}
+
+	return p, nil
+}
+
+func (p *cleaningPipeline) clean(col int, v string) string {
+
+	if col >= len(p.columns) {
+		return cleanValue(v)
+	}
+
+	return p.columns[col](v)
+}
+
+// retype re-infers the columns with custom rules from their cleaned
+// values, since the built-in inference assumes the built-in cleaning.
+func (p *cleaningPipeline) retype(preview Preview, o inferenceOptions) Preview {
+
+	types := inferTypesWith(preview.Columns, preview.Rows, p.clean, o)
+
+	for i, name := range preview.Columns {
+		if p.custom[i] {
+			preview.Types[name] = types[name]
+		}
+	}
+
+	return preview
+}
+
+// cleanStream applies the pipeline to every row on its way to MySQL.
+type cleanStream struct {
+	rowStream
+	pipeline *cleaningPipeline
+}
+
+func (s *cleanStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]string, len(row))
+	for i, v := range row {
+		out[i] = s.pipeline.clean(i, v)
+	}
+
+	return out, nil
+}
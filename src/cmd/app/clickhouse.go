@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// CLICKHOUSE SINK /////////////////////
+///////////////////////////////////////////////////////////
+
+// "sink": "clickhouse" (or INGEST_SINK=clickhouse) writes a job's table
+// to ClickHouse instead of the database, for large time series that are
+// queried analytically. The jobs, logs and schema registry stay in the
+// database; only the rows move.
+//
+// Tables are MergeTree, ordered by their first NOT NULL DATE or
+// DATETIME column so range scans over time read few parts, and typed
+// from the inferred types (clickhouseType). Rows go over the HTTP
+// interface (CLICKHOUSE_URL, e.g. http://clickhouse:8123) as
+// TabSeparated, CLICKHOUSE_BATCH_ROWS (default 50000) per INSERT, each
+// of which ClickHouse writes at once. A batch it refuses fails the job;
+// the batches before stay, and a resumed job continues after them.
+// ClickHouse has no unique keys or transactions, so dedup, mode upsert
+// and a transaction other than none are refused.
+
+const (
+	sinkDatabase   = "database"
+	sinkClickHouse = "clickhouse"
+)
+
+var (
+	defaultSink         = strings.ToLower(os.Getenv("INGEST_SINK"))
+	clickhouseURL       = strings.TrimRight(os.Getenv("CLICKHOUSE_URL"), "/")
+	clickhouseBatchRows = 50000
+)
+
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("CLICKHOUSE_BATCH_ROWS")); err == nil && n > 0 {
+		clickhouseBatchRows = n
+	}
+}
+
+// validSink checks a request's sink, and that it asks nothing of
+// ClickHouse that ClickHouse cannot do.
+func validSink(req IngestRequest) error {
+
+	switch req.Sink {
+	case "", sinkDatabase, sinkClickHouse:
+	default:
+		return fmt.Errorf("unknown sink %q (use database or clickhouse)", req.Sink)
+	}
+
+	if sinkFor(req) != sinkClickHouse {
+		return nil
+	}
+
+	if clickhouseURL == "" {
+		return fmt.Errorf("sink clickhouse needs CLICKHOUSE_URL")
+	}
+	if dedupEnabled(req) || req.Mode == modeUpsert {
+		return fmt.Errorf("dedup and mode upsert need a unique key, which sink clickhouse does not have")
+	}
+	if req.Transaction != "" && req.Transaction != txNone {
+		return fmt.Errorf("transaction %s is not available with sink clickhouse", req.Transaction)
+	}
+
+	return nil
+}
+
+// sinkFor returns where the request's rows are written.
+func sinkFor(req IngestRequest) string {
+
+	sink := req.Sink
+	if sink == "" {
+		sink = defaultSink
+	}
+	if sink != sinkClickHouse {
+		return sinkDatabase
+	}
+
+	return sink
+}
+
+var clickhouseDecimal = regexp.MustCompile(`^(?:DECIMAL|NUMERIC)(?:\((\d+)(?:,(\d+))?\))?$`)
+
+// clickhouseType maps an inferred or requested SQL type to ClickHouse.
+// Integer sizes, decimal precision and dates keep their range; strings,
+// JSON and times become String, and ENUM columns LowCardinality(String),
+// whose values need no declaring.
+func clickhouseType(t string, notNull bool) string {
+
+	t = strings.ToUpper(strings.TrimSpace(t))
+	unsigned := strings.HasSuffix(t, " UNSIGNED")
+	t = strings.TrimSuffix(t, " UNSIGNED")
+
+	var ch string
+	switch {
+	case intDigits[t] > 0:
+		bits := map[string]string{"TINYINT": "8", "SMALLINT": "16", "MEDIUMINT": "32", "INT": "32", "INTEGER": "32", "BIGINT": "64"}[t]
+		ch = "Int" + bits
+		if unsigned {
+			ch = "UInt" + bits
+		}
+	case clickhouseDecimal.MatchString(t):
+		m := clickhouseDecimal.FindStringSubmatch(t)
+		precision, scale := m[1], m[2]
+		if precision == "" {
+			precision = "10"
+		}
+		if scale == "" {
+			scale = "0"
+		}
+		ch = fmt.Sprintf("Decimal(%s,%s)", precision, scale)
+	case t == "FLOAT":
+		ch = "Float32"
+	case t == "DOUBLE":
+		ch = "Float64"
+	case t == "BOOLEAN" || t == "BOOL":
+		ch = "Bool"
+	case t == "DATE":
+		ch = "Date32"
+	case t == "DATETIME" || t == "TIMESTAMP":
+		ch = "DateTime64(3)"
+	case t == "YEAR":
+		ch = "UInt16"
+	case strings.HasPrefix(t, "ENUM("):
+		if notNull {
+			return "LowCardinality(String)"
+		}
+		return "LowCardinality(Nullable(String))"
+	default:
+		ch = "String"
+	}
+
+	if notNull {
+		return ch
+	}
+
+	return "Nullable(" + ch + ")"
+}
+
+// clickhouseCreate returns the CREATE TABLE statement of p's table.
+func clickhouseCreate(table string, p Preview) string {
+
+	notNull := map[string]bool{}
+	for _, c := range p.NotNull {
+		notNull[c] = true
+	}
+
+	cols := make([]string, len(p.Columns))
+	order := "tuple()"
+	for i, c := range p.Columns {
+		cols[i] = fmt.Sprintf("%s %s", c, clickhouseType(p.Types[c], notNull[c]))
+		t := strings.ToUpper(p.Types[c])
+		if order == "tuple()" && notNull[c] && (t == "DATE" || t == "DATETIME" || t == "TIMESTAMP") {
+			order = c
+		}
+	}
+
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s) ENGINE = MergeTree ORDER BY %s",
+		table, strings.Join(cols, ", "), order)
+}
+
+// clickhouseExec sends one statement, with body as its data for an
+// INSERT.
+func clickhouseExec(query string, body io.Reader) error {
+
+	params := url.Values{}
+	params.Set("query", query)
+	if name := os.Getenv("CLICKHOUSE_DATABASE"); name != "" {
+		params.Set("database", name)
+	}
+	params.Set("date_time_input_format", "best_effort")
+
+	req, err := http.NewRequest("POST", clickhouseURL+"/?"+params.Encode(), body)
+	if err != nil {
+		return err
+	}
+	if user := os.Getenv("CLICKHOUSE_USER"); user != "" {
+		req.Header.Set("X-ClickHouse-User", user)
+		req.Header.Set("X-ClickHouse-Key", os.Getenv("CLICKHOUSE_PASSWORD"))
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("clickhouse: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("clickhouse: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+var tsvEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// insertClickHouse writes a job's rows to ClickHouse, as insertRows
+// does to the database.
+func insertClickHouse(p Preview, rows rowStream, table, mode, jobID string) bool {
+
+	defer rows.Close()
+
+	fmt.Printf("📊 Starting ClickHouse ingestion for table '%s' (mode: %s, rows: %d)\n", table, mode, len(p.Rows))
+
+	resume, inserted := jobCheckpoint(jobID)
+	if resume > 0 {
+		fmt.Printf("↩️  Resuming job %s after row %d\n", jobID, resume)
+	}
+
+	seen := 0
+
+	fail := func(err error) bool {
+		fmt.Printf("❌ ClickHouse ingestion of '%s' failed: %v\n", table, err)
+		logJob(jobID, err.Error())
+		db.Exec(`
+		UPDATE ingestion_jobs
+		SET inserted_rows=?, total_rows=?, status='failed'
+		WHERE id=?`,
+			inserted, seen, jobID)
+		return false
+	}
+
+	if mode == "create" && resume == 0 {
+		if err := clickhouseExec("DROP TABLE IF EXISTS "+table, nil); err != nil {
+			return fail(err)
+		}
+		fmt.Printf("🗑️  Dropped existing ClickHouse table '%s'\n", table)
+	}
+
+	if err := clickhouseExec(clickhouseCreate(table, p), nil); err != nil {
+		return fail(err)
+	}
+
+	fmt.Printf("✓ Created ClickHouse table schema\n")
+
+	var batch bytes.Buffer
+	n := 0 // rows in batch
+
+	// flush sends the batch as one INSERT. Rows narrower than the table
+	// leave the last columns NULL.
+	flush := func() error {
+
+		if n == 0 {
+			return nil
+		}
+
+		query := fmt.Sprintf("INSERT INTO %s (%s) FORMAT TabSeparated", table, strings.Join(p.Columns, ","))
+		if err := clickhouseExec(query, &batch); err != nil {
+			return fmt.Errorf("rows %d to %d: %w", seen-n+1, seen, err)
+		}
+
+		inserted += n
+		batch.Reset()
+		n = 0
+
+		db.Exec(`
+		UPDATE ingestion_jobs
+		SET inserted_rows=?, total_rows=GREATEST(total_rows, ?), checkpoint_rows=?
+		WHERE id=?`,
+			inserted, seen, seen, jobID)
+		fmt.Printf("📝 Progress: %d/%d rows inserted\n", inserted, seen)
+
+		return nil
+	}
+
+	for {
+
+		r, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if err := flush(); err != nil {
+				return fail(err)
+			}
+			return fail(fmt.Errorf("reading source rows: %w", err))
+		}
+
+		seen++
+		if seen <= resume {
+			continue
+		}
+
+		for i := range p.Columns {
+			if i > 0 {
+				batch.WriteByte('\t')
+			}
+			if i >= len(r) || r[i] == "" {
+				batch.WriteString(`\N`)
+				continue
+			}
+			tsvEscaper.WriteString(&batch, r[i])
+		}
+		batch.WriteByte('\n')
+		n++
+
+		if n >= clickhouseBatchRows {
+			if err := flush(); err != nil {
+				return fail(err)
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return fail(err)
+	}
+
+	db.Exec(`
+	UPDATE ingestion_jobs
+	SET inserted_rows=?, total_rows=?, status='completed'
+	WHERE id=?`,
+		inserted, seen, jobID)
+
+	fmt.Printf("✅ ClickHouse ingestion complete: %d inserted\n", inserted)
+
+	return true
+}
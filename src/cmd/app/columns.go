@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// COLUMN SELECTION ////////////////////
+///////////////////////////////////////////////////////////
+
+// columnSelection returns the indexes of the columns to keep, or nil to
+// keep them all. Names are matched after normalization, so "Last Price"
+// selects last_price. columns keeps its own order; exclude_columns drops.
+func columnSelection(cols []string, req IngestRequest) ([]int, error) {
+
+	if len(req.Columns) == 0 && len(req.ExcludeColumns) == 0 {
+		return nil, nil
+	}
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	var keep []int
+
+	if len(req.Columns) > 0 {
+		for _, name := range normalizeColumns(req.Columns) {
+			i, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown column %q (available: %s)", name, strings.Join(cols, ", "))
+			}
+			keep = append(keep, i)
+		}
+	} else {
+		for i := range cols {
+			keep = append(keep, i)
+		}
+	}
+
+	if len(req.ExcludeColumns) > 0 {
+		drop := map[int]bool{}
+		for _, name := range normalizeColumns(req.ExcludeColumns) {
+			if i, ok := index[name]; ok {
+				drop[i] = true
+			}
+		}
+
+		var kept []int
+		for _, i := range keep {
+			if !drop[i] {
+				kept = append(kept, i)
+			}
+		}
+		keep = kept
+	}
+
+	if len(keep) == 0 {
+		return nil, fmt.Errorf("column selection leaves no columns")
+	}
+
+	return keep, nil
+}
+
+// selectColumns narrows a preview to the requested columns.
+func selectColumns(p Preview, req IngestRequest) (Preview, error) {
+
+	keep, err := columnSelection(p.Columns, req)
+	if err != nil || keep == nil {
+		return p, err
+	}
+
+	cols := make([]string, len(keep))
+	types := map[string]string{}
+	for j, i := range keep {
+		cols[j] = p.Columns[i]
+		types[cols[j]] = p.Types[cols[j]]
+	}
+
+	for r, row := range p.Rows {
+		p.Rows[r] = projectRow(row, keep)
+	}
+
+	p.Columns = cols
+	p.Types = types
+
+	return p, nil
+}
+
+// renameColumns applies a request's rename map, detected name -> chosen
+// name. Both sides are normalized, so {"Net Income 2": "Net Income Adj"}
+// turns net_income_2 into net_income_adj.
+func renameColumns(p Preview, rename map[string]string) (Preview, error) {
+
+	if len(rename) == 0 {
+		return p, nil
+	}
+
+	for from := range rename {
+		col := normalizeColumns([]string{from})[0]
+		if _, ok := p.Types[col]; !ok {
+			return Preview{}, fmt.Errorf("rename: unknown column %q (available: %s)", col, strings.Join(p.Columns, ", "))
+		}
+	}
+
+	cols := make([]string, len(p.Columns))
+	types := map[string]string{}
+	seen := map[string]bool{}
+
+	for i, c := range p.Columns {
+		cols[i] = renamedColumn(c, rename)
+		if seen[cols[i]] {
+			return Preview{}, fmt.Errorf("rename: two columns would be named %s", cols[i])
+		}
+		seen[cols[i]] = true
+		types[cols[i]] = p.Types[c]
+	}
+
+	p.Columns = cols
+	p.Types = types
+
+	return p, nil
+}
+
+// renamedColumn is the table column a request option's column name
+// refers to: its rename target when it was renamed, itself otherwise.
+func renamedColumn(name string, rename map[string]string) string {
+
+	col := normalizeColumns([]string{name})[0]
+
+	for from, to := range rename {
+		if normalizeColumns([]string{from})[0] == col {
+			return normalizeColumns([]string{to})[0]
+		}
+	}
+
+	return col
+}
+
+func projectRow(row []string, keep []int) []string {
+
+	out := make([]string, len(keep))
+	for j, i := range keep {
+		if i < len(row) {
+			out[j] = row[i]
+		}
+	}
+
+	return out
+}
+
+// projectStream applies a column selection to rows the consumer streams
+// straight from the source.
+type projectStream struct {
+	rowStream
+	keep []int
+}
+
+func (s *projectStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return projectRow(row, s.keep), nil
+}
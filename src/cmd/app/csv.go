@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// CSV SOURCE //////////////////////////
+///////////////////////////////////////////////////////////
+
+// gridLayout says where the header of a grid source is: skip non-blank
+// records come first, then the header. When names are supplied the
+// source has no header row and every remaining record is data.
+// Transpose pivots the records after the skip, so the header is the
+// first column.
+// Scan, when positive, looks for the header among that many records
+// after the skip instead of taking the first one.
+type gridLayout struct {
+	Skip      int
+	Names     []string
+	Transpose bool
+	Scan      int
+}
+
+// parseCSV reads a delimited document laid out as layout describes.
+// Rows may be ragged; the consumer already tolerates short rows.
+func parseCSV(body []byte, delim rune, layout gridLayout) (Preview, error) {
+
+	if layout.Transpose {
+		// A pivot needs every record, so the stream is not used.
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))))
+		r.Comma = delim
+		r.FieldsPerRecord = -1
+		r.LazyQuotes = true
+
+		records, err := r.ReadAll()
+		if err != nil {
+			return Preview{}, fmt.Errorf("failed to parse CSV: %w", err)
+		}
+
+		cols, rows := splitHeader(records, layout)
+		return buildPreview(cols, rows)
+	}
+
+	cols, stream, err := openCSVStream(io.NopCloser(bytes.NewReader(body)), delim, layout)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	rows, err := drainRows(stream, 0)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	return buildPreview(cols, rows)
+}
+
+// csvStream yields trimmed, non-blank records after the header.
+type csvStream struct {
+	r       *csv.Reader
+	body    io.Closer
+	pending [][]string // records read while looking for the header that are data
+}
+
+// openCSVStream consumes the banner records and the header record and
+// returns a stream over the remaining rows, so large objects can be
+// inserted without buffering.
+func openCSVStream(body io.ReadCloser, delim rune, layout gridLayout) ([]string, *csvStream, error) {
+
+	br := bufio.NewReader(body)
+
+	// Strip a UTF-8 BOM so it does not end up in the first column name.
+	if bom, _ := br.Peek(3); bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+
+	r := csv.NewReader(br)
+	r.Comma = delim
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	s := &csvStream{r: r, body: body}
+
+	for i := 0; i < layout.Skip; i++ {
+		if _, err := s.Next(); err != nil {
+			break
+		}
+	}
+
+	// Buffer enough records to find the header and see the data below it.
+	var head [][]string
+	for len(head) < max(1, 2*layout.Scan) {
+		rec, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			body.Close()
+			return nil, nil, err
+		}
+		head = append(head, rec)
+	}
+
+	if len(head) == 0 {
+		body.Close()
+		return nil, nil, fmt.Errorf("no columns found in table")
+	}
+
+	if layout.Names != nil {
+		s.pending = head
+		return namedColumns(layout.Names, len(head[0])), s, nil
+	}
+
+	if k := detectHeaderRow(head, nil, layout.Scan); k > 0 {
+		fmt.Printf("🔎 Header detected at row %d\n", k)
+		head = head[k:]
+	}
+
+	s.pending = head[1:]
+	return head[0], s, nil
+}
+
+func (s *csvStream) Next() ([]string, error) {
+
+	if len(s.pending) > 0 {
+		rec := s.pending[0]
+		s.pending = s.pending[1:]
+		return rec, nil
+	}
+
+	for {
+		rec, err := s.r.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse CSV: %w", err)
+		}
+
+		if isBlankRecord(rec) {
+			continue
+		}
+
+		for i := range rec {
+			rec[i] = strings.TrimSpace(rec[i])
+		}
+
+		return rec, nil
+	}
+}
+
+func (s *csvStream) Close() error {
+	return s.body.Close()
+}
+
+// splitHeader drops the banner records, takes the header as layout says
+// and returns the remaining non-blank records as data, trimming every cell.
+func splitHeader(records [][]string, layout gridLayout) ([]string, [][]string) {
+
+	skip := layout.Skip
+
+	if layout.Transpose {
+		var kept [][]string
+		for _, rec := range records {
+			if isBlankRecord(rec) {
+				continue
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			kept = append(kept, rec)
+		}
+		records = transposeRecords(kept)
+	}
+
+	var cols []string
+	var rows [][]string
+
+	for _, rec := range records {
+
+		if isBlankRecord(rec) {
+			continue
+		}
+
+		for i := range rec {
+			rec[i] = strings.TrimSpace(rec[i])
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		rows = append(rows, rec)
+	}
+
+	if layout.Names == nil && len(rows) > 0 {
+		if k := detectHeaderRow(rows, nil, layout.Scan); k > 0 {
+			fmt.Printf("🔎 Header detected at row %d\n", k)
+			rows = rows[k:]
+		}
+		cols, rows = rows[0], rows[1:]
+	}
+
+	if layout.Names != nil && len(rows) > 0 {
+		cols = namedColumns(layout.Names, len(rows[0]))
+	}
+
+	return cols, rows
+}
+
+// namedColumns pads user-supplied names to width; the unnamed columns
+// get generated col_N names during normalization.
+func namedColumns(names []string, width int) []string {
+
+	cols := append([]string{}, names...)
+	for len(cols) < width {
+		cols = append(cols, "")
+	}
+
+	return cols
+}
+
+func isBlankRecord(rec []string) bool {
+
+	for _, v := range rec {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
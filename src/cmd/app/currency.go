@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// CURRENCY ////////////////////////////
+///////////////////////////////////////////////////////////
+
+// Money columns ("$1,200.50", "EUR 99", "£3.10") are stored as DECIMAL
+// amounts. With currency_columns the code found in each cell is kept in
+// a <col>_currency column next to the amount.
+
+// currencySymbols is checked in order, so prefixed dollars come before "$".
+var currencySymbols = []struct{ Symbol, Code string }{
+	{"US$", "USD"}, {"HK$", "HKD"}, {"C$", "CAD"}, {"A$", "AUD"},
+	{"NZ$", "NZD"}, {"S$", "SGD"}, {"R$", "BRL"}, {"$", "USD"},
+	{"£", "GBP"}, {"€", "EUR"}, {"¥", "JPY"}, {"₹", "INR"},
+	{"₩", "KRW"}, {"₽", "RUB"}, {"₺", "TRY"}, {"₪", "ILS"},
+}
+
+var currencyCodes = map[string]bool{
+	"USD": true, "EUR": true, "GBP": true, "JPY": true, "CHF": true,
+	"CAD": true, "AUD": true, "NZD": true, "CNY": true, "HKD": true,
+	"SGD": true, "INR": true, "KRW": true, "BRL": true, "MXN": true,
+	"ZAR": true, "SEK": true, "NOK": true, "DKK": true, "PLN": true,
+	"RUB": true, "TRY": true, "ILS": true,
+}
+
+var moneyAmount = regexp.MustCompile(`^[-+]?\d[\d,]*(\.(\d+))?$`)
+
+// parseMoney splits a cell into a plain amount and the currency code its
+// symbol or ISO code names (empty for a bare number).
+func parseMoney(v string) (amount, code string, ok bool) {
+
+	v = strings.TrimSpace(v)
+
+	sign := ""
+	if inner, ok := strings.CutPrefix(v, "("); ok && strings.HasSuffix(inner, ")") {
+		// Accounting negative: "($1,234.50)".
+		sign, v = "-", strings.TrimSpace(strings.TrimSuffix(inner, ")"))
+	} else if strings.HasPrefix(v, "-") || strings.HasPrefix(v, "+") {
+		sign, v = v[:1], strings.TrimSpace(v[1:])
+	}
+
+	for _, s := range currencySymbols {
+		if rest, found := strings.CutPrefix(v, s.Symbol); found {
+			v, code = rest, s.Code
+			break
+		}
+		if rest, found := strings.CutSuffix(v, s.Symbol); found {
+			v, code = rest, s.Code
+			break
+		}
+	}
+
+	if code == "" {
+		if fields := strings.Fields(v); len(fields) == 2 {
+			switch {
+			case currencyCodes[fields[0]]:
+				code, v = fields[0], fields[1]
+			case currencyCodes[fields[1]]:
+				code, v = fields[1], fields[0]
+			}
+		}
+	}
+
+	v = strings.TrimSpace(v)
+	if sign == "" && (strings.HasPrefix(v, "-") || strings.HasPrefix(v, "+")) {
+		sign, v = v[:1], v[1:]
+	}
+
+	if !moneyAmount.MatchString(v) {
+		return "", "", false
+	}
+
+	if sign == "+" {
+		sign = ""
+	}
+
+	return sign + strings.ReplaceAll(v, ",", ""), code, true
+}
+
+// detectCurrencies turns columns where most cells are money, and some
+// carry a currency marker, into DECIMAL amounts.
+func detectCurrencies(p Preview, withCodes bool) Preview {
+
+	var cols []string
+	var money []int
+	scales := map[int]int{}
+
+	for c, name := range p.Columns {
+
+		total, parsed, marked, scale := 0, 0, 0, 2
+
+		for _, r := range p.Rows {
+			if c >= len(r) || strings.TrimSpace(r[c]) == "" {
+				continue
+			}
+			total++
+
+			amount, code, ok := parseMoney(r[c])
+			if !ok {
+				continue
+			}
+			parsed++
+			if code != "" {
+				marked++
+			}
+			if m := moneyAmount.FindStringSubmatch(amount); len(m[2]) > scale {
+				scale = min(len(m[2]), 10)
+			}
+		}
+
+		if marked > 0 && float64(parsed) >= float64(total)*0.8 {
+			money = append(money, c)
+			scales[c] = scale
+		}
+
+		cols = append(cols, name)
+	}
+
+	if len(money) == 0 {
+		return p
+	}
+
+	isMoney := map[int]bool{}
+	for _, c := range money {
+		isMoney[c] = true
+		p.Types[p.Columns[c]] = fmt.Sprintf("DECIMAL(20,%d)", scales[c])
+	}
+
+	if withCodes {
+		cols = nil
+		for c, name := range p.Columns {
+			cols = append(cols, name)
+			if isMoney[c] {
+				cols = append(cols, name+"_currency")
+				p.Types[name+"_currency"] = "VARCHAR(3)"
+			}
+		}
+	}
+
+	for i, r := range p.Rows {
+		var out []string
+		for c, v := range r {
+			code := ""
+			if isMoney[c] {
+				if amount, cur, ok := parseMoney(v); ok {
+					v, code = amount, cur
+				}
+			}
+			out = append(out, v)
+			if withCodes && isMoney[c] {
+				out = append(out, code)
+			}
+		}
+		p.Rows[i] = alignRow(out, len(cols))
+	}
+
+	p.Columns = cols
+
+	fmt.Printf("✓ Stored %d money columns as DECIMAL\n", len(money))
+
+	return p
+}
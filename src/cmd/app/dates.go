@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// DATE ORDER //////////////////////////
+///////////////////////////////////////////////////////////
+
+// 02/03/2024 reads as 2 March day-first and 3 February month-first. Each
+// DATE column settles on one layout: the one that parses the most of its
+// values, so a single 13/02/2024 or 02/13/2024 decides the whole column.
+// Columns that never disambiguate read day-first unless date_order says
+// otherwise. The chosen layout rewrites values to ISO 2006-01-02.
+const (
+	dayFirstLayout   = "2/1/2006"
+	monthFirstLayout = "1/2/2006"
+)
+
+func validDateOrder(order string) error {
+
+	switch order {
+	case "", "auto", "dmy", "mdy":
+		return nil
+	default:
+		return fmt.Errorf("unknown date_order %q (use auto, dmy or mdy)", order)
+	}
+}
+
+// columnDateLayout picks the layout for a column's values. An explicit
+// order rules out the other slash layout.
+func columnDateLayout(values []string, order string) string {
+
+	best, bestN := "", 0
+
+	for _, l := range dateLayouts {
+
+		if (order == "dmy" && l == monthFirstLayout) || (order == "mdy" && l == dayFirstLayout) {
+			continue
+		}
+
+		n := 0
+		for _, v := range values {
+			if _, err := time.Parse(l, v); err == nil {
+				n++
+			}
+		}
+
+		if n > bestN {
+			best, bestN = l, n
+		}
+	}
+
+	return best
+}
+
+// normalizeDates picks a layout for every DATE column and rewrites its
+// values to ISO dates. The layouts travel with the preview so the
+// consumer can convert streamed rows the same way.
+func normalizeDates(p Preview, order string) Preview {
+
+	for c, name := range p.Columns {
+
+		if p.Types[name] != "DATE" {
+			continue
+		}
+
+		var values []string
+		for _, r := range p.Rows {
+			if c < len(r) && r[c] != "" {
+				values = append(values, strings.TrimSpace(r[c]))
+			}
+		}
+
+		layout := columnDateLayout(values, order)
+		if layout == "" {
+			continue
+		}
+
+		if p.DateLayouts == nil {
+			p.DateLayouts = map[string]string{}
+		}
+		p.DateLayouts[name] = layout
+
+		for _, r := range p.Rows {
+			if c < len(r) {
+				r[c] = isoDate(r[c], layout)
+			}
+		}
+	}
+
+	return p
+}
+
+// isoDate rewrites v from layout to 2006-01-02. Values in another
+// supported layout are read with that one; values that do not parse are
+// left untouched.
+func isoDate(v, layout string) string {
+
+	t, err := time.Parse(layout, strings.TrimSpace(v))
+	if err != nil {
+		var ok bool
+		if t, _, ok = parseDateTime(v); !ok {
+			return v
+		}
+	}
+
+	return t.Format("2006-01-02")
+}
+
+// isDateTimeType reports whether t holds a date and a time.
+func isDateTimeType(t string) bool {
+
+	t = strings.ToUpper(t)
+	return t == "DATETIME" || t == "TIMESTAMP"
+}
+
+// dateStream converts the DATE and DATETIME columns of rows on their way
+// to MySQL, by the final column types: a column typed DATE by types or
+// the registered schema after the preview is converted too. Values
+// already in MySQL format pass unchanged.
+type dateStream struct {
+	rowStream
+	layouts   map[int]string
+	datetimes []int
+	loc       *time.Location
+}
+
+func newDateStream(rows rowStream, p Preview, loc *time.Location) rowStream {
+
+	s := &dateStream{rowStream: rows, layouts: map[int]string{}, loc: loc}
+
+	for c, name := range p.Columns {
+		switch {
+		case strings.ToUpper(p.Types[name]) == "DATE":
+			// Columns without a layout are read with any of them.
+			s.layouts[c] = p.DateLayouts[name]
+		case isDateTimeType(p.Types[name]):
+			s.datetimes = append(s.datetimes, c)
+		}
+	}
+
+	if len(s.layouts) == 0 && len(s.datetimes) == 0 {
+		return rows
+	}
+
+	return s
+}
+
+func (s *dateStream) Next() ([]string, error) {
+
+	r, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	for c, l := range s.layouts {
+		if c < len(r) && r[c] != "" {
+			r[c] = isoDate(r[c], l)
+		}
+	}
+
+	for _, c := range s.datetimes {
+		if c < len(r) && r[c] != "" {
+			r[c], _ = convertDateTime(r[c], s.loc)
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// DECIMAL INFERENCE ///////////////////
+///////////////////////////////////////////////////////////
+
+// FLOAT cannot hold money exactly. A fractional column becomes
+// DECIMAL(p,s) when its raw values carry a currency marker or when most
+// of them share one number of decimal places (prices, rates, balances).
+// Columns with varying precision, like measurements, stay FLOAT.
+
+// plainDecimal splits a cleaned number into its integer and fraction digits.
+var plainDecimal = regexp.MustCompile(`^[-+]?(\d*)(?:\.(\d*))?$`)
+
+// maxDecimalScale keeps long fractions (ratios, coordinates) as FLOAT.
+const maxDecimalScale = 8
+
+// decimalStats collects the digit counts of one column's numeric values.
+type decimalStats struct {
+	intDigits  int
+	scales     map[int]int // decimal places -> values with that many
+	fractional int
+	marked     bool
+	plain      bool // false once a value is not a plain decimal (1e5)
+	scientific bool // a raw value was in exponent notation
+}
+
+func newDecimalStats() *decimalStats {
+	return &decimalStats{scales: map[int]int{}, plain: true}
+}
+
+// add records a value that parsed as a number: raw as the source had it,
+// val after cleaning.
+func (d *decimalStats) add(raw, val string) {
+
+	if isScientific(strings.TrimSpace(raw)) {
+		d.scientific = true
+	}
+
+	m := plainDecimal.FindStringSubmatch(val)
+	if m == nil {
+		d.plain = false
+		return
+	}
+
+	d.intDigits = max(d.intDigits, len(strings.TrimLeft(m[1], "0")))
+
+	if len(m[2]) > 0 {
+		d.scales[len(m[2])]++
+		d.fractional++
+	}
+
+	if hasCurrencyMarker(raw) {
+		d.marked = true
+	}
+}
+
+// decimalType returns the DECIMAL type for the column, or false when it
+// should stay FLOAT. Precision keeps two spare integer digits and is at
+// least 10, so later loads of slightly larger amounts still fit.
+func (d *decimalStats) decimalType() (string, bool) {
+
+	if !d.plain || d.scientific || d.intDigits > maxExpandedDigits-2 {
+		return "", false
+	}
+
+	if d.fractional == 0 {
+		// Whole numbers only reach here when they overflow BIGINT.
+		if d.intDigits < 19 {
+			return "", false
+		}
+		return fmt.Sprintf("DECIMAL(%d,0)", min(d.intDigits+2, 65)), true
+	}
+
+	scale := 0
+	for s := range d.scales {
+		scale = max(scale, s)
+	}
+
+	if scale > maxDecimalScale {
+		return "", false
+	}
+
+	consistent := float64(d.scales[scale]) >= float64(d.fractional)*0.8
+	if !consistent && !d.marked {
+		return "", false
+	}
+
+	precision := min(max(d.intDigits+2+scale, 10), 65)
+
+	return fmt.Sprintf("DECIMAL(%d,%d)", precision, scale), true
+}
+
+// floatType is FLOAT, or DOUBLE for columns written in exponent notation
+// (measurements spanning magnitudes) or beyond FLOAT's 3.4e38.
+func (d *decimalStats) floatType() string {
+
+	if d.scientific || !d.plain || d.intDigits > 38 {
+		return "DOUBLE"
+	}
+
+	return "FLOAT"
+}
+
+var decimalPattern = regexp.MustCompile(`^(?i)DECIMAL\((\d+),\s*(\d+)\)$`)
+
+// widerDecimal returns the DECIMAL holding both cur and next, or false
+// when cur already does or either is not a DECIMAL(p,s).
+func widerDecimal(cur, next string) (string, bool) {
+
+	c := decimalPattern.FindStringSubmatch(strings.TrimSpace(cur))
+	n := decimalPattern.FindStringSubmatch(strings.TrimSpace(next))
+	if c == nil || n == nil {
+		return "", false
+	}
+
+	cp, _ := strconv.Atoi(c[1])
+	cs, _ := strconv.Atoi(c[2])
+	np, _ := strconv.Atoi(n[1])
+	ns, _ := strconv.Atoi(n[2])
+
+	scale := max(cs, ns)
+	precision := max(cp-cs, np-ns) + scale
+
+	if precision == cp && scale == cs {
+		return "", false
+	}
+
+	return fmt.Sprintf("DECIMAL(%d,%d)", min(precision, 65), min(scale, 30)), true
+}
+
+// hasCurrencyMarker reports whether a raw cell is an amount with a
+// currency symbol or ISO code.
+func hasCurrencyMarker(raw string) bool {
+
+	_, code, ok := parseMoney(raw)
+	return ok && code != ""
+}
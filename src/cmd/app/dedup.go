@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// DEDUPLICATION ///////////////////////
+///////////////////////////////////////////////////////////
+
+// Rows are identified by dedup_keys (all columns when empty). The table
+// gets a hidden _dedup_key column holding the SHA-256 of those cells
+// under a UNIQUE index, so keys stay unique across jobs. Strategies:
+//
+//	keep_first     within the job the first row of a key wins (default)
+//	keep_last      within the job the last row of a key wins
+//	skip_existing  rows whose key is already in the table are skipped
+//
+// keep_first and keep_last replace table rows that share a key with the
+// job's row; skip_existing leaves them alone. Rows written before dedup
+// was enabled have no key and never match.
+const dedupKeyColumn = "_dedup_key"
+
+var dedupStrategies = map[string]bool{"": true, "keep_first": true, "keep_last": true, "skip_existing": true}
+
+func validDedupStrategy(strategy string) error {
+
+	if !dedupStrategies[strategy] {
+		return fmt.Errorf("unknown dedup_strategy %q (use keep_first, keep_last or skip_existing)", strategy)
+	}
+
+	return nil
+}
+
+// dedupEnabled reports whether req asks for deduplication at all.
+func dedupEnabled(req IngestRequest) bool {
+	return req.Dedup || len(req.DedupKeys) > 0 || req.DedupStrategy != ""
+}
+
+// dedupKeyColumns resolves dedup_keys against the table's columns.
+// Names may be the detected name of a renamed column.
+func dedupKeyColumns(req IngestRequest, cols []string) ([]int, error) {
+
+	if len(req.DedupKeys) == 0 {
+		all := make([]int, len(cols))
+		for i := range all {
+			all[i] = i
+		}
+		return all, nil
+	}
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	var keys []int
+	for _, name := range req.DedupKeys {
+		col := renamedColumn(name, req.Rename)
+		i, ok := index[col]
+		if !ok {
+			return nil, fmt.Errorf("dedup_keys: unknown column %q (available: %s)", col, strings.Join(cols, ", "))
+		}
+		keys = append(keys, i)
+	}
+
+	return keys, nil
+}
+
+// dedupPolicy drops a job's duplicate rows and keys the rest.
+type dedupPolicy struct {
+	width    int
+	keys     []int
+	strategy string
+	seen     map[[32]byte]bool
+	dropped  int // rows not written because their key repeated
+}
+
+// newDedupPolicy returns the policy of req, or nil without dedup.
+func newDedupPolicy(req IngestRequest, cols []string) (*dedupPolicy, error) {
+
+	if !dedupEnabled(req) {
+		return nil, nil
+	}
+
+	if err := validDedupStrategy(req.DedupStrategy); err != nil {
+		return nil, err
+	}
+
+	keys, err := dedupKeyColumns(req, cols)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy := req.DedupStrategy
+	if strategy == "" {
+		strategy = "keep_first"
+	}
+
+	return &dedupPolicy{width: len(cols), keys: keys, strategy: strategy, seen: map[[32]byte]bool{}}, nil
+}
+
+// upsert reports whether the job's rows replace table rows with the same
+// key, rather than being skipped.
+func (d *dedupPolicy) upsert() bool {
+	return d.strategy != "skip_existing"
+}
+
+// admit returns row with its key appended, or nil when the row repeats
+// a key the job has already written and the strategy drops it.
+func (d *dedupPolicy) admit(row []string) []string {
+
+	row = alignRow(row[:len(row):len(row)], d.width)
+
+	h := sha256.New()
+	for _, k := range d.keys {
+		h.Write([]byte(row[k]))
+		h.Write([]byte{0x1f})
+	}
+
+	var key [32]byte
+	copy(key[:], h.Sum(nil))
+
+	if d.seen[key] {
+		d.dropped++
+		// keep_last writes the row anyway; the upsert replaces the
+		// earlier one.
+		if d.strategy != "keep_last" {
+			return nil
+		}
+	}
+	d.seen[key] = true
+
+	return append(row, hex.EncodeToString(key[:]))
+}
+
+// ensureDedupKey adds the key column and its UNIQUE index to table. Both
+// fail harmlessly when they already exist.
+func ensureDedupKey(table string) {
+	db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s CHAR(64)", table, dedupKeyColumn))
+	db.Exec(fmt.Sprintf("ALTER TABLE %s ADD UNIQUE KEY %s (%s)", table, dedupKeyColumn, dedupKeyColumn))
+}
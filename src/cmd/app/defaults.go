@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// DEFAULT VALUES //////////////////////
+///////////////////////////////////////////////////////////
+
+// defaults fills a column's empty cells with a value instead of NULL,
+// e.g. {"quantity": "0", "region": "unknown", "as_of": "CURRENT_DATE"}.
+// CURRENT_DATE and CURRENT_TIMESTAMP (or NOW()) are the job's start time
+// in the request's timezone. A default must fit its column: a number for
+// numeric columns, a date for date columns. ENUM and VARCHAR columns grow
+// to hold it.
+
+// columnDefault is the resolved default of one column.
+type columnDefault struct {
+	col   int
+	value string
+}
+
+// defaultValue resolves the date keywords of a default.
+func defaultValue(v string, now time.Time) string {
+
+	switch strings.ToUpper(strings.TrimSpace(v)) {
+	case "CURRENT_DATE":
+		return now.Format("2006-01-02")
+	case "CURRENT_TIMESTAMP", "NOW()":
+		return now.Format("2006-01-02 15:04:05")
+	}
+
+	return v
+}
+
+// compileDefaults resolves the defaults of req against cols.
+func compileDefaults(req IngestRequest, cols []string) ([]columnDefault, error) {
+
+	loc, err := outputLocation(req.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	now := time.Now().In(loc)
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	var out []columnDefault
+
+	for name, v := range req.Defaults {
+
+		col := renamedColumn(name, req.Rename)
+		i, ok := index[col]
+		if !ok {
+			return nil, fmt.Errorf("defaults: unknown column %q (available: %s)", col, strings.Join(cols, ", "))
+		}
+
+		if v = defaultValue(v, now); v == "" {
+			continue
+		}
+		out = append(out, columnDefault{i, v})
+	}
+
+	return out, nil
+}
+
+// defaultType checks that v fits a column of type t and returns the type
+// the column needs to hold it.
+func defaultType(v, t string) (string, error) {
+
+	kind := classifyValue(cleanValue(v))
+	upper := strings.ToUpper(t)
+
+	switch {
+	case isNumericType(upper):
+		if kind != "INT" && kind != "FLOAT" {
+			return "", fmt.Errorf("%q is not a number", v)
+		}
+	case upper == "DATE":
+		if kind != "DATE" {
+			return "", fmt.Errorf("%q is not a date (use YYYY-MM-DD or CURRENT_DATE)", v)
+		}
+	case upper == "DATETIME":
+		if kind != "DATE" && kind != "DATETIME" {
+			return "", fmt.Errorf("%q is not a date or time", v)
+		}
+	}
+
+	if values, ok := enumValues(t); ok {
+		for _, e := range values {
+			if e == v {
+				return t, nil
+			}
+		}
+		return enumType(append(values, v)), nil
+	}
+
+	if n, ok := varcharLength(upper); ok && utf8.RuneCountInString(v) > n {
+		return stringType(utf8.RuneCountInString(v)), nil
+	}
+
+	return t, nil
+}
+
+// fillDefaults writes the defaults into the empty cells of row.
+func fillDefaults(row []string, defaults []columnDefault) []string {
+
+	for _, d := range defaults {
+		if d.col < len(row) && strings.TrimSpace(row[d.col]) == "" {
+			row[d.col] = d.value
+		}
+	}
+
+	return row
+}
+
+// applyDefaults checks the defaults against the preview's types and fills
+// its empty cells, so the preview shows what will be inserted.
+func applyDefaults(p Preview, req IngestRequest) (Preview, error) {
+
+	if len(req.Defaults) == 0 {
+		return p, nil
+	}
+
+	defaults, err := compileDefaults(req, p.Columns)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	for _, d := range defaults {
+		name := p.Columns[d.col]
+		t, err := defaultType(d.value, p.Types[name])
+		if err != nil {
+			return Preview{}, fmt.Errorf("defaults: column %s is %s: %w", name, p.Types[name], err)
+		}
+		p.Types[name] = t
+	}
+
+	for i, r := range p.Rows {
+		p.Rows[i] = fillDefaults(alignRow(r, len(p.Columns)), defaults)
+	}
+
+	return p, nil
+}
+
+// defaultStream fills empty cells of cleaned rows on their way to MySQL.
+type defaultStream struct {
+	rowStream
+	defaults []columnDefault
+}
+
+func (s *defaultStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return fillDefaults(row, s.defaults), nil
+}
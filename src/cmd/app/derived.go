@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// DERIVED COLUMNS /////////////////////
+///////////////////////////////////////////////////////////
+
+// DerivedColumn is a column computed from the others with an expression
+// (see expr.go), e.g. {"name": "market_cap", "expr": "price * shares"}.
+// Expressions see cleaned cells and may use derived columns defined
+// before them. The preview shows their values and infers their types;
+// the consumer computes them again for every row it inserts.
+type DerivedColumn struct {
+	Name string `json:"name"`
+	Expr string `json:"expr"`
+	Type string `json:"type"` // SQL type; inferred from the preview values when empty
+}
+
+type derivedColumn struct {
+	name string
+	expr expr
+}
+
+// compileDerived parses the derived columns of a table whose other
+// columns are cols.
+func compileDerived(defs []DerivedColumn, cols []string) ([]derivedColumn, error) {
+
+	known := append([]string{}, cols...)
+	var out []derivedColumn
+
+	for _, d := range defs {
+
+		name := normalizeColumns([]string{d.Name})[0]
+		for _, c := range known {
+			if c == name {
+				return nil, fmt.Errorf("derived column %s: a column with that name exists", name)
+			}
+		}
+
+		e, err := compileExpr(d.Expr, known)
+		if err != nil {
+			return nil, fmt.Errorf("derived column %s: %w", name, err)
+		}
+
+		out = append(out, derivedColumn{name, e})
+		known = append(known, name)
+	}
+
+	return out, nil
+}
+
+// deriveRow appends the derived values to a row of width cells.
+func deriveRow(row []string, width int, derived []derivedColumn) []string {
+
+	row = alignRow(row, width)
+	for _, d := range derived {
+		row = append(row, d.expr.eval(row))
+	}
+
+	return row
+}
+
+// deriveColumns adds the derived columns to a preview. Values are
+// computed from cells cleaned by clean, as the consumer will see them.
+func deriveColumns(p Preview, defs []DerivedColumn, clean func(int, string) string, sampled bool) (Preview, error) {
+
+	derived, err := compileDerived(defs, p.Columns)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	width := len(p.Columns)
+
+	for r, row := range p.Rows {
+		cleaned := make([]string, width)
+		for i := range cleaned {
+			if i < len(row) {
+				cleaned[i] = clean(i, row[i])
+			}
+		}
+		values := deriveRow(cleaned, width, derived)[width:]
+		p.Rows[r] = append(alignRow(row, width), values...)
+	}
+
+	names := make([]string, len(derived))
+	for i, d := range derived {
+		names[i] = d.name
+		p.Columns = append(p.Columns, d.name)
+	}
+	p.appended += len(derived)
+
+	values := make([][]string, len(p.Rows))
+	for r, row := range p.Rows {
+		values[r] = row[width:]
+	}
+
+	types := inferTypes(names, values)
+	if sampled {
+		sampledTypes(types)
+	}
+
+	explicit := map[string]string{}
+	for i, d := range defs {
+		p.Types[names[i]] = types[names[i]]
+		if strings.TrimSpace(d.Type) != "" {
+			explicit[names[i]] = d.Type
+		}
+	}
+
+	return applyTypeOverrides(p, explicit, nil)
+}
+
+// withoutDerived drops the n derived and lookup cells the preview added to
+// each row (Preview.appended), since the consumer computes them itself.
+func withoutDerived(rows [][]string, n int) [][]string {
+
+	if n == 0 {
+		return rows
+	}
+
+	out := make([][]string, len(rows))
+	for i, r := range rows {
+		out[i] = r[:max(len(r)-n, 0)]
+	}
+
+	return out
+}
+
+// derivedStream computes the derived columns of cleaned rows on their way
+// to MySQL.
+type derivedStream struct {
+	rowStream
+	width   int
+	derived []derivedColumn
+}
+
+func (s *derivedStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return deriveRow(row, s.width, s.derived), nil
+}
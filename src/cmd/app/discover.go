@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xmlquery"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TABLE DISCOVERY /////////////////////
+///////////////////////////////////////////////////////////
+
+type DiscoverRequest struct {
+	URL          string        `json:"url"`       // site root or sitemap.xml
+	MaxPages     int           `json:"max_pages"` // pages to inspect, default 20
+	FetchOptions *FetchOptions `json:"fetch_options,omitempty"`
+}
+
+type DiscoveredPage struct {
+	URL    string      `json:"url"`
+	Title  string      `json:"title,omitempty"`
+	Tables []TableInfo `json:"tables"`
+}
+
+const (
+	defaultDiscoverPages = 20
+	maxDiscoverPages     = 200
+)
+
+// discoverHandler reports which pages of a site contain tables. A
+// sitemap (or sitemap index) lists the pages to inspect; any other URL is
+// crawled breadth-first through same-host links.
+func discoverHandler(w http.ResponseWriter, r *http.Request) {
+
+	var req DiscoverRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	if req.URL == "" {
+		http.Error(w, "url required", http.StatusBadRequest)
+		return
+	}
+
+	limit := req.MaxPages
+	if limit <= 0 {
+		limit = defaultDiscoverPages
+	}
+	if limit > maxDiscoverPages {
+		limit = maxDiscoverPages
+	}
+
+	first, err := fetchSource(req.URL, req.FetchOptions)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	var pages []DiscoveredPage
+
+	if locs := sitemapLocations(first, req.FetchOptions, limit); locs != nil {
+		pages = inspectPages(locs, req.FetchOptions)
+	} else {
+		pages = crawlForTables(first, req.FetchOptions, limit)
+	}
+
+	if pages == nil {
+		pages = []DiscoveredPage{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pages)
+}
+
+// sitemapLocations returns the page URLs of a sitemap, following nested
+// sitemap indexes, or nil when the source is not a sitemap.
+func sitemapLocations(src *fetchedSource, opts *FetchOptions, limit int) []string {
+
+	doc, err := xmlquery.Parse(bytes.NewReader(src.Body))
+	if err != nil {
+		return nil
+	}
+
+	root := xmlquery.FindOne(doc, "/*")
+	if root == nil || (root.Data != "urlset" && root.Data != "sitemapindex") {
+		return nil
+	}
+
+	locs := []string{}
+
+	for _, n := range xmlquery.Find(doc, "//loc") {
+
+		if len(locs) >= limit {
+			break
+		}
+
+		loc := strings.TrimSpace(n.InnerText())
+
+		if root.Data == "sitemapindex" {
+			child, err := fetchSource(loc, opts)
+			if err != nil {
+				continue
+			}
+			locs = append(locs, sitemapLocations(child, opts, limit-len(locs))...)
+			continue
+		}
+
+		locs = append(locs, loc)
+	}
+
+	return locs
+}
+
+func inspectPages(urls []string, opts *FetchOptions) []DiscoveredPage {
+
+	var pages []DiscoveredPage
+
+	for _, u := range urls {
+
+		src, err := fetchSource(u, opts)
+		if err != nil {
+			continue
+		}
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(src.Body))
+		if err != nil {
+			continue
+		}
+
+		if page, ok := tablePage(u, doc); ok {
+			pages = append(pages, page)
+		}
+	}
+
+	return pages
+}
+
+func crawlForTables(first *fetchedSource, opts *FetchOptions, limit int) []DiscoveredPage {
+
+	root, err := url.Parse(first.URL)
+	if err != nil {
+		return nil
+	}
+
+	var pages []DiscoveredPage
+
+	queue := []string{first.URL}
+	seen := map[string]bool{first.URL: true}
+
+	for visited := 0; len(queue) > 0 && visited < limit; visited++ {
+
+		current := queue[0]
+		queue = queue[1:]
+
+		src := first
+		if visited > 0 {
+			src, err = fetchSource(current, opts)
+			if err != nil {
+				continue
+			}
+		}
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(src.Body))
+		if err != nil {
+			continue
+		}
+
+		if page, ok := tablePage(current, doc); ok {
+			pages = append(pages, page)
+		}
+
+		base, _ := url.Parse(current)
+
+		doc.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+
+			ref, err := url.Parse(strings.TrimSpace(a.AttrOr("href", "")))
+			if err != nil {
+				return
+			}
+
+			next := base.ResolveReference(ref)
+			next.Fragment = ""
+
+			if next.Host != root.Host || (next.Scheme != "http" && next.Scheme != "https") {
+				return
+			}
+
+			if s := next.String(); !seen[s] {
+				seen[s] = true
+				queue = append(queue, s)
+			}
+		})
+	}
+
+	return pages
+}
+
+func tablePage(u string, doc *goquery.Document) (DiscoveredPage, bool) {
+
+	tables := listTables(doc)
+	if len(tables) == 0 {
+		return DiscoveredPage{}, false
+	}
+
+	return DiscoveredPage{
+		URL:    u,
+		Title:  strings.TrimSpace(doc.Find("title").First().Text()),
+		Tables: tables,
+	}, true
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// LOOKUP ENRICHMENT ///////////////////
+///////////////////////////////////////////////////////////
+
+// Lookup adds columns of an existing table to each row whose key matches,
+// e.g. {"table": "securities", "key": "ticker", "columns": ["sector"]}.
+// Keys are compared as trimmed text, ignoring case like MySQL's default
+// collation. The lookup table is read once per job.
+type Lookup struct {
+	Table     string   `json:"table"`      // existing table to read
+	Key       string   `json:"key"`        // incoming column to match
+	LookupKey string   `json:"lookup_key"` // column of table it matches; defaults to key
+	Columns   []string `json:"columns"`    // columns of table added to each row
+	OnMissing string   `json:"on_missing"` // no match: "null" (default), "reject" the row or "fail" the job
+}
+
+var lookupMissingPolicies = map[string]bool{"": true, "null": true, "reject": true, "fail": true}
+
+// lookupTable is a loaded lookup.
+type lookupTable struct {
+	table     string
+	key       int // index of the incoming key column
+	columns   []string
+	types     []string
+	values    map[string][]string
+	onMissing string
+}
+
+func lookupKey(v string) string {
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// loadLookups reads the lookup tables of req. cols are the incoming
+// columns; the added columns must not collide with them or each other.
+func loadLookups(req IngestRequest, cols []string) ([]lookupTable, error) {
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	var out []lookupTable
+
+	for _, l := range req.Lookups {
+
+		if !lookupMissingPolicies[l.OnMissing] {
+			return nil, fmt.Errorf("lookup %s: unknown on_missing %q (use null, reject or fail)", l.Table, l.OnMissing)
+		}
+		if len(l.Columns) == 0 {
+			return nil, fmt.Errorf("lookup %s: no columns to add", l.Table)
+		}
+
+		key := renamedColumn(l.Key, req.Rename)
+		k, ok := index[key]
+		if !ok {
+			return nil, fmt.Errorf("lookup %s: unknown key column %q (available: %s)", l.Table, key, strings.Join(cols, ", "))
+		}
+
+		_, types, err := existingTableSchema(l.Table)
+		if err != nil {
+			return nil, fmt.Errorf("lookup: %w", err)
+		}
+
+		from := l.LookupKey
+		if from == "" {
+			from = key
+		}
+		if _, ok := types[from]; !ok {
+			return nil, fmt.Errorf("lookup %s: no column %q", l.Table, from)
+		}
+
+		lt := lookupTable{table: l.Table, key: k, values: map[string][]string{}, onMissing: l.OnMissing}
+		for _, c := range l.Columns {
+			t, ok := types[c]
+			if !ok {
+				return nil, fmt.Errorf("lookup %s: no column %q", l.Table, c)
+			}
+			if _, taken := index[c]; taken {
+				return nil, fmt.Errorf("lookup %s: column %s already exists", l.Table, c)
+			}
+			index[c] = -1
+			lt.columns = append(lt.columns, c)
+			lt.types = append(lt.types, t)
+		}
+
+		if err := lt.load(from); err != nil {
+			return nil, err
+		}
+
+		fmt.Printf("🔗 Loaded %d keys from lookup table %s\n", len(lt.values), l.Table)
+		out = append(out, lt)
+	}
+
+	return out, nil
+}
+
+// load reads the lookup columns of every row, keyed by from. The first
+// row of a repeated key wins.
+func (lt *lookupTable) load(from string) error {
+
+	rows, err := db.Query(fmt.Sprintf("SELECT %s, %s FROM %s", from, strings.Join(lt.columns, ", "), lt.table))
+	if err != nil {
+		return fmt.Errorf("lookup %s: %w", lt.table, err)
+	}
+	defer rows.Close()
+
+	cells := make([]sql.NullString, len(lt.columns)+1)
+	dest := make([]interface{}, len(cells))
+	for i := range cells {
+		dest[i] = &cells[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return fmt.Errorf("lookup %s: %w", lt.table, err)
+		}
+		k := lookupKey(cells[0].String)
+		if _, seen := lt.values[k]; seen || !cells[0].Valid {
+			continue
+		}
+		values := make([]string, len(lt.columns))
+		for i := range values {
+			values[i] = cells[i+1].String
+		}
+		lt.values[k] = values
+	}
+
+	return rows.Err()
+}
+
+// enrichRow appends the looked-up values to a row of width cells. A key
+// without a match gets NULLs, or is returned as missing when its lookup
+// rejects or fails.
+func enrichRow(row []string, width int, lookups []lookupTable) ([]string, *lookupTable) {
+
+	row = alignRow(row, width)
+
+	for i := range lookups {
+		lt := &lookups[i]
+		values, ok := lt.values[lookupKey(row[lt.key])]
+		if !ok {
+			if lt.onMissing == "reject" || lt.onMissing == "fail" {
+				return nil, lt
+			}
+			values = make([]string, len(lt.columns))
+		}
+		row = append(row, values...)
+	}
+
+	return row, nil
+}
+
+func (lt *lookupTable) missing(row []string) string {
+	return fmt.Sprintf("no %s match for %q", lt.table, row[lt.key])
+}
+
+// enrichedColumns counts the columns the lookups of req add.
+func enrichedColumns(req IngestRequest) int {
+
+	n := 0
+	for _, l := range req.Lookups {
+		n += len(l.Columns)
+	}
+
+	return n
+}
+
+// enrichColumns adds the lookup columns to a preview. Keys are read from
+// cells cleaned by clean, as the consumer will see them.
+func enrichColumns(p Preview, req IngestRequest, clean func(int, string) string) (Preview, error) {
+
+	lookups, err := loadLookups(req, p.Columns)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	width := len(p.Columns)
+	var kept [][]string
+
+	for i, row := range p.Rows {
+		cleaned := make([]string, width)
+		for c := range cleaned {
+			if c < len(row) {
+				cleaned[c] = clean(c, row[c])
+			}
+		}
+
+		enriched, missing := enrichRow(cleaned, width, lookups)
+		if missing != nil {
+			if missing.onMissing == "fail" {
+				return Preview{}, fmt.Errorf("lookup: %s", missing.missing(cleaned))
+			}
+			p.Rejected = append(p.Rejected, RejectedRow{Row: i + 1, Reason: "lookup: " + missing.missing(cleaned), Cells: row})
+			continue
+		}
+		kept = append(kept, append(alignRow(row, width), enriched[width:]...))
+	}
+	p.Rows = kept
+
+	for _, lt := range lookups {
+		for i, c := range lt.columns {
+			p.Columns = append(p.Columns, c)
+			p.Types[c] = lt.types[i]
+		}
+		p.appended += len(lt.columns)
+	}
+
+	return p, nil
+}
+
+// enrichStream adds the lookup columns to cleaned rows on their way to
+// MySQL, recording rejected rows against the job.
+type enrichStream struct {
+	rowStream
+	width   int
+	lookups []lookupTable
+	jobID   string
+	row     int
+}
+
+func (s *enrichStream) Next() ([]string, error) {
+
+	for {
+		r, err := s.rowStream.Next()
+		if err != nil {
+			return nil, err
+		}
+		s.row++
+
+		enriched, missing := enrichRow(r, s.width, s.lookups)
+		if missing == nil {
+			return enriched, nil
+		}
+
+		reason := "lookup: " + missing.missing(alignRow(r, s.width))
+		if missing.onMissing == "fail" {
+			return nil, fmt.Errorf("%s", reason)
+		}
+		recordRejects(s.jobID, []RejectedRow{{Row: s.row, Reason: reason, Cells: r}})
+	}
+}
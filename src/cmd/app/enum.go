@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// CATEGORICAL COLUMNS /////////////////
+///////////////////////////////////////////////////////////
+
+// A string column is categorical when it repeats a handful of values:
+// ratings, sectors, statuses. The preview lists those columns with their
+// values; enum_columns creates them as ENUM.
+const (
+	maxEnumValues     = 16
+	minEnumSample     = 10 // non-empty cells needed before a column is judged
+	minEnumRepetition = 4  // cells per distinct value, on average
+)
+
+// flagCategorical fills p.Categorical and, with asEnum, switches the
+// flagged columns to ENUM. Values are taken as they will be inserted,
+// i.e. after cleaning.
+func flagCategorical(p Preview, clean func(int, string) string, asEnum bool) Preview {
+
+	for c, name := range p.Columns {
+
+		t := strings.ToUpper(p.Types[name])
+		if _, ok := varcharLength(t); !ok && t != "TEXT" {
+			continue
+		}
+
+		seen := map[string]bool{}
+		total := 0
+
+		for _, r := range p.Rows {
+			if c >= len(r) || r[c] == "" {
+				continue
+			}
+			v := clean(c, r[c])
+			if v == "" {
+				continue
+			}
+			total++
+			seen[v] = true
+			if len(seen) > maxEnumValues {
+				break
+			}
+		}
+
+		if total < minEnumSample || len(seen) > maxEnumValues || len(seen)*minEnumRepetition > total {
+			continue
+		}
+
+		values := make([]string, 0, len(seen))
+		for v := range seen {
+			values = append(values, v)
+		}
+		sort.Strings(values)
+
+		if p.Categorical == nil {
+			p.Categorical = map[string][]string{}
+		}
+		p.Categorical[name] = values
+
+		if asEnum {
+			p.Types[name] = enumType(values)
+		}
+	}
+
+	return p
+}
+
+// enumType renders an ENUM column type, quoting each value for SQL.
+func enumType(values []string) string {
+
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		v = strings.ReplaceAll(v, `\`, `\\`)
+		quoted[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+
+	return fmt.Sprintf("ENUM(%s)", strings.Join(quoted, ","))
+}
+
+// enumValues parses the values of an ENUM column type as information_schema
+// reports it. ok is false for other types.
+func enumValues(t string) ([]string, bool) {
+
+	inner, ok := strings.CutPrefix(strings.TrimSpace(t), "ENUM(")
+	if !ok {
+		inner, ok = strings.CutPrefix(strings.TrimSpace(t), "enum(")
+	}
+	if !ok {
+		return nil, false
+	}
+	inner = strings.TrimSuffix(inner, ")")
+
+	var values []string
+	var cur strings.Builder
+	quoted := false
+
+	for i := 0; i < len(inner); i++ {
+		ch := inner[i]
+		switch {
+		case ch == '\'' && !quoted:
+			quoted = true
+		case ch == '\'' && i+1 < len(inner) && inner[i+1] == '\'':
+			cur.WriteByte('\'')
+			i++
+		case ch == '\\' && i+1 < len(inner):
+			cur.WriteByte(inner[i+1])
+			i++
+		case ch == '\'':
+			quoted = false
+			values = append(values, cur.String())
+			cur.Reset()
+		case quoted:
+			cur.WriteByte(ch)
+		}
+	}
+
+	return values, true
+}
+
+// widerEnum returns the ENUM type holding the values of both cur and
+// next, or false when next adds nothing or either is not an ENUM.
+func widerEnum(cur, next string) (string, bool) {
+
+	have, ok1 := enumValues(cur)
+	add, ok2 := enumValues(next)
+	if !ok1 || !ok2 {
+		return "", false
+	}
+
+	known := map[string]bool{}
+	for _, v := range have {
+		known[v] = true
+	}
+
+	union := have
+	for _, v := range add {
+		if !known[v] {
+			union = append(union, v)
+		}
+	}
+
+	if len(union) == len(have) {
+		return "", false
+	}
+
+	// Existing values keep their positions; new ones go at the end.
+	return enumType(union), true
+}
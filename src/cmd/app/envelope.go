@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// MESSAGE ENVELOPE ////////////////////
+///////////////////////////////////////////////////////////
+
+// JSON job messages travel in a versioned envelope:
+//
+//	{"version": 1, "type": "job" or "chunk", "job_id": "...",
+//	 "checksum": "<crc32c of payload>", "payload": {...}}
+//
+// The consumer reads the envelope first and decodes the payload the way
+// its version says, so a change to the payload ships as a new version
+// while messages of the old one still in the broker read as before.
+// Messages published before the envelope, a bare payload, read as
+// version 0. A payload whose checksum does not match, or of a version
+// this consumer does not know, fails its job instead of being guessed at.
+//
+// Avro messages (registry.go) are not wrapped: the schema ID they carry
+// versions them already, and Confluent deserializers must still read
+// them.
+
+const messageVersion = 1
+
+type messageEnvelope struct {
+	Version  int             `json:"version"`
+	Type     string          `json:"type"`
+	JobID    string          `json:"job_id"`
+	Checksum string          `json:"checksum"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+func messageChecksum(payload []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(payload, crc32c))
+}
+
+// badMessage is an enveloped message that names its job but cannot be
+// read; the consumer fails the job.
+type badMessage struct {
+	jobID string
+	err   error
+}
+
+func (e *badMessage) Error() string {
+	return fmt.Sprintf("job %s: %v", e.jobID, e.err)
+}
+
+// sealMessage wraps a schema message or chunk message in the current
+// envelope.
+func sealMessage(msg map[string]interface{}) ([]byte, error) {
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := "job"
+	if _, ok := msg["chunk"]; ok {
+		kind = "chunk"
+	}
+	jobID, _ := msg["job_id"].(string)
+
+	return json.Marshal(messageEnvelope{
+		Version:  messageVersion,
+		Type:     kind,
+		JobID:    jobID,
+		Checksum: messageChecksum(payload),
+		Payload:  payload,
+	})
+}
+
+// openMessage reads a JSON message of any version into the shape
+// assembleJob expects.
+func openMessage(b []byte) (map[string]interface{}, error) {
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("message is neither Avro nor JSON: %w", err)
+	}
+
+	// Version 0: the payload itself.
+	if _, ok := fields["version"]; !ok {
+		var msg map[string]interface{}
+		json.Unmarshal(b, &msg)
+		return msg, nil
+	}
+
+	var env messageEnvelope
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, fmt.Errorf("envelope: %w", err)
+	}
+	if env.JobID == "" {
+		return nil, fmt.Errorf("envelope of version %d names no job", env.Version)
+	}
+
+	switch env.Version {
+	case 1:
+		return openMessageV1(env)
+	}
+
+	return nil, &badMessage{env.JobID, fmt.Errorf("message version %d is newer than this consumer reads (%d)", env.Version, messageVersion)}
+}
+
+func openMessageV1(env messageEnvelope) (map[string]interface{}, error) {
+
+	if sum := messageChecksum(env.Payload); sum != env.Checksum {
+		return nil, &badMessage{env.JobID, fmt.Errorf("%s message checksum %s does not match its payload (%s)", env.Type, env.Checksum, sum)}
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal(env.Payload, &msg); err != nil {
+		return nil, &badMessage{env.JobID, fmt.Errorf("%s message payload: %w", env.Type, err)}
+	}
+
+	_, chunk := msg["chunk"]
+	if jobID, _ := msg["job_id"].(string); jobID != env.JobID || chunk != (env.Type == "chunk") {
+		return nil, &badMessage{env.JobID, fmt.Errorf("%s message payload does not match its envelope", env.Type)}
+	}
+
+	return msg, nil
+}
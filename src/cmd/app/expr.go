@@ -0,0 +1,639 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// EXPRESSIONS /////////////////////////
+///////////////////////////////////////////////////////////
+
+// Derived columns, row filters, mapping rules and validation checks are
+// written in a small expression language:
+//
+//   price * shares              columns by normalized name
+//   `Last Price` / 100          backquotes for names as the source wrote them
+//   (high + low) / 2            + - * / and parentheses
+//   'n/a'                       string literals
+//   YEAR(trade_date)            functions, see exprFunctions
+//   country == 'US'             == (or =), !=, <>, <, <=, >, >=
+//   a > 0 AND NOT b IS NULL     AND (&&), OR (||), NOT, IS [NOT] NULL
+//
+// Cells are strings. Arithmetic reads them as numbers the way inference
+// does ("$1,234" is 1234); an empty (NULL) or non-numeric operand, or a
+// division by zero, makes the result NULL, as in SQL. Comparisons are
+// numeric when both sides are numbers and textual otherwise, and give 1
+// or 0 (NULL when a side is NULL); AND and OR treat NULL as false.
+
+type expr interface {
+	eval(row []string) string
+}
+
+type exprLiteral string
+
+type exprColumn int
+
+type exprNegate struct{ x expr }
+
+type exprBinary struct {
+	op   byte
+	x, y expr
+}
+
+type exprCall struct {
+	fn   func(args []string) string
+	args []expr
+}
+
+type exprCompare struct {
+	op   string
+	x, y expr
+}
+
+type exprLogic struct {
+	and  bool
+	x, y expr
+}
+
+type exprNot struct{ x expr }
+
+type exprIsNull struct {
+	x   expr
+	not bool
+}
+
+func (e exprLiteral) eval([]string) string { return string(e) }
+
+func (e exprColumn) eval(row []string) string {
+
+	if int(e) < len(row) {
+		return row[e]
+	}
+
+	return ""
+}
+
+func (e exprNegate) eval(row []string) string {
+
+	x, ok := exprNumber(e.x.eval(row))
+	if !ok {
+		return ""
+	}
+
+	return formatNumber(-x)
+}
+
+func (e exprBinary) eval(row []string) string {
+
+	x, ok1 := exprNumber(e.x.eval(row))
+	y, ok2 := exprNumber(e.y.eval(row))
+	if !ok1 || !ok2 {
+		return ""
+	}
+
+	switch e.op {
+	case '+':
+		return formatNumber(x + y)
+	case '-':
+		return formatNumber(x - y)
+	case '*':
+		return formatNumber(x * y)
+	default:
+		if y == 0 {
+			return ""
+		}
+		return formatNumber(x / y)
+	}
+}
+
+func (e exprCall) eval(row []string) string {
+
+	args := make([]string, len(e.args))
+	for i, a := range e.args {
+		args[i] = a.eval(row)
+	}
+
+	return e.fn(args)
+}
+
+func (e exprCompare) eval(row []string) string {
+
+	a, b := e.x.eval(row), e.y.eval(row)
+	if a == "" || b == "" {
+		return ""
+	}
+
+	cmp := strings.Compare(a, b)
+	if x, ok := exprNumber(a); ok {
+		if y, ok := exprNumber(b); ok {
+			cmp = 0
+			if x < y {
+				cmp = -1
+			} else if x > y {
+				cmp = 1
+			}
+		}
+	}
+
+	switch e.op {
+	case "==", "=":
+		return exprBool(cmp == 0)
+	case "!=", "<>":
+		return exprBool(cmp != 0)
+	case "<":
+		return exprBool(cmp < 0)
+	case "<=":
+		return exprBool(cmp <= 0)
+	case ">":
+		return exprBool(cmp > 0)
+	default:
+		return exprBool(cmp >= 0)
+	}
+}
+
+func (e exprLogic) eval(row []string) string {
+
+	if e.and {
+		return exprBool(truthy(e.x.eval(row)) && truthy(e.y.eval(row)))
+	}
+
+	return exprBool(truthy(e.x.eval(row)) || truthy(e.y.eval(row)))
+}
+
+func (e exprNot) eval(row []string) string {
+
+	v := e.x.eval(row)
+	if v == "" {
+		return ""
+	}
+
+	return exprBool(!truthy(v))
+}
+
+func (e exprIsNull) eval(row []string) string {
+	return exprBool((e.x.eval(row) == "") != e.not)
+}
+
+func exprBool(b bool) string {
+
+	if b {
+		return "1"
+	}
+
+	return "0"
+}
+
+// truthy is false for NULL and numeric zero, true for anything else.
+func truthy(v string) bool {
+
+	if v == "" {
+		return false
+	}
+
+	if f, ok := exprNumber(v); ok {
+		return f != 0
+	}
+
+	return true
+}
+
+// exprFunction is a function callable from expressions with min to max
+// arguments; max -1 means any number.
+type exprFunction struct {
+	min, max int
+	fn       func(args []string) string
+}
+
+var exprFunctions = map[string]exprFunction{
+	"YEAR":  {1, 1, datePart(func(t time.Time) int { return t.Year() })},
+	"MONTH": {1, 1, datePart(func(t time.Time) int { return int(t.Month()) })},
+	"DAY":   {1, 1, datePart(func(t time.Time) int { return t.Day() })},
+	"ABS": {1, 1, func(a []string) string {
+		x, ok := exprNumber(a[0])
+		if !ok {
+			return ""
+		}
+		return formatNumber(math.Abs(x))
+	}},
+	"ROUND": {1, 2, func(a []string) string {
+		x, ok := exprNumber(a[0])
+		if !ok {
+			return ""
+		}
+		places := 0.0
+		if len(a) == 2 {
+			if places, ok = exprNumber(a[1]); !ok {
+				return ""
+			}
+		}
+		scale := math.Pow(10, math.Trunc(places))
+		return formatNumber(math.Round(x*scale) / scale)
+	}},
+	"UPPER": {1, 1, func(a []string) string { return strings.ToUpper(a[0]) }},
+	"LOWER": {1, 1, func(a []string) string { return strings.ToLower(a[0]) }},
+	// CONCAT skips NULL arguments instead of returning NULL.
+	"CONCAT": {1, -1, func(a []string) string { return strings.Join(a, "") }},
+	"COALESCE": {1, -1, func(a []string) string {
+		for _, v := range a {
+			if v != "" {
+				return v
+			}
+		}
+		return ""
+	}},
+}
+
+func datePart(part func(time.Time) int) func([]string) string {
+
+	return func(a []string) string {
+		t, _, ok := parseDateTime(a[0])
+		if !ok {
+			return ""
+		}
+		return strconv.Itoa(part(t))
+	}
+}
+
+// exprNumber reads a cell as a number; NULL and text are not numbers.
+func exprNumber(v string) (float64, bool) {
+
+	if v == "" {
+		return 0, false
+	}
+
+	f, err := strconv.ParseFloat(cleanForInference(v), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+
+	return f, true
+}
+
+// formatNumber writes a result without float noise (0.1*3 is 0.3).
+func formatNumber(f float64) string {
+
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return ""
+	}
+
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', 15, 64), 64)
+
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// compileExpr parses src into an expression over the columns cols.
+func compileExpr(src string, cols []string) (expr, error) {
+
+	p := &exprParser{src: src, cols: map[string]int{}, names: cols}
+	for i, c := range cols {
+		p.cols[c] = i
+	}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.src[p.pos:], p.pos+1)
+	}
+
+	return e, nil
+}
+
+type exprParser struct {
+	src   string
+	pos   int
+	cols  map[string]int
+	names []string
+}
+
+func (p *exprParser) skipSpace() {
+
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// next skips spaces and returns the next byte, 0 at the end.
+func (p *exprParser) next() byte {
+
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+
+	return 0
+}
+
+// keyword consumes word, in any case, when it is the next token.
+func (p *exprParser) keyword(word string) bool {
+
+	p.skipSpace()
+
+	end := p.pos + len(word)
+	if end > len(p.src) || !strings.EqualFold(p.src[p.pos:end], word) {
+		return false
+	}
+	if end < len(p.src) && (isIdentStart(p.src[end]) || p.src[end] >= '0' && p.src[end] <= '9') {
+		return false
+	}
+
+	p.pos = end
+	return true
+}
+
+// operator consumes the first of ops that comes next.
+func (p *exprParser) operator(ops ...string) string {
+
+	p.skipSpace()
+
+	for _, op := range ops {
+		if strings.HasPrefix(p.src[p.pos:], op) {
+			p.pos += len(op)
+			return op
+		}
+	}
+
+	return ""
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("OR") || p.operator("||") != "" {
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		x = exprLogic{false, x, y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.keyword("AND") || p.operator("&&") != "" {
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		x = exprLogic{true, x, y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+
+	if p.keyword("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return exprNot{x}, nil
+	}
+
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (expr, error) {
+
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			return nil, fmt.Errorf("expected NULL after IS at position %d", p.pos+1)
+		}
+		return exprIsNull{x, not}, nil
+	}
+
+	if op := p.operator("==", "!=", "<>", "<=", ">=", "=", "<", ">"); op != "" {
+		y, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return exprCompare{op, x, y}, nil
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseSum() (expr, error) {
+
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.next(); op == '+' || op == '-'; op = p.next() {
+		p.pos++
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		x = exprBinary{op, x, y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseProduct() (expr, error) {
+
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for op := p.next(); op == '*' || op == '/'; op = p.next() {
+		p.pos++
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		x = exprBinary{op, x, y}
+	}
+
+	return x, nil
+}
+
+func (p *exprParser) parseUnary() (expr, error) {
+
+	if p.next() == '-' {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNegate{x}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+
+	c := p.next()
+
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression")
+
+	case c == '(':
+		p.pos++
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ')' {
+			return nil, fmt.Errorf("missing ) at position %d", p.pos+1)
+		}
+		p.pos++
+		return x, nil
+
+	case c == '\'':
+		return p.parseString()
+
+	case c == '`':
+		end := strings.IndexByte(p.src[p.pos+1:], '`')
+		if end == -1 {
+			return nil, fmt.Errorf("unterminated ` at position %d", p.pos+1)
+		}
+		name := p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return p.column(name)
+
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		if _, err := strconv.ParseFloat(p.src[start:p.pos], 64); err != nil {
+			return nil, fmt.Errorf("bad number %q", p.src[start:p.pos])
+		}
+		return exprLiteral(p.src[start:p.pos]), nil
+
+	case isIdentStart(c):
+		start := p.pos
+		for p.pos < len(p.src) && (isIdentStart(p.src[p.pos]) || p.src[p.pos] >= '0' && p.src[p.pos] <= '9') {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		if p.next() == '(' {
+			return p.parseCall(name)
+		}
+		if strings.EqualFold(name, "NULL") {
+			return exprLiteral(""), nil
+		}
+		return p.column(name)
+	}
+
+	return nil, fmt.Errorf("unexpected %q at position %d", c, p.pos+1)
+}
+
+func (p *exprParser) parseString() (expr, error) {
+
+	var b strings.Builder
+	p.pos++
+
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		p.pos++
+		if c != '\'' {
+			b.WriteByte(c)
+			continue
+		}
+		// '' is an escaped quote.
+		if p.pos < len(p.src) && p.src[p.pos] == '\'' {
+			b.WriteByte('\'')
+			p.pos++
+			continue
+		}
+		return exprLiteral(b.String()), nil
+	}
+
+	return nil, fmt.Errorf("unterminated string")
+}
+
+func (p *exprParser) parseCall(name string) (expr, error) {
+
+	f, ok := exprFunctions[strings.ToUpper(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+
+	p.pos++ // (
+	var args []expr
+
+	if p.next() != ')' {
+		for {
+			a, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if p.next() != ',' {
+				break
+			}
+			p.pos++
+		}
+	}
+
+	if p.next() != ')' {
+		return nil, fmt.Errorf("missing ) after %s arguments", name)
+	}
+	p.pos++
+
+	if len(args) < f.min || f.max >= 0 && len(args) > f.max {
+		return nil, fmt.Errorf("%s takes %s arguments, got %d", strings.ToUpper(name), argCount(f), len(args))
+	}
+
+	return exprCall{fn: f.fn, args: args}, nil
+}
+
+func (p *exprParser) column(name string) (expr, error) {
+
+	col := normalizeColumns([]string{name})[0]
+
+	i, ok := p.cols[col]
+	if !ok {
+		return nil, fmt.Errorf("unknown column %q (available: %s)", col, strings.Join(p.names, ", "))
+	}
+
+	return exprColumn(i), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+}
+
+func argCount(f exprFunction) string {
+
+	switch {
+	case f.max < 0:
+		return fmt.Sprintf("at least %d", f.min)
+	case f.min == f.max:
+		return strconv.Itoa(f.min)
+	default:
+		return fmt.Sprintf("%d to %d", f.min, f.max)
+	}
+}
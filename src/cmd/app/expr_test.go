@@ -0,0 +1,119 @@
+package main
+
+import "testing"
+
+func TestExprEval(t *testing.T) {
+
+	cols := []string{"price", "shares", "last_price", "country", "note"}
+	row := []string{"10", "3", "$1,250.50", "US", ""}
+
+	cases := []struct {
+		src  string
+		want string
+	}{
+		// Precedence and associativity.
+		{"1 + 2 * 3", "7"},
+		{"(1 + 2) * 3", "9"},
+		{"10 - 4 - 3", "3"},
+		{"8 / 4 / 2", "1"},
+		{"-price + 1", "-9"},
+		{"- -price", "10"},
+		{"price * shares - 5", "25"},
+		{"1 + 2 = 3", "1"},
+		{"0.1 * 3", "0.3"},
+		{"price > 5 OR shares > 5 AND country = 'CA'", "1"},
+		{"(price > 5 OR shares > 5) AND country = 'CA'", "0"},
+		{"NOT price > 20", "1"},
+		{"NOT price > 5 AND shares = 3", "0"},
+		{"price > 5 && shares < 2 || country == 'US'", "1"},
+
+		// Comparisons.
+		{"'abc' < 'abd'", "1"},
+		{"'10' > '9'", "1"},
+		{"country <> 'US'", "0"},
+		{"country != 'CA'", "1"},
+		{"price >= 10", "1"},
+		{"price <= 9.99", "0"},
+
+		// NULL.
+		{"note + 1", ""},
+		{"note * 0", ""},
+		{"NULL + 1", ""},
+		{"-note", ""},
+		{"note = 'x'", ""},
+		{"note = NULL", ""},
+		{"NOT note", ""},
+		{"note AND 1", "0"},
+		{"note OR 1", "1"},
+		{"COALESCE(note, 'none')", "none"},
+		{"CONCAT(country, note, '!')", "US!"},
+		{"ABS(note)", ""},
+		{"YEAR(note)", ""},
+
+		// IS [NOT] NULL.
+		{"note IS NULL", "1"},
+		{"note IS NOT NULL", "0"},
+		{"price is null", "0"},
+		{"price Is Not Null", "1"},
+		{"NOT note IS NULL", "0"},
+		{"price / 0 IS NULL", "1"},
+
+		// Quoted names and strings.
+		{"`Last Price` * 2", "2501"},
+		{"`last price`", "$1,250.50"},
+		{"`Country` = 'US'", "1"},
+		{"'it''s'", "it's"},
+
+		// Division by zero.
+		{"price / 0", ""},
+		{"price / (shares - 3)", ""},
+		{"COALESCE(price / 0, -1)", "-1"},
+		{"0 / price", "0"},
+
+		// Functions.
+		{"YEAR('2024-03-15')", "2024"},
+		{"month('2024-03-15')", "3"},
+		{"ROUND(2.5)", "3"},
+		{"ROUND(1.2345, 2)", "1.23"},
+		{"ABS(-price)", "10"},
+		{"UPPER(country) = LOWER('US')", "0"},
+	}
+
+	for _, c := range cases {
+		e, err := compileExpr(c.src, cols)
+		if err != nil {
+			t.Errorf("compileExpr(%q): %v", c.src, err)
+			continue
+		}
+		if got := e.eval(row); got != c.want {
+			t.Errorf("%s = %q, want %q", c.src, got, c.want)
+		}
+	}
+}
+
+func TestExprCompileErrors(t *testing.T) {
+
+	cols := []string{"price", "last_price"}
+
+	for _, src := range []string{
+		"",
+		"price +",
+		"(price",
+		"price price",
+		"volume",
+		"`Last Price",
+		"`Volume`",
+		"'abc",
+		"FOO(price)",
+		"ROUND()",
+		"ROUND(price, 2, 3)",
+		"ABS(price",
+		"price IS 5",
+		"price IS NOT",
+		"1..2",
+	} {
+		if _, err := compileExpr(src, cols); err == nil {
+			t.Errorf("compileExpr(%q) succeeded, want an error", src)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xmlquery"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// RSS / ATOM FEEDS ////////////////////
+///////////////////////////////////////////////////////////
+
+// feedColumns are taken from every RSS item or Atom entry. Extra columns
+// come from the request's fields, with paths relative to the entry
+// (e.g. {"name": "category", "path": "category"}).
+var feedColumns = []string{"title", "link", "published", "author", "guid", "description"}
+
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+}
+
+// isFeed reports whether an XML document is an RSS or Atom feed.
+func isFeed(body []byte) bool {
+
+	doc, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	root := xmlquery.FindOne(doc, "/*")
+	return root != nil && (root.Data == "rss" || root.Data == "feed" || root.Data == "RDF")
+}
+
+func parseFeed(body []byte, extra []FieldSelector) (Preview, error) {
+
+	doc, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	entries := xmlquery.Find(doc, "//item")
+	atom := false
+	if len(entries) == 0 {
+		entries = xmlquery.Find(doc, "//entry")
+		atom = true
+	}
+
+	if len(entries) == 0 {
+		return Preview{}, fmt.Errorf("feed has no items or entries")
+	}
+
+	cols := append([]string{}, feedColumns...)
+	for _, f := range extra {
+		cols = append(cols, f.Name)
+	}
+
+	var rows [][]string
+
+	for _, e := range entries {
+
+		var row []string
+
+		if atom {
+			row = []string{
+				feedText(e, "title"),
+				atomLink(e),
+				feedDate(firstNonEmpty(feedText(e, "published"), feedText(e, "updated"))),
+				feedText(e, "author/name"),
+				feedText(e, "id"),
+				firstNonEmpty(feedText(e, "summary"), feedText(e, "content")),
+			}
+		} else {
+			row = []string{
+				feedText(e, "title"),
+				feedText(e, "link"),
+				feedDate(firstNonEmpty(feedText(e, "pubDate"), feedText(e, "dc:date"))),
+				firstNonEmpty(feedText(e, "author"), feedText(e, "dc:creator")),
+				feedText(e, "guid"),
+				feedText(e, "description"),
+			}
+		}
+
+		for _, f := range extra {
+			n := xmlquery.FindOne(e, f.Path)
+			switch {
+			case n == nil:
+				row = append(row, "")
+			case f.Attr != "":
+				row = append(row, strings.TrimSpace(n.SelectAttr(f.Attr)))
+			default:
+				row = append(row, strings.TrimSpace(n.InnerText()))
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return buildPreview(cols, rows)
+}
+
+func feedText(n *xmlquery.Node, path string) string {
+
+	found := xmlquery.FindOne(n, path)
+	if found == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(found.InnerText())
+}
+
+// atomLink prefers the rel="alternate" link, which is the entry's page.
+func atomLink(e *xmlquery.Node) string {
+
+	var fallback string
+
+	for _, l := range xmlquery.Find(e, "link") {
+		rel := l.SelectAttr("rel")
+		if rel == "" || rel == "alternate" {
+			return l.SelectAttr("href")
+		}
+		if fallback == "" {
+			fallback = l.SelectAttr("href")
+		}
+	}
+
+	return fallback
+}
+
+// feedDate rewrites feed timestamps as UTC "YYYY-MM-DD HH:MM:SS" so the
+// column is inferred as DATETIME; unparseable values pass through.
+func feedDate(v string) string {
+
+	for _, l := range feedDateLayouts {
+		if t, err := time.Parse(l, v); err == nil {
+			return t.UTC().Format("2006-01-02 15:04:05")
+		}
+	}
+
+	return v
+}
+
+func firstNonEmpty(values ...string) string {
+
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sync"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// FETCH OPTIONS ///////////////////////
+///////////////////////////////////////////////////////////
+
+// FetchOptions carries credentials for sources behind a login or API key.
+// A cookie jar is kept for the lifetime of the request, so cookies set by
+// the first page are sent with redirects and later pages.
+type FetchOptions struct {
+	Headers     map[string]string `json:"headers"`
+	BasicAuth   *BasicAuth        `json:"basic_auth,omitempty"`
+	BearerToken string            `json:"bearer_token"`
+	Cookies     map[string]string `json:"cookies"`
+
+	// Proxy routes the fetch through http://, https:// or socks5:// proxy,
+	// with optional user:password. Empty falls back to FETCH_PROXY and
+	// then the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY variables.
+	Proxy string `json:"proxy"`
+
+	jarOnce sync.Once
+	jar     http.CookieJar
+}
+
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+const redacted = "***"
+
+// apply sets the configured headers, auth and cookies on an outgoing request.
+func (o *FetchOptions) apply(req *http.Request) {
+
+	if o == nil {
+		return
+	}
+
+	for k, v := range o.authHeaders() {
+		req.Header.Set(k, v)
+	}
+
+	for name, value := range o.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+}
+
+// authHeaders merges custom headers with the Authorization header implied
+// by basic auth or a bearer token.
+func (o *FetchOptions) authHeaders() map[string]string {
+
+	h := map[string]string{}
+
+	if o == nil {
+		return h
+	}
+
+	for k, v := range o.Headers {
+		h[k] = v
+	}
+
+	switch {
+	case o.BearerToken != "":
+		h["Authorization"] = "Bearer " + o.BearerToken
+	case o.BasicAuth != nil:
+		cred := o.BasicAuth.Username + ":" + o.BasicAuth.Password
+		h["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(cred))
+	}
+
+	return h
+}
+
+// proxyURL resolves the per-request proxy or the FETCH_PROXY default.
+// A nil result means the environment proxy settings apply.
+func (o *FetchOptions) proxyURL() (*url.URL, error) {
+
+	raw := os.Getenv("FETCH_PROXY")
+	if o != nil && o.Proxy != "" {
+		raw = o.Proxy
+	}
+
+	if raw == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", redactURL(raw), err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	return u, nil
+}
+
+// httpClient builds the client for one fetch, wired to the cookie jar
+// and, when configured, the proxy.
+func (o *FetchOptions) httpClient() (*http.Client, error) {
+
+	client := &http.Client{Jar: o.cookieJar()}
+
+	proxy, err := o.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+
+	if proxy != nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = http.ProxyURL(proxy)
+		client.Transport = t
+	}
+
+	return client, nil
+}
+
+func (o *FetchOptions) cookieJar() http.CookieJar {
+
+	if o == nil {
+		return nil
+	}
+
+	o.jarOnce.Do(func() {
+		o.jar, _ = cookiejar.New(nil)
+	})
+
+	return o.jar
+}
+
+// redact returns a copy that is safe to log or publish: every header,
+// cookie and credential value is masked, only the names are kept.
+func (o *FetchOptions) redact() *FetchOptions {
+
+	if o == nil {
+		return nil
+	}
+
+	r := &FetchOptions{}
+
+	if len(o.Headers) > 0 {
+		r.Headers = map[string]string{}
+		for k := range o.Headers {
+			r.Headers[k] = redacted
+		}
+	}
+
+	if len(o.Cookies) > 0 {
+		r.Cookies = map[string]string{}
+		for k := range o.Cookies {
+			r.Cookies[k] = redacted
+		}
+	}
+
+	if o.BasicAuth != nil {
+		r.BasicAuth = &BasicAuth{Username: o.BasicAuth.Username, Password: redacted}
+	}
+
+	if o.BearerToken != "" {
+		r.BearerToken = redacted
+	}
+
+	r.Proxy = redactURL(o.Proxy)
+
+	return r
+}
+
+// redactURL masks a password embedded in a URL's user info.
+func redactURL(raw string) string {
+
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+
+	return u.String()
+}
@@ -0,0 +1,74 @@
+package main
+
+import "fmt"
+
+///////////////////////////////////////////////////////////
+//////////////////// ROW FILTER //////////////////////////
+///////////////////////////////////////////////////////////
+
+// A row filter is an expression (see expr.go) such as
+// "country == 'US' AND revenue > 0". Only rows it holds for are inserted;
+// a NULL result drops the row, as in a SQL WHERE clause. It sees cleaned
+// and mapped cells and the derived columns.
+
+// compileFilter parses a filter over the table's columns.
+func compileFilter(src string, cols []string) (expr, error) {
+
+	e, err := compileExpr(src, cols)
+	if err != nil {
+		return nil, fmt.Errorf("filter: %w", err)
+	}
+
+	return e, nil
+}
+
+// filterRows drops the preview rows the filter rejects and counts them
+// in p.Filtered. Cells are cleaned by clean and mapped first, as the
+// consumer will see them.
+func filterRows(p Preview, src string, clean func(int, string) string, mappings []columnMapping) (Preview, error) {
+
+	filter, err := compileFilter(src, p.Columns)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	kept := p.Rows[:0]
+	for _, row := range p.Rows {
+		cleaned := make([]string, len(p.Columns))
+		for i := range cleaned {
+			if i < len(row) {
+				cleaned[i] = clean(i, row[i])
+			}
+		}
+		if truthy(filter.eval(mapRow(cleaned, mappings))) {
+			kept = append(kept, row)
+		} else {
+			p.Filtered++
+		}
+	}
+	p.Rows = kept
+
+	return p, nil
+}
+
+// filterStream drops the cleaned rows the filter rejects on their way to
+// MySQL.
+type filterStream struct {
+	rowStream
+	filter  expr
+	dropped int
+}
+
+func (s *filterStream) Next() ([]string, error) {
+
+	for {
+		row, err := s.rowStream.Next()
+		if err != nil {
+			return nil, err
+		}
+		if truthy(s.filter.eval(row)) {
+			return row, nil
+		}
+		s.dropped++
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// FIXED-WIDTH SOURCE //////////////////
+///////////////////////////////////////////////////////////
+
+// parseFixedWidth splits each line of a fixed-width export into columns.
+// widths gives the character width of every column; when empty the
+// layout is guessed from character positions that are blank on every
+// line. The first non-blank line after the banner lines is the header,
+// unless the layout supplies column names.
+func parseFixedWidth(body []byte, widths []int, layout gridLayout) (Preview, error) {
+
+	text := strings.ReplaceAll(string(body), "\r\n", "\n")
+
+	var lines [][]rune
+	for _, l := range strings.Split(text, "\n") {
+		if strings.TrimSpace(l) != "" {
+			lines = append(lines, []rune(strings.ReplaceAll(l, "\t", "    ")))
+		}
+	}
+
+	// Banner lines would break the gutter guess, so drop them first.
+	if layout.Skip >= len(lines) {
+		lines = nil
+	} else {
+		lines = lines[layout.Skip:]
+	}
+
+	if len(lines) == 0 {
+		return Preview{}, fmt.Errorf("no columns found in table")
+	}
+
+	starts, err := columnStarts(lines, widths)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	records := make([][]string, len(lines))
+	for i, l := range lines {
+		records[i] = sliceFixed(l, starts)
+	}
+
+	cols, rows := splitHeader(records, gridLayout{Names: layout.Names, Transpose: layout.Transpose, Scan: layout.Scan})
+	return buildPreview(cols, rows)
+}
+
+// columnStarts returns the offset of every column plus a final end
+// offset (-1 meaning "to the end of the line").
+func columnStarts(lines [][]rune, widths []int) ([]int, error) {
+
+	if len(widths) > 0 {
+		starts := []int{0}
+		for _, w := range widths {
+			if w <= 0 {
+				return nil, fmt.Errorf("column widths must be positive")
+			}
+			starts = append(starts, starts[len(starts)-1]+w)
+		}
+		return starts, nil
+	}
+
+	maxLen := 0
+	for _, l := range lines {
+		if len(l) > maxLen {
+			maxLen = len(l)
+		}
+	}
+
+	used := make([]bool, maxLen)
+	for _, l := range lines {
+		for i, r := range l {
+			if r != ' ' {
+				used[i] = true
+			}
+		}
+	}
+
+	var segments []int
+	for i := range used {
+		if used[i] && (i == 0 || !used[i-1]) {
+			segments = append(segments, i)
+		}
+	}
+
+	// Single spaces inside values ("Alice Smith") also leave blank
+	// gutters, so a segment only starts a column when the header line
+	// has text in it; otherwise it belongs to the column on its left.
+	header := lines[0]
+
+	var starts []int
+	for i, from := range segments {
+		to := len(used)
+		if i+1 < len(segments) {
+			to = segments[i+1]
+		}
+		if i == 0 || hasText(header, from, to) {
+			starts = append(starts, from)
+		}
+	}
+
+	if len(starts) < 2 {
+		return nil, fmt.Errorf("could not detect column boundaries, pass column_widths")
+	}
+
+	// Columns run up to the next one so right-aligned values stay whole.
+	starts[0] = 0
+	return append(starts, -1), nil
+}
+
+func sliceFixed(line []rune, starts []int) []string {
+
+	cells := make([]string, len(starts)-1)
+
+	for i := 0; i < len(starts)-1; i++ {
+
+		from, to := starts[i], starts[i+1]
+		if to == -1 || to > len(line) {
+			to = len(line)
+		}
+		if from >= to {
+			continue
+		}
+
+		cells[i] = strings.TrimSpace(string(line[from:to]))
+	}
+
+	return cells
+}
+
+func hasText(line []rune, from, to int) bool {
+
+	for i := from; i < to && i < len(line); i++ {
+		if line[i] != ' ' {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// CURRENCY CONVERSION /////////////////
+///////////////////////////////////////////////////////////
+
+// CurrencyConversion adds <column>_<to> next to a money column, holding
+// its amounts converted to one currency. Each cell's currency is its own
+// symbol or code, else the from_column cell, else from.
+type CurrencyConversion struct {
+	Column     string `json:"column"`
+	To         string `json:"to"`          // ISO code, e.g. "USD"
+	From       string `json:"from"`        // currency of unmarked cells
+	FromColumn string `json:"from_column"` // column holding each row's currency code
+}
+
+// FX rates are units of a currency per unit of a common base, e.g.
+// {"USD": 1, "EUR": 0.92}: converting A to B multiplies by rate[B] /
+// rate[A]. They come from the request's fx_rates, over those of the
+// FX_RATES_URL API ({"base": "USD", "rates": {...}}), which are fetched
+// at most once per FX_RATES_TTL (default 1h).
+var (
+	fxRatesURL = os.Getenv("FX_RATES_URL")
+	fxRatesTTL = fxTTLFromEnv()
+
+	fxMu      sync.Mutex
+	fxRates   map[string]float64
+	fxFetched time.Time
+)
+
+func fxTTLFromEnv() time.Duration {
+
+	if d, err := time.ParseDuration(os.Getenv("FX_RATES_TTL")); err == nil && d > 0 {
+		return d
+	}
+
+	return time.Hour
+}
+
+// apiRates returns the rates of FX_RATES_URL, or nil when it is unset.
+func apiRates() (map[string]float64, error) {
+
+	if fxRatesURL == "" {
+		return nil, nil
+	}
+
+	fxMu.Lock()
+	defer fxMu.Unlock()
+
+	if fxRates != nil && time.Since(fxFetched) < fxRatesTTL {
+		return fxRates, nil
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(fxRatesURL)
+	if err != nil {
+		return nil, fmt.Errorf("fx rates: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fx rates: %s returned %s", redactURL(fxRatesURL), resp.Status)
+	}
+
+	var body struct {
+		Base  string             `json:"base"`
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("fx rates: %w", err)
+	}
+
+	if body.Base != "" {
+		if _, ok := body.Rates[body.Base]; !ok {
+			body.Rates[body.Base] = 1
+		}
+	}
+
+	fxRates, fxFetched = body.Rates, time.Now()
+	fmt.Printf("💱 Loaded %d FX rates from %s\n", len(fxRates), redactURL(fxRatesURL))
+
+	return fxRates, nil
+}
+
+// requestRates merges the API rates with the request's own.
+func requestRates(req IngestRequest) (map[string]float64, error) {
+
+	api, err := apiRates()
+	if err != nil && len(req.FXRates) == 0 {
+		return nil, err
+	}
+
+	rates := map[string]float64{}
+	for code, r := range api {
+		rates[strings.ToUpper(code)] = r
+	}
+	for code, r := range req.FXRates {
+		if r <= 0 {
+			return nil, fmt.Errorf("fx_rates: rate for %s must be positive", code)
+		}
+		rates[strings.ToUpper(code)] = r
+	}
+
+	return rates, nil
+}
+
+// currencyConversion is a conversion resolved against the row layout.
+type currencyConversion struct {
+	col     int
+	fromCol int // -1 without from_column
+	from    string
+	to      string
+	name    string
+}
+
+// compileConversions resolves the conversions of req against cols, the
+// columns before any converted one is added.
+func compileConversions(req IngestRequest, cols []string) ([]currencyConversion, map[string]float64, error) {
+
+	rates, err := requestRates(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	var out []currencyConversion
+
+	for _, c := range req.Convert {
+
+		col := renamedColumn(c.Column, req.Rename)
+		i, ok := index[col]
+		if !ok {
+			return nil, nil, fmt.Errorf("convert: unknown column %q (available: %s)", col, strings.Join(cols, ", "))
+		}
+
+		conv := currencyConversion{col: i, fromCol: -1, from: strings.ToUpper(c.From), to: strings.ToUpper(c.To)}
+		if _, ok := rates[conv.to]; !ok {
+			return nil, nil, fmt.Errorf("convert %s: no FX rate for %q", col, c.To)
+		}
+		if conv.from != "" {
+			if _, ok := rates[conv.from]; !ok {
+				return nil, nil, fmt.Errorf("convert %s: no FX rate for %q", col, c.From)
+			}
+		}
+
+		if c.FromColumn != "" {
+			from := renamedColumn(c.FromColumn, req.Rename)
+			j, ok := index[from]
+			if !ok {
+				return nil, nil, fmt.Errorf("convert %s: unknown from_column %q", col, from)
+			}
+			conv.fromCol = j
+		}
+
+		conv.name = col + "_" + strings.ToLower(conv.to)
+		if _, taken := index[conv.name]; taken {
+			return nil, nil, fmt.Errorf("convert %s: column %s already exists", col, conv.name)
+		}
+		index[conv.name] = -1
+
+		out = append(out, conv)
+	}
+
+	return out, rates, nil
+}
+
+// convertAmount converts one money cell, or returns "" when the cell is
+// not money or its currency has no rate.
+func (c currencyConversion) convertAmount(row []string, rates map[string]float64) string {
+
+	if c.col >= len(row) {
+		return ""
+	}
+
+	amount, code, ok := parseMoney(row[c.col])
+	if !ok {
+		return ""
+	}
+
+	if code == "" && c.fromCol >= 0 && c.fromCol < len(row) {
+		code = strings.ToUpper(strings.TrimSpace(row[c.fromCol]))
+	}
+	if code == "" {
+		code = c.from
+	}
+
+	from, ok := rates[code]
+	if !ok {
+		return ""
+	}
+
+	v, err := strconv.ParseFloat(amount, 64)
+	if err != nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(v*rates[c.to]/from, 'f', 4, 64)
+}
+
+// convertRow inserts each converted amount right after its column.
+func convertRow(row []string, convs []currencyConversion, rates map[string]float64) []string {
+
+	out := make([]string, 0, len(row)+len(convs))
+
+	for i, v := range row {
+		out = append(out, v)
+		for _, c := range convs {
+			if c.col == i {
+				out = append(out, c.convertAmount(row, rates))
+			}
+		}
+	}
+
+	return out
+}
+
+// convertCurrencies adds the converted columns to a preview. Cells whose
+// currency has no rate are left NULL.
+func convertCurrencies(p Preview, req IngestRequest) (Preview, error) {
+
+	if len(req.Convert) == 0 {
+		return p, nil
+	}
+
+	convs, rates, err := compileConversions(req, p.Columns)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	for i, r := range p.Rows {
+		p.Rows[i] = convertRow(alignRow(r, len(p.Columns)), convs, rates)
+	}
+
+	var cols []string
+	for i, name := range p.Columns {
+		cols = append(cols, name)
+		for _, c := range convs {
+			if c.col == i {
+				cols = append(cols, c.name)
+				p.Types[c.name] = "DECIMAL(20,4)"
+			}
+		}
+	}
+	p.Columns = cols
+
+	fmt.Printf("💱 Converted %d money columns\n", len(convs))
+
+	return p, nil
+}
+
+// convertedColumns lists the columns the conversions of req add.
+func convertedColumns(req IngestRequest) map[string]bool {
+
+	names := map[string]bool{}
+	for _, c := range req.Convert {
+		names[renamedColumn(c.Column, req.Rename)+"_"+strings.ToLower(c.To)] = true
+	}
+
+	return names
+}
+
+// convertStream converts the money columns of a streamed source.
+type convertStream struct {
+	rowStream
+	convs []currencyConversion
+	rates map[string]float64
+}
+
+func (s *convertStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return convertRow(row, s.convs, s.rates), nil
+}
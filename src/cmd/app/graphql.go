@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// GRAPHQL SOURCE //////////////////////
+///////////////////////////////////////////////////////////
+
+// GraphQLQuery is POSTed to the request URL. records_path selects the
+// result list relative to "data"; when empty, single-field objects are
+// followed down until a list is found.
+type GraphQLQuery struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+}
+
+type graphQLResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func loadGraphQL(req IngestRequest) (Preview, error) {
+
+	if req.GraphQL.Query == "" {
+		return Preview{}, fmt.Errorf("graphql query required")
+	}
+
+	body, err := runGraphQL(req.URL, req.GraphQL, req.FetchOptions)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	var resp graphQLResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return Preview{}, fmt.Errorf("invalid graphql response: %w", err)
+	}
+
+	if len(resp.Errors) > 0 {
+		return Preview{}, fmt.Errorf("graphql error: %s", resp.Errors[0].Message)
+	}
+
+	path := req.RecordsPath
+	if path == "" {
+		path = graphQLListPath(resp.Data)
+	}
+
+	records, err := jsonRecordsAt(resp.Data, path)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	list, _ := json.Marshal(unwrapEdges(records))
+
+	return parseJSONRecords(list, "")
+}
+
+func runGraphQL(url string, q *GraphQLQuery, opts *FetchOptions) ([]byte, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	fmt.Printf("🌐 Querying %s\n", redactURL(url))
+
+	payload, _ := json.Marshal(q)
+
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	opts.apply(req)
+
+	client, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// GraphQL servers often answer errors with 4xx plus an errors array;
+	// only give up here when there is nothing to decode.
+	if resp.StatusCode >= 400 && !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		return nil, fmt.Errorf("source returned %s", resp.Status)
+	}
+
+	return body, nil
+}
+
+// graphQLListPath follows objects with a single field (the usual shape
+// of a query selecting one root field) until it reaches a list.
+func graphQLListPath(data json.RawMessage) string {
+
+	path := ""
+	cur := data
+
+	for {
+		var obj map[string]json.RawMessage
+		if json.Unmarshal(cur, &obj) != nil || len(obj) != 1 {
+			return path
+		}
+
+		for k, v := range obj {
+			if path != "" {
+				path += "."
+			}
+			path += k
+			cur = v
+		}
+
+		if bytes.HasPrefix(bytes.TrimSpace(cur), []byte("[")) {
+			return path
+		}
+	}
+}
+
+// unwrapEdges turns Relay connection edges ({"node": {...}}) into the
+// nodes themselves so their fields become columns.
+func unwrapEdges(records []json.RawMessage) []json.RawMessage {
+
+	nodes := make([]json.RawMessage, 0, len(records))
+
+	for _, r := range records {
+		var edge map[string]json.RawMessage
+		if json.Unmarshal(r, &edge) != nil {
+			return records
+		}
+		node, ok := edge["node"]
+		if !ok {
+			return records
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// GRPC STREAMING //////////////////////
+///////////////////////////////////////////////////////////
+
+// The service is described in ingest.proto. Its only messages are
+// google.protobuf.Struct, so the descriptor below is written by hand
+// instead of generated.
+
+const grpcBatchRows = 1000
+
+var ingestServiceDesc = grpc.ServiceDesc{
+	ServiceName: "fintech.ingest.Ingest",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamRows",
+			Handler:       streamRowsHandler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "ingest.proto",
+}
+
+func startGRPCServer() {
+
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = "9090"
+	}
+
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		fmt.Println("gRPC listen failed:", err)
+		return
+	}
+
+	srv := grpc.NewServer()
+	srv.RegisterService(&ingestServiceDesc, nil)
+
+	fmt.Println("🚀 gRPC server running on :" + port)
+
+	if err := srv.Serve(lis); err != nil {
+		fmt.Println("gRPC server stopped:", err)
+	}
+}
+
+// rowBatcher collects streamed rows and publishes them as jobs of
+// grpcBatchRows. The first job uses the requested mode, the rest append.
+type rowBatcher struct {
+	req     IngestRequest
+	keys    []string
+	columns []string
+	types   map[string]string
+	rows    [][]string
+	jobIDs  []string
+	total   int
+}
+
+func streamRowsHandler(_ interface{}, stream grpc.ServerStream) error {
+
+	if reason := busyReason(); reason != "" {
+		return status.Error(codes.ResourceExhausted, "ingestion paused: "+reason)
+	}
+
+	var b rowBatcher
+
+	for {
+		msg := &structpb.Struct{}
+
+		err := stream.RecvMsg(msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := b.add(msg.AsMap()); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+
+		if len(b.rows) >= grpcBatchRows {
+			if reason := busyReason(); reason != "" {
+				return status.Errorf(codes.ResourceExhausted, "ingestion paused after %d rows (jobs %v): %s", b.total, b.jobIDs, reason)
+			}
+			if err := b.flush(); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+
+	if err := b.flush(); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ids := make([]interface{}, len(b.jobIDs))
+	for i, id := range b.jobIDs {
+		ids[i] = id
+	}
+
+	reply, err := structpb.NewStruct(map[string]interface{}{
+		"job_ids": ids,
+		"rows":    b.total,
+	})
+	if err != nil {
+		return err
+	}
+
+	return stream.SendMsg(reply)
+}
+
+func (b *rowBatcher) add(m map[string]interface{}) error {
+
+	if b.req.Table == "" {
+		b.req.Table, _ = m["table"].(string)
+		b.req.Mode, _ = m["mode"].(string)
+		b.req.Dedup, _ = m["dedup"].(bool)
+		b.req.Priority, _ = m["priority"].(string)
+		b.req.Sink, _ = m["sink"].(string)
+		if keys, ok := m["upsert_keys"].([]interface{}); ok {
+			for _, k := range keys {
+				b.req.UpsertKeys = append(b.req.UpsertKeys, jsonCell(k))
+			}
+		}
+
+		if b.req.Table == "" {
+			return fmt.Errorf("first message must set table")
+		}
+
+		if err := validPriority(b.req.Priority); err != nil {
+			return err
+		}
+
+		if err := validSink(b.req); err != nil {
+			return err
+		}
+
+		if cols, ok := m["columns"].([]interface{}); ok {
+			for _, c := range cols {
+				b.keys = append(b.keys, jsonCell(c))
+			}
+			b.columns = b.keys
+		}
+
+		if types, ok := m["types"].(map[string]interface{}); ok {
+			b.types = map[string]string{}
+			for k, v := range types {
+				b.types[k] = jsonCell(v)
+			}
+		}
+	}
+
+	rows, _ := m["rows"].([]interface{})
+
+	for _, r := range rows {
+		switch row := r.(type) {
+
+		case []interface{}:
+			cells := make([]string, len(row))
+			for i, v := range row {
+				cells[i] = jsonCell(v)
+			}
+			b.rows = append(b.rows, cells)
+
+		case map[string]interface{}:
+			if b.keys == nil {
+				// Struct fields carry no order, so sort the keys.
+				for k := range row {
+					b.keys = append(b.keys, k)
+				}
+				sort.Strings(b.keys)
+				b.columns = b.keys
+			}
+			cells := make([]string, len(b.keys))
+			for i, c := range b.keys {
+				if v, ok := row[c]; ok {
+					cells[i] = jsonCell(v)
+				}
+			}
+			b.rows = append(b.rows, cells)
+
+		default:
+			return fmt.Errorf("rows must be arrays or objects")
+		}
+	}
+
+	return nil
+}
+
+func (b *rowBatcher) flush() error {
+
+	if len(b.rows) == 0 {
+		return nil
+	}
+
+	if len(b.columns) == 0 {
+		return fmt.Errorf("columns required for array rows")
+	}
+
+	var p Preview
+
+	if len(b.jobIDs) == 0 {
+		var err error
+		p, err = buildPreview(b.columns, b.rows)
+		if err != nil {
+			return err
+		}
+		if p, err = applyTypeOverrides(p, b.types, nil); err != nil {
+			return err
+		}
+		if _, err := upsertKeyColumns(b.req, p.Columns); err != nil {
+			return err
+		}
+		// Later batches reuse the schema the first one settled on.
+		b.columns, b.types = p.Columns, p.Types
+	} else {
+		p = Preview{Columns: b.columns, Types: b.types, Rows: b.rows}
+		if b.req.Mode == "create" {
+			b.req.Mode = "append"
+		}
+	}
+
+	b.jobIDs = append(b.jobIDs, startJob(p, b.req))
+	b.total += len(b.rows)
+	b.rows = nil
+
+	return nil
+}
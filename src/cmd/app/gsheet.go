@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// GOOGLE SHEETS ///////////////////////
+///////////////////////////////////////////////////////////
+
+// Sheets are read through the Sheets API when a service-account key is
+// configured (GSHEETS_CREDENTIALS_FILE), otherwise through the CSV export
+// of a published or link-shared sheet.
+
+var sheetIDPattern = regexp.MustCompile(`/spreadsheets/d/([a-zA-Z0-9_-]+)`)
+var sheetGIDPattern = regexp.MustCompile(`[#?&]gid=([0-9]+)`)
+
+func isGoogleSheetURL(u string) bool {
+	return strings.Contains(u, "docs.google.com/spreadsheets/d/")
+}
+
+func loadGoogleSheet(req IngestRequest) (Preview, error) {
+
+	m := sheetIDPattern.FindStringSubmatch(req.URL)
+	if m == nil {
+		return Preview{}, fmt.Errorf("not a Google Sheets URL: %s", req.URL)
+	}
+	id := m[1]
+
+	if keyFile := os.Getenv("GSHEETS_CREDENTIALS_FILE"); keyFile != "" {
+		return loadSheetFromAPI(id, req.Sheet, req.Range, keyFile, gridLayoutFor(req))
+	}
+
+	q := url.Values{}
+	q.Set("tqx", "out:csv")
+	q.Set("headers", "1")
+
+	if req.Sheet != "" {
+		q.Set("sheet", req.Sheet)
+	} else if g := sheetGIDPattern.FindStringSubmatch(req.URL); g != nil {
+		q.Set("gid", g[1])
+	}
+
+	if req.Range != "" {
+		q.Set("range", req.Range)
+	}
+
+	exportURL := "https://docs.google.com/spreadsheets/d/" + id + "/gviz/tq?" + q.Encode()
+
+	src, err := fetchSource(exportURL, req.FetchOptions)
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to export sheet: %w", err)
+	}
+
+	return parseCSV(src.Body, ',', gridLayoutFor(req))
+}
+
+func loadSheetFromAPI(id, sheet, cellRange, keyFile string, layout gridLayout) (Preview, error) {
+
+	key, err := os.ReadFile(keyFile)
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to read service account key: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	creds, err := google.CredentialsFromJSON(ctx, key,
+		"https://www.googleapis.com/auth/spreadsheets.readonly")
+	if err != nil {
+		return Preview{}, fmt.Errorf("invalid service account key: %w", err)
+	}
+
+	// The API always needs a range; a bare A1 range reads the first sheet.
+	a1 := cellRange
+	switch {
+	case sheet != "" && cellRange != "":
+		a1 = "'" + sheet + "'!" + cellRange
+	case sheet != "":
+		a1 = "'" + sheet + "'"
+	case cellRange == "":
+		a1 = "A:ZZ"
+	}
+
+	endpoint := "https://sheets.googleapis.com/v4/spreadsheets/" + id +
+		"/values/" + url.PathEscape(a1)
+
+	httpReq, _ := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+
+	tok, err := creds.TokenSource.Token()
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to obtain Sheets token: %w", err)
+	}
+	tok.SetAuthHeader(httpReq)
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return Preview{}, fmt.Errorf("sheets API returned %s", resp.Status)
+	}
+
+	var body struct {
+		Values [][]string `json:"values"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Preview{}, fmt.Errorf("failed to decode sheet values: %w", err)
+	}
+
+	cols, rows := splitHeader(body.Values, layout)
+	return buildPreview(cols, rows)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// HEADER DETECTION ////////////////////
+///////////////////////////////////////////////////////////
+
+// defaultHeaderScanRows is how far down a source the header is looked for
+// when header_scan_rows is not set.
+const defaultHeaderScanRows = 10
+
+// headerScanRows is the detection window for a request.
+func headerScanRows(req IngestRequest) int {
+
+	if req.HeaderScanRows != 0 {
+		return req.HeaderScanRows
+	}
+
+	return defaultHeaderScanRows
+}
+
+// detectHeaderRow finds the header among the first scan rows: the first
+// row that fills at least half the table with distinct names and is either
+// marked as a header (th[i], HTML <th> cells) or all text above a column
+// that holds numbers or dates further down. Banner and title rows above
+// the header are short or repeat one value, so they are passed over.
+// It returns -1 when no row looks like a header.
+func detectHeaderRow(rows [][]string, th []bool, scan int) int {
+
+	if scan <= 0 {
+		return -1
+	}
+
+	window := rows[:min(scan, len(rows))]
+
+	width := 0
+	for _, r := range window {
+		width = max(width, len(r))
+	}
+
+	if width < 2 {
+		return -1
+	}
+
+	for i, r := range window {
+
+		if !distinctNames(r, width) {
+			continue
+		}
+
+		if i < len(th) && th[i] {
+			return i
+		}
+
+		if allText(r) && typedBelow(r, rows[i+1:min(i+1+scan, len(rows))]) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// distinctNames reports whether a row fills at least half the width
+// (and two cells) with values that do not repeat.
+func distinctNames(r []string, width int) bool {
+
+	seen := map[string]bool{}
+
+	for _, v := range r {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		if seen[v] {
+			return false
+		}
+		seen[v] = true
+	}
+
+	return len(seen) >= 2 && len(seen)*2 >= width
+}
+
+// allText reports whether no cell reads as a number or date. Years are
+// allowed, since period columns are often named 2023, 2024.
+func allText(r []string) bool {
+
+	for _, v := range r {
+		if isYear(v) {
+			continue
+		}
+		if looksTyped(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// typedBelow reports whether some column where the candidate has a name
+// holds a number or date in the rows below it.
+func typedBelow(header []string, below [][]string) bool {
+
+	for _, r := range below {
+		for c, v := range r {
+			if c < len(header) && strings.TrimSpace(header[c]) != "" && looksTyped(v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func looksTyped(v string) bool {
+
+	val := cleanForInference(v)
+	if val == "" {
+		return false
+	}
+
+	if _, err := strconv.ParseFloat(val, 64); err == nil {
+		return true
+	}
+
+	return matchesAnyLayout(val, dateLayouts) || matchesAnyLayout(val, dateTimeLayouts)
+}
+
+func isYear(v string) bool {
+
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	return err == nil && n >= 1900 && n <= 2100
+}
@@ -0,0 +1,117 @@
+package main
+
+import "strings"
+
+///////////////////////////////////////////////////////////
+//////////////////// DIGIT IDENTIFIERS ///////////////////
+///////////////////////////////////////////////////////////
+
+// Zip codes, account numbers and CUSIP-like codes are digits but not
+// numbers: stored as INT, "02134" becomes 2134. A column of digit strings
+// of one fixed width stays text when they are consistently zero-padded
+// (zeroLedShare of them with a leading zero), or when its name says it
+// holds codes (account_no, isin, zip) and either a value has a leading
+// zero or there are fixedWidthValues values of fixedWidthDigits or more.
+// A stray "0123" among numbers, or epoch seconds, which have a fixed
+// width too, stay numbers.
+
+const (
+	fixedWidthDigits = 6
+	fixedWidthValues = 10  // values needed before a fixed width counts
+	zeroLedShare     = 0.8 // of the values, zero-led for padding to count
+)
+
+// digitShape tracks the shape of one column's cleaned values.
+type digitShape struct {
+	digits  int  // plain digit strings seen
+	zeroLed int  // of those, with a leading zero
+	width   int  // width of the first digit string
+	varied  bool // a digit string of another width was seen
+	other   bool // a value that is not a plain digit string was seen
+}
+
+func (d *digitShape) add(val string) {
+
+	if !isDigits(val) {
+		d.other = true
+		return
+	}
+
+	if d.digits == 0 {
+		d.width = len(val)
+	} else if len(val) != d.width {
+		d.varied = true
+	}
+
+	d.digits++
+
+	if len(val) > 1 && val[0] == '0' {
+		d.zeroLed++
+	}
+}
+
+// identifier reports whether the column named name holds codes rather
+// than numbers.
+func (d *digitShape) identifier(name string) bool {
+
+	if d.other || d.varied || d.digits == 0 {
+		return false
+	}
+
+	if float64(d.zeroLed) >= zeroLedShare*float64(d.digits) {
+		return true
+	}
+
+	if !codeColumnName(name) {
+		return false
+	}
+
+	return d.zeroLed > 0 || (d.width >= fixedWidthDigits && d.digits >= fixedWidthValues)
+}
+
+// codeColumnWords are the words of a column name that mark it as
+// holding codes.
+var codeColumnWords = map[string]bool{
+	"id": true, "code": true, "no": true, "nr": true, "number": true, "ref": true, "reference": true,
+	"account": true, "acct": true, "iban": true, "routing": true, "aba": true,
+	"isin": true, "cusip": true, "sedol": true, "figi": true, "lei": true, "swift": true, "bic": true,
+	"zip": true, "zipcode": true, "postcode": true, "postal": true,
+	"ssn": true, "tin": true, "ein": true, "phone": true, "sku": true,
+}
+
+// codeColumnName reports whether a word of name, split at anything but
+// letters and digits, is one of codeColumnWords, alone or run together
+// with an id, code or no (accountid, isincode).
+func codeColumnName(name string) bool {
+
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+	for _, w := range words {
+		if codeColumnWords[w] {
+			return true
+		}
+		for _, suffix := range []string{"id", "code", "no", "number"} {
+			if codeColumnWords[strings.TrimSuffix(w, suffix)] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func isDigits(s string) bool {
+
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDigitIdentifiers(t *testing.T) {
+
+	// seq returns n values counting up from start, printed with format.
+	seq := func(format string, start, n int) []string {
+		var vals []string
+		for i := 0; i < n; i++ {
+			vals = append(vals, fmt.Sprintf(format, start+i))
+		}
+		return vals
+	}
+
+	cases := []struct {
+		name   string
+		column string
+		values []string
+		want   bool
+	}{
+		{"zero-padded", "amount", seq("%06d", 120, 12), true},
+		{"stray leading zero", "qty", append(seq("%d", 1000, 9), "0123"), false},
+		{"zip with a leading zero", "zip", []string{"94105", "02134", "10001"}, true},
+		{"epoch seconds", "ts", seq("%d", 1700000000, 12), false},
+		{"account numbers", "account_no", seq("%d", 81234567, 12), true},
+		{"run-together name", "accountid", seq("%d", 81234567, 12), true},
+		{"too few to tell", "account_no", seq("%d", 81234567, 3), false},
+		{"varied widths", "order_id", []string{"0012", "00123", "001234"}, false},
+		{"not digits", "code", []string{"0A12", "0012"}, false},
+	}
+
+	for _, c := range cases {
+		var d digitShape
+		for _, v := range c.values {
+			d.add(v)
+		}
+		if got := d.identifier(c.column); got != c.want {
+			t.Errorf("%s: identifier(%q) = %v, want %v", c.name, c.column, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// IMAP ATTACHMENTS ////////////////////
+///////////////////////////////////////////////////////////
+
+// The mailbox poller runs when IMAP_HOST is set. Every IMAP_POLL_INTERVAL
+// it looks for unread messages in IMAP_MAILBOX matching IMAP_SUBJECT and
+// IMAP_FROM, starts one job per CSV/TSV/XLSX (or zipped) attachment into
+// IMAP_TABLE ("{file}" expands to the attachment name) with IMAP_MODE,
+// then marks the message read. While the queue is over its limits
+// (rejectWhenBusy) polls are skipped and the mail stays unread.
+
+var attachmentExts = map[string]bool{
+	".csv": true, ".tsv": true, ".tab": true, ".xlsx": true, ".zip": true, ".gz": true,
+}
+
+func startMailPoller() {
+
+	if os.Getenv("IMAP_HOST") == "" {
+		return
+	}
+
+	interval, err := time.ParseDuration(os.Getenv("IMAP_POLL_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	fmt.Printf("📬 Polling %s every %s\n", os.Getenv("IMAP_HOST"), interval)
+
+	for {
+		if err := pollMailbox(); err != nil {
+			fmt.Printf("⚠️  Mailbox poll failed: %v\n", err)
+		}
+		time.Sleep(interval)
+	}
+}
+
+func pollMailbox() error {
+
+	// Unread mail waits for the next poll.
+	if reason := busyReason(); reason != "" {
+		fmt.Printf("⏸️  Mailbox poll skipped, ingestion paused: %s\n", reason)
+		return nil
+	}
+
+	addr := os.Getenv("IMAP_HOST")
+	if !strings.Contains(addr, ":") {
+		addr += ":993"
+	}
+
+	c, err := client.DialTLS(addr, nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer c.Logout()
+
+	if err := c.Login(os.Getenv("IMAP_USER"), os.Getenv("IMAP_PASSWORD")); err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+
+	mailbox := os.Getenv("IMAP_MAILBOX")
+	if mailbox == "" {
+		mailbox = "INBOX"
+	}
+
+	if _, err := c.Select(mailbox, false); err != nil {
+		return fmt.Errorf("failed to select %s: %w", mailbox, err)
+	}
+
+	criteria := imap.NewSearchCriteria()
+	criteria.WithoutFlags = []string{imap.SeenFlag}
+	if subject := os.Getenv("IMAP_SUBJECT"); subject != "" {
+		criteria.Header.Add("Subject", subject)
+	}
+	if from := os.Getenv("IMAP_FROM"); from != "" {
+		criteria.Header.Add("From", from)
+	}
+
+	uids, err := c.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("search failed: %w", err)
+	}
+
+	if len(uids) == 0 {
+		return nil
+	}
+
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uids...)
+
+	// Peek so a message is only marked read once its attachments are queued.
+	section := &imap.BodySectionName{Peek: true}
+
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.UidFetch(seqset, []imap.FetchItem{section.FetchItem(), imap.FetchUid}, messages)
+	}()
+
+	processed := new(imap.SeqSet)
+
+	for msg := range messages {
+
+		body := msg.GetBody(section)
+		if body == nil {
+			continue
+		}
+
+		if err := ingestMessage(body); err != nil {
+			fmt.Printf("⚠️  Message %d skipped: %v\n", msg.Uid, err)
+			continue
+		}
+
+		processed.AddNum(msg.Uid)
+	}
+
+	if err := <-done; err != nil {
+		return fmt.Errorf("fetch failed: %w", err)
+	}
+
+	if processed.Empty() {
+		return nil
+	}
+
+	flags := []interface{}{imap.SeenFlag}
+	return c.UidStore(processed, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil)
+}
+
+// ingestMessage starts a job for every supported attachment of a message.
+func ingestMessage(r io.Reader) error {
+
+	mr, err := mail.CreateReader(r)
+	if err != nil {
+		return err
+	}
+
+	subject, _ := mr.Header.Subject()
+
+	table := os.Getenv("IMAP_TABLE")
+	if table == "" {
+		table = "mail_{file}"
+	}
+
+	mode := os.Getenv("IMAP_MODE")
+	if mode == "" {
+		mode = "append"
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		h, ok := part.Header.(*mail.AttachmentHeader)
+		if !ok {
+			continue
+		}
+
+		name, _ := h.Filename()
+		if !attachmentExts[strings.ToLower(path.Ext(name))] {
+			continue
+		}
+
+		data, err := io.ReadAll(part.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		req := IngestRequest{
+			Table: tableForFile(table, name),
+			Mode:  mode,
+		}
+
+		p, err := parseDocument(req, &fetchedSource{URL: name, Body: data})
+		if err != nil {
+			fmt.Printf("⚠️  Attachment %s (%q) not ingested: %v\n", name, subject, err)
+			continue
+		}
+
+		jobID := startJob(p, req)
+		fmt.Printf("📎 Attachment %s from %q → job %s\n", name, subject, jobID)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// INFERENCE OPTIONS ///////////////////
+///////////////////////////////////////////////////////////
+
+// inferenceOptions tune type inference: the share of filled values that
+// must fit a type, and how many rows are read to decide.
+type inferenceOptions struct {
+	Threshold  float64
+	SampleRows int // 0 reads every row
+}
+
+// defaultInference comes from INFERENCE_THRESHOLD and
+// INFERENCE_SAMPLE_ROWS, falling back to 0.8 over every row.
+var defaultInference = inferenceFromEnv()
+
+func inferenceFromEnv() inferenceOptions {
+
+	o := inferenceOptions{Threshold: 0.8}
+
+	if v, err := strconv.ParseFloat(os.Getenv("INFERENCE_THRESHOLD"), 64); err == nil && v > 0 && v <= 1 {
+		o.Threshold = v
+	}
+
+	if n, err := strconv.Atoi(os.Getenv("INFERENCE_SAMPLE_ROWS")); err == nil && n > 0 {
+		o.SampleRows = n
+	}
+
+	return o
+}
+
+// inferenceFor applies a request's inference_threshold and
+// inference_sample_rows over the defaults.
+func inferenceFor(req IngestRequest) (inferenceOptions, error) {
+
+	o := defaultInference
+
+	if req.InferenceThreshold != nil {
+		if t := *req.InferenceThreshold; t <= 0 || t > 1 {
+			return o, fmt.Errorf("inference_threshold must be above 0 and at most 1, got %g", t)
+		}
+		o.Threshold = *req.InferenceThreshold
+	}
+
+	if req.InferenceSampleRows < 0 {
+		return o, fmt.Errorf("inference_sample_rows must not be negative")
+	}
+	if req.InferenceSampleRows > 0 {
+		o.SampleRows = req.InferenceSampleRows
+	}
+
+	return o, nil
+}
+
+// sampleRows picks n rows spread evenly over rows, so sorted sources
+// are not judged by their first rows only.
+func sampleRows(rows [][]string, n int) ([][]string, bool) {
+
+	if n <= 0 || len(rows) <= n {
+		return rows, false
+	}
+
+	sample := make([][]string, 0, n)
+	for i := 0; i < n; i++ {
+		sample = append(sample, rows[i*len(rows)/n])
+	}
+
+	return sample, true
+}
+
+// reinferTypes infers a preview's types again with request options.
+// Sources with their own schema keep it.
+func reinferTypes(p Preview, o inferenceOptions) Preview {
+
+	if o == defaultInference || p.typed {
+		return p
+	}
+
+	p.Types = inferTypesWith(p.Columns, p.Rows, func(_ int, v string) string {
+		return cleanForInference(v)
+	}, o)
+
+	return p
+}
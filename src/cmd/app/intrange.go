@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// INTEGER RANGES //////////////////////
+///////////////////////////////////////////////////////////
+
+// Integer columns get the narrowest of TINYINT, INT and BIGINT that holds
+// every observed value. Appending data that no longer fits widens the
+// existing column before the rows are inserted.
+
+// intRanks orders MySQL integer types from narrow to wide.
+var intRanks = map[string]int{
+	"TINYINT": 1, "SMALLINT": 2, "MEDIUMINT": 3, "INT": 4, "INTEGER": 4, "BIGINT": 5,
+}
+
+// intRange tracks the smallest and largest value of an integer column.
+type intRange struct {
+	min, max int64
+	seen     bool
+}
+
+func (r *intRange) add(n int64) {
+
+	if !r.seen || n < r.min {
+		r.min = n
+	}
+	if !r.seen || n > r.max {
+		r.max = n
+	}
+	r.seen = true
+}
+
+func (r *intRange) sqlType() string {
+
+	switch {
+	case r.min >= math.MinInt8 && r.max <= math.MaxInt8:
+		return "TINYINT"
+	case r.min >= math.MinInt32 && r.max <= math.MaxInt32:
+		return "INT"
+	default:
+		return "BIGINT"
+	}
+}
+
+// intBase strips the display width and attributes from a column type:
+// "int(11) unsigned" is INT.
+func intBase(t string) string {
+
+	t = strings.ToUpper(strings.TrimSpace(t))
+	if i := strings.IndexAny(t, "( "); i != -1 {
+		t = t[:i]
+	}
+
+	return t
+}
+
+// widerInt reports whether integer type next needs a wider column than cur.
+func widerInt(cur, next string) bool {
+
+	c, ok1 := intRanks[intBase(cur)]
+	n, ok2 := intRanks[intBase(next)]
+
+	return ok1 && ok2 && n > c
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// NATS JETSTREAM BUS //////////////////
+///////////////////////////////////////////////////////////
+
+// With MESSAGE_BUS=nats jobs go through NATS JetStream at NATS_URL
+// (nats://host:4222, credentials in the URL or a NATS_CREDS file), a
+// single small binary that suits edge and small deployments. Jobs are
+// published on the subject named like the Kafka topic, kept in a
+// file-backed work-queue stream of the same name (dots become
+// underscores). NATS_SUBJECTS splits the subject into that many,
+// "<topic>.0" to "<topic>.<n-1>", each table's jobs hashed to one of
+// them, each read by its own durable consumer. With PRIORITY_LANES the
+// high and low lanes publish on subjects of their own in the same stream,
+// split the same way.
+//
+// Consumers acknowledge with ack-all once a job is written, so the
+// stream keeps a crashed consumer's unfinished jobs and delivers them
+// again; stream sequence numbers serve as offsets. natsAckWait is long
+// enough for a job's chunks to arrive; messages redelivered while the
+// consumer still holds them are dropped.
+
+var natsURL = os.Getenv("NATS_URL")
+
+const natsAckWait = 3 * chunkTimeout
+
+var natsStreamChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+type natsBus struct {
+	conn     *nats.Conn
+	js       jetstream.JetStream
+	stream   string
+	subjects map[jobPriority][]string // by lane
+}
+
+func newNATSBus() (messageBus, error) {
+
+	if natsURL == "" {
+		return nil, fmt.Errorf("MESSAGE_BUS=nats needs NATS_URL")
+	}
+
+	n := 1
+	if v := os.Getenv("NATS_SUBJECTS"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			return nil, fmt.Errorf("NATS_SUBJECTS must be a positive number, got %q", v)
+		}
+	}
+
+	opts := []nats.Option{nats.Name("fintech_pipeline")}
+	if creds := os.Getenv("NATS_CREDS"); creds != "" {
+		opts = append(opts, nats.UserCredentials(creds))
+	}
+
+	conn, err := nats.Connect(natsURL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: %w", err)
+	}
+
+	b := &natsBus{conn: conn, js: js, stream: natsStreamChars.ReplaceAllString(jobsTopic, "_"), subjects: map[jobPriority][]string{}}
+
+	var filters []string
+	for _, lane := range lanes() {
+		topic := laneTopic(lane)
+		for i := 0; i < n; i++ {
+			subject := topic
+			if n > 1 {
+				subject = fmt.Sprintf("%s.%d", topic, i)
+			}
+			b.subjects[lane] = append(b.subjects[lane], subject)
+		}
+		filters = append(filters, topic, topic+".*")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      b.stream,
+		Subjects:  filters,
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: stream %s: %w", b.stream, err)
+	}
+
+	return b, nil
+}
+
+func (b *natsBus) Publish(jobID string, lane jobPriority, key string, values [][]byte) error {
+
+	subjects := b.subjects[lane]
+	subject := subjects[keyLane(key, len(subjects))]
+
+	for i, v := range values {
+		ack, err := b.js.PublishAsync(subject, v)
+		if err != nil {
+			return fmt.Errorf("nats: publish to %s: %w", subject, err)
+		}
+		go awaitPubAck(ack, delivery{jobID: jobID, message: i, messages: len(values)})
+	}
+
+	return nil
+}
+
+// awaitPubAck reports the stream's ack of one published message.
+func awaitPubAck(ack jetstream.PubAckFuture, d delivery) {
+
+	select {
+	case <-ack.Ok():
+		if d.message == d.messages-1 {
+			fmt.Printf("📤 Published job %s (%d messages)\n", d.jobID, d.messages)
+		}
+	case err := <-ack.Err():
+		failDelivery(d, err)
+	}
+}
+
+func (b *natsBus) Streams() ([]messageStream, error) {
+
+	var streams []messageStream
+
+	for _, lane := range lanes() {
+		for i, subject := range b.subjects[lane] {
+
+			name := "ingest"
+			if lane != priorityNormal {
+				name += "_" + lane.String()
+			}
+			if len(b.subjects[lane]) > 1 {
+				name = fmt.Sprintf("%s_%d", name, i)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			consumer, err := b.js.CreateOrUpdateConsumer(ctx, b.stream, jetstream.ConsumerConfig{
+				Durable:       name,
+				FilterSubject: subject,
+				AckPolicy:     jetstream.AckAllPolicy,
+				AckWait:       natsAckWait,
+				// A job's chunks stay unacknowledged until it is written.
+				MaxAckPending: -1,
+			})
+			cancel()
+			if err != nil {
+				return nil, fmt.Errorf("nats: consumer %s: %w", name, err)
+			}
+
+			msgs, err := consumer.Messages()
+			if err != nil {
+				return nil, fmt.Errorf("nats: consume %s: %w", subject, err)
+			}
+
+			streams = append(streams, &natsStream{subject: subject, consumer: consumer, msgs: msgs})
+		}
+	}
+
+	return streams, nil
+}
+
+type natsStream struct {
+	subject  string
+	consumer jetstream.Consumer
+	msgs     jetstream.MessagesContext
+
+	mu   sync.Mutex
+	held []natsHeld // delivered, not yet acknowledged
+	last uint64     // highest stream sequence delivered
+}
+
+type natsHeld struct {
+	seq uint64
+	msg jetstream.Msg
+}
+
+func (s *natsStream) Name() string {
+	return s.subject
+}
+
+func (s *natsStream) Messages() <-chan busMessage {
+
+	out := make(chan busMessage)
+	go func() {
+		defer close(out)
+		for {
+			msg, err := s.msgs.Next()
+			if err != nil {
+				return
+			}
+			meta, err := msg.Metadata()
+			if err != nil {
+				continue
+			}
+			seq := meta.Sequence.Stream
+
+			s.mu.Lock()
+			redelivered := seq <= s.last
+			if !redelivered {
+				s.last = seq
+				s.held = append(s.held, natsHeld{seq: seq, msg: msg})
+			}
+			s.mu.Unlock()
+
+			if !redelivered {
+				out <- busMessage{value: msg.Data(), offset: int64(seq)}
+			}
+		}
+	}()
+
+	return out
+}
+
+// Commit acknowledges the last held message before next, and with it
+// (ack-all) every one before.
+func (s *natsStream) Commit(next int64) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	for i < len(s.held) && int64(s.held[i].seq) < next {
+		i++
+	}
+	if i == 0 {
+		return
+	}
+
+	if err := s.held[i-1].msg.Ack(); err != nil {
+		fmt.Printf("⚠️  Could not acknowledge %s up to %d: %v\n", s.subject, s.held[i-1].seq, err)
+		return
+	}
+	s.held = s.held[i:]
+}
+
+// Lag is the consumer's count of messages not yet delivered.
+func (s *natsStream) Lag() (int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	info, err := s.consumer.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(info.NumPending), nil
+}
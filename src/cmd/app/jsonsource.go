@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// JSON SOURCE /////////////////////////
+///////////////////////////////////////////////////////////
+
+// jsonField is one key/value pair of an object, kept in document order
+// so columns come out in the order the API returns them.
+type jsonField struct {
+	Key   string
+	Value interface{}
+}
+
+// parseJSONRecords ingests a JSON array of objects. path is an optional
+// dotted path (e.g. "data.items") to the array inside a wrapper object.
+// Nested objects are flattened one level deep as parent.child columns,
+// which normalize to parent_child (a flat key already named parent_child
+// keeps its column and the nested one becomes parent_child_2); anything
+// deeper, and arrays, are stored as their JSON text.
+func parseJSONRecords(body []byte, path string) (Preview, error) {
+
+	objects, err := jsonRecordsAt(body, path)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	var cols []string
+	index := map[string]int{}
+	var flat []map[string]string
+
+	for _, obj := range objects {
+
+		fields, err := orderedFields(obj)
+		if err != nil {
+			return Preview{}, err
+		}
+
+		row := map[string]string{}
+
+		for _, f := range flattenFields(fields) {
+			if _, ok := index[f.Key]; !ok {
+				index[f.Key] = len(cols)
+				cols = append(cols, f.Key)
+			}
+			row[f.Key] = jsonCell(f.Value)
+		}
+
+		flat = append(flat, row)
+	}
+
+	rows := make([][]string, len(flat))
+	for i, m := range flat {
+		row := make([]string, len(cols))
+		for j, c := range cols {
+			row[j] = m[c]
+		}
+		rows[i] = row
+	}
+
+	return buildPreview(cols, rows)
+}
+
+// jsonRecordsAt walks path through nested objects and returns the raw
+// elements of the array found there, leaving objects undecoded so their
+// key order survives.
+func jsonRecordsAt(body []byte, path string) ([]json.RawMessage, error) {
+
+	cur := json.RawMessage(body)
+
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(cur, &obj); err != nil {
+				return nil, fmt.Errorf("records path %q: %q is not inside an object", path, key)
+			}
+			cur = obj[key]
+		}
+	}
+
+	var records []json.RawMessage
+	if err := json.Unmarshal(cur, &records); err != nil {
+		return nil, fmt.Errorf("expected a JSON array of objects: %w", err)
+	}
+
+	return records, nil
+}
+
+// orderedFields decodes a single JSON object preserving key order.
+func orderedFields(raw json.RawMessage) ([]jsonField, error) {
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, fmt.Errorf("expected a JSON array of objects")
+	}
+
+	var fields []jsonField
+
+	for dec.More() {
+
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var val json.RawMessage
+		if err := dec.Decode(&val); err != nil {
+			return nil, err
+		}
+
+		var v interface{}
+		if bytes.HasPrefix(bytes.TrimSpace(val), []byte("{")) {
+			nested, err := orderedFields(val)
+			if err != nil {
+				return nil, err
+			}
+			v = nested
+		} else {
+			d := json.NewDecoder(bytes.NewReader(val))
+			d.UseNumber()
+			d.Decode(&v)
+		}
+
+		fields = append(fields, jsonField{Key: tok.(string), Value: v})
+	}
+
+	return fields, nil
+}
+
+// flattenFields lifts the keys of nested objects one level up, naming
+// them parent.child so they stay apart from real flat keys.
+func flattenFields(fields []jsonField) []jsonField {
+
+	var out []jsonField
+
+	for _, f := range fields {
+		nested, ok := f.Value.([]jsonField)
+		if !ok {
+			out = append(out, f)
+			continue
+		}
+		for _, n := range nested {
+			out = append(out, jsonField{Key: f.Key + "." + n.Key, Value: n.Value})
+		}
+	}
+
+	return out
+}
+
+func jsonCell(v interface{}) string {
+
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(t)
+	case json.Number:
+		return t.String()
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	case []jsonField:
+		return string(marshalFields(t))
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
+
+func marshalFields(fields []jsonField) []byte {
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+
+	for i, f := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(f.Key)
+		buf.Write(k)
+		buf.WriteByte(':')
+		if nested, ok := f.Value.([]jsonField); ok {
+			buf.Write(marshalFields(nested))
+		} else {
+			v, _ := json.Marshal(f.Value)
+			buf.Write(v)
+		}
+	}
+
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
+
+// jsonSampleRecords is how many leading records a streamed JSON array
+// contributes to column discovery; keys first seen later are dropped.
+const jsonSampleRecords = 1000
+
+// jsonStream reads a top-level JSON array, or newline-delimited JSON,
+// one record at a time.
+type jsonStream struct {
+	dec     *json.Decoder
+	body    io.Closer
+	cols    []string
+	index   map[string]int
+	pending [][]jsonField
+}
+
+func openJSONStream(body io.ReadCloser) ([]string, *jsonStream, error) {
+
+	dec := json.NewDecoder(body)
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if d, ok := tok.(json.Delim); err != nil || !ok || d != '[' {
+		body.Close()
+		return nil, nil, fmt.Errorf("expected a JSON array of objects")
+	}
+
+	return sampleJSONStream(dec, body)
+}
+
+// openNDJSONStream reads one JSON object per line (JSON Lines). The
+// decoder only ever holds the current line, so files of any size work.
+func openNDJSONStream(body io.ReadCloser) ([]string, *jsonStream, error) {
+
+	dec := json.NewDecoder(bufio.NewReaderSize(body, 64<<10))
+	dec.UseNumber()
+
+	return sampleJSONStream(dec, body)
+}
+
+func parseNDJSON(body []byte) (Preview, error) {
+
+	cols, stream, err := openNDJSONStream(io.NopCloser(bytes.NewReader(body)))
+	if err != nil {
+		return Preview{}, err
+	}
+
+	rows, err := drainRows(stream, 0)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	return buildPreview(cols, rows)
+}
+
+// sampleJSONStream discovers columns from the first jsonSampleRecords
+// records, buffering them so Next still returns them in order.
+func sampleJSONStream(dec *json.Decoder, body io.Closer) ([]string, *jsonStream, error) {
+
+	s := &jsonStream{dec: dec, body: body, index: map[string]int{}}
+
+	for len(s.pending) < jsonSampleRecords && dec.More() {
+
+		fields, err := s.decodeRecord()
+		if err != nil {
+			body.Close()
+			return nil, nil, err
+		}
+
+		for _, f := range fields {
+			if _, ok := s.index[f.Key]; !ok {
+				s.index[f.Key] = len(s.cols)
+				s.cols = append(s.cols, f.Key)
+			}
+		}
+
+		s.pending = append(s.pending, fields)
+	}
+
+	return s.cols, s, nil
+}
+
+func (s *jsonStream) decodeRecord() ([]jsonField, error) {
+
+	var raw json.RawMessage
+	if err := s.dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	fields, err := orderedFields(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	return flattenFields(fields), nil
+}
+
+func (s *jsonStream) Next() ([]string, error) {
+
+	var fields []jsonField
+
+	switch {
+	case len(s.pending) > 0:
+		fields = s.pending[0]
+		s.pending = s.pending[1:]
+	case s.dec.More():
+		f, err := s.decodeRecord()
+		if err != nil {
+			return nil, err
+		}
+		fields = f
+	default:
+		return nil, io.EOF
+	}
+
+	row := make([]string, len(s.cols))
+	for _, f := range fields {
+		if i, ok := s.index[f.Key]; ok {
+			row[i] = jsonCell(f.Value)
+		}
+	}
+
+	return row, nil
+}
+
+func (s *jsonStream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJSONFlattening(t *testing.T) {
+
+	cases := []struct {
+		name string
+		body string
+		cols []string
+	}{
+		{"nested", `[{"id": 1, "price": {"bid": 1.5, "ask": 1.6}}]`, []string{"id", "price_bid", "price_ask"}},
+		{"flat key first", `[{"price_bid": 1, "price": {"bid": 2}}]`, []string{"price_bid", "price_bid_2"}},
+		{"nested key first", `[{"price": {"bid": 2}, "price_bid": 1}]`, []string{"price_bid", "price_bid_2"}},
+		{"deeper as text", `[{"a": {"b": {"c": 1}}}]`, []string{"a_b"}},
+	}
+
+	for _, c := range cases {
+		p, err := parseJSONRecords([]byte(c.body), "")
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(p.Columns, c.cols) {
+			t.Errorf("%s: columns = %v, want %v", c.name, p.Columns, c.cols)
+		}
+	}
+
+	p, _ := parseJSONRecords([]byte(`[{"price_bid": 1, "price": {"bid": 2}}]`), "")
+	if len(p.Rows) != 1 || !reflect.DeepEqual(p.Rows[0][:2], []string{"1", "2"}) {
+		t.Errorf("colliding keys: rows = %v, want [[1 2]]", p.Rows)
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// KAFKA CONNECTION ////////////////////
+///////////////////////////////////////////////////////////
+
+// KAFKA_BROKER lists the bootstrap brokers, comma-separated. Managed
+// clusters (MSK, Confluent Cloud) also need:
+//
+//	KAFKA_SASL_MECHANISM   PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+//	KAFKA_SASL_USERNAME    with KAFKA_SASL_PASSWORD
+//	KAFKA_TLS              true to connect over TLS
+//	KAFKA_TLS_CA_FILE      PEM CA bundle; the system roots by default
+//	KAFKA_TLS_CERT_FILE    client certificate for mutual TLS, with KAFKA_TLS_KEY_FILE
+//	KAFKA_TLS_SKIP_VERIFY  true to accept any server certificate (testing only)
+//
+// Jobs go to the topic KAFKA_TOPIC (default table_rows), prefixed with
+// KAFKA_TOPIC_PREFIX, e.g. "staging." or "dev-", so several environments
+// can share a cluster without consuming each other's jobs.
+//
+// Messages are keyed by table name, so each table's jobs stay in order on
+// one partition while the consumer ingests the partitions in parallel.
+// KAFKA_PARTITIONS creates the topic with that many partitions, or grows
+// it to that many, at startup (KAFKA_REPLICATION_FACTOR, default 1, for a
+// new topic). Growing a topic moves tables to other partitions, so jobs
+// already queued for a table may then run alongside its new ones.
+var jobsTopic = kafkaTopic("KAFKA_TOPIC", "table_rows")
+
+func kafkaTopic(env, name string) string {
+
+	if v := strings.TrimSpace(os.Getenv(env)); v != "" {
+		name = v
+	}
+
+	return os.Getenv("KAFKA_TOPIC_PREFIX") + name
+}
+
+// ensureJobsTopic creates or grows jobsTopic, and the topics of the other
+// priority lanes, to KAFKA_PARTITIONS.
+func ensureJobsTopic(cfg *sarama.Config) error {
+
+	v := os.Getenv("KAFKA_PARTITIONS")
+	if v == "" {
+		return nil
+	}
+
+	partitions, err := strconv.Atoi(v)
+	if err != nil || partitions <= 0 {
+		return fmt.Errorf("KAFKA_PARTITIONS must be a positive number, got %q", v)
+	}
+
+	replicas := 1
+	if v := os.Getenv("KAFKA_REPLICATION_FACTOR"); v != "" {
+		if replicas, err = strconv.Atoi(v); err != nil || replicas <= 0 {
+			return fmt.Errorf("KAFKA_REPLICATION_FACTOR must be a positive number, got %q", v)
+		}
+	}
+
+	admin, err := sarama.NewClusterAdmin(kafkaBrokers(), cfg)
+	if err != nil {
+		return fmt.Errorf("kafka admin: %w", err)
+	}
+	defer admin.Close()
+
+	for _, lane := range lanes() {
+
+		topic := laneTopic(lane)
+
+		topics, err := admin.DescribeTopics([]string{topic})
+		if err != nil {
+			return fmt.Errorf("kafka admin: %w", err)
+		}
+
+		if len(topics) == 0 || topics[0].Err == sarama.ErrUnknownTopicOrPartition {
+			err := admin.CreateTopic(topic, &sarama.TopicDetail{
+				NumPartitions:     int32(partitions),
+				ReplicationFactor: int16(replicas),
+			}, false)
+			if err != nil {
+				return fmt.Errorf("create topic %s: %w", topic, err)
+			}
+			fmt.Printf("🧩 Created topic %s with %d partitions\n", topic, partitions)
+			continue
+		}
+
+		if have := len(topics[0].Partitions); have < partitions {
+			if err := admin.CreatePartitions(topic, int32(partitions), nil, false); err != nil {
+				return fmt.Errorf("grow topic %s: %w", topic, err)
+			}
+			fmt.Printf("🧩 Grew topic %s from %d to %d partitions\n", topic, have, partitions)
+		}
+	}
+
+	return nil
+}
+
+func kafkaBrokers() []string {
+
+	var brokers []string
+	for _, b := range strings.Split(os.Getenv("KAFKA_BROKER"), ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+
+	return brokers
+}
+
+// kafkaConfig is the sarama config of the producer and the consumer.
+func kafkaConfig() (*sarama.Config, error) {
+
+	cfg := sarama.NewConfig()
+
+	if mechanism := strings.ToUpper(os.Getenv("KAFKA_SASL_MECHANISM")); mechanism != "" {
+
+		cfg.Net.SASL.Enable = true
+		cfg.Net.SASL.User = os.Getenv("KAFKA_SASL_USERNAME")
+		cfg.Net.SASL.Password = os.Getenv("KAFKA_SASL_PASSWORD")
+
+		switch mechanism {
+		case sarama.SASLTypePlaintext:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		case sarama.SASLTypeSCRAMSHA256:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+		case sarama.SASLTypeSCRAMSHA512:
+			cfg.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			cfg.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA512} }
+		default:
+			return nil, fmt.Errorf("unknown KAFKA_SASL_MECHANISM %q (use PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512)", mechanism)
+		}
+	}
+
+	if on, _ := strconv.ParseBool(os.Getenv("KAFKA_TLS")); on {
+		tlsConfig, err := kafkaTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Net.TLS.Enable = true
+		cfg.Net.TLS.Config = tlsConfig
+	}
+
+	return cfg, nil
+}
+
+// The producer is tuned with
+//
+//	KAFKA_COMPRESSION         none (default), gzip, snappy, lz4 or zstd
+//	KAFKA_ACKS                all (default), leader or none
+//	KAFKA_BATCH_BYTES         bytes buffered before a batch is sent
+//	KAFKA_BATCH_MESSAGES      messages buffered before a batch is sent
+//	KAFKA_LINGER              longest wait for a batch to fill, e.g. 20ms
+//	KAFKA_MAX_MESSAGE_BYTES   largest message accepted (default 1048576);
+//	                          must not exceed the broker's message.max.bytes
+//
+// Batches form from messages of concurrent jobs; a job's own messages are
+// sent one at a time. KAFKA_CHUNK_BYTES defaults to half the max message.
+
+var kafkaCompression = map[string]sarama.CompressionCodec{
+	"":       sarama.CompressionNone,
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+var kafkaAcks = map[string]sarama.RequiredAcks{
+	"":       sarama.WaitForAll,
+	"all":    sarama.WaitForAll,
+	"-1":     sarama.WaitForAll,
+	"leader": sarama.WaitForLocal,
+	"1":      sarama.WaitForLocal,
+	"none":   sarama.NoResponse,
+	"0":      sarama.NoResponse,
+}
+
+// kafkaProducerConfig is kafkaConfig with the producer settings applied.
+func kafkaProducerConfig() (*sarama.Config, error) {
+
+	cfg, err := kafkaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.Producer.Return.Successes = true
+	cfg.Producer.Return.Errors = true
+
+	// A job's messages share its table's key, and so its partition.
+	cfg.Producer.Partitioner = sarama.NewHashPartitioner
+
+	// Retries must not reorder a job's chunks behind its schema message.
+	cfg.Net.MaxOpenRequests = 1
+
+	codec, ok := kafkaCompression[strings.ToLower(os.Getenv("KAFKA_COMPRESSION"))]
+	if !ok {
+		return nil, fmt.Errorf("unknown KAFKA_COMPRESSION %q (use none, gzip, snappy, lz4 or zstd)", os.Getenv("KAFKA_COMPRESSION"))
+	}
+	cfg.Producer.Compression = codec
+
+	acks, ok := kafkaAcks[strings.ToLower(os.Getenv("KAFKA_ACKS"))]
+	if !ok {
+		return nil, fmt.Errorf("unknown KAFKA_ACKS %q (use all, leader or none)", os.Getenv("KAFKA_ACKS"))
+	}
+	cfg.Producer.RequiredAcks = acks
+
+	for env, field := range map[string]*int{
+		"KAFKA_BATCH_BYTES":       &cfg.Producer.Flush.Bytes,
+		"KAFKA_BATCH_MESSAGES":    &cfg.Producer.Flush.Messages,
+		"KAFKA_MAX_MESSAGE_BYTES": &cfg.Producer.MaxMessageBytes,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("%s must be a positive number of bytes or messages, got %q", env, v)
+			}
+			*field = n
+		}
+	}
+
+	if v := os.Getenv("KAFKA_LINGER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("KAFKA_LINGER must be a duration such as 20ms, got %q", v)
+		}
+		cfg.Producer.Flush.Frequency = d
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("kafka producer: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func kafkaTLSConfig() (*tls.Config, error) {
+
+	skip, _ := strconv.ParseBool(os.Getenv("KAFKA_TLS_SKIP_VERIFY"))
+	c := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: skip}
+
+	if file := os.Getenv("KAFKA_TLS_CA_FILE"); file != "" {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("kafka tls: %w", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("kafka tls: no certificates in %s", file)
+		}
+	}
+
+	if certFile := os.Getenv("KAFKA_TLS_CERT_FILE"); certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("KAFKA_TLS_KEY_FILE"))
+		if err != nil {
+			return nil, fmt.Errorf("kafka tls: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+// scramClient is the SCRAM client sarama leaves to the application,
+// backed by xdg-go/scram, which also SASLpreps user names and passwords.
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(user, password, authzID string) error {
+
+	client, err := c.hash.NewClient(user, password, authzID)
+	if err != nil {
+		return fmt.Errorf("scram: %w", err)
+	}
+	c.conversation = client.NewConversation()
+
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
+
+///////////////////////////////////////////////////////////
+//////////////////// KAFKA BUS ///////////////////////////
+///////////////////////////////////////////////////////////
+
+// kafkaBus is the Kafka messageBus: an async producer, and a consumer
+// with a stream per partition of each lane's topic (jobsTopic unless
+// PRIORITY_LANES is set), checkpointed in ingestion_offsets (offsets.go).
+type kafkaBus struct {
+	producer sarama.AsyncProducer
+}
+
+func newKafkaBus() (messageBus, error) {
+
+	cfg, err := kafkaProducerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureJobsTopic(cfg); err != nil {
+		return nil, err
+	}
+
+	p, err := sarama.NewAsyncProducer(
+		kafkaBrokers(),
+		cfg,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	go handleDeliveries(p)
+
+	return &kafkaBus{producer: p}, nil
+}
+
+func (b *kafkaBus) Publish(jobID string, lane jobPriority, key string, values [][]byte) error {
+
+	for i, v := range values {
+		b.producer.Input() <- &sarama.ProducerMessage{
+			Topic:    laneTopic(lane),
+			Key:      sarama.StringEncoder(key),
+			Value:    sarama.ByteEncoder(v),
+			Metadata: delivery{jobID: jobID, message: i, messages: len(values)},
+		}
+	}
+
+	return nil
+}
+
+// handleDeliveries drains the async producer. A message that could not be
+// delivered fails its job with the error in the job's log.
+func handleDeliveries(p sarama.AsyncProducer) {
+
+	successes, failures := p.Successes(), p.Errors()
+
+	for successes != nil || failures != nil {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			if d, ok := msg.Metadata.(delivery); ok && d.message == d.messages-1 {
+				fmt.Printf("📤 Published job %s (%d messages)\n", d.jobID, d.messages)
+			}
+
+		case perr, ok := <-failures:
+			if !ok {
+				failures = nil
+				continue
+			}
+			d, ok := perr.Msg.Metadata.(delivery)
+			if !ok {
+				fmt.Printf("❌ Kafka delivery failed: %v\n", perr.Err)
+				continue
+			}
+			failDelivery(d, perr.Err)
+		}
+	}
+}
+
+func (b *kafkaBus) Streams() ([]messageStream, error) {
+
+	cfg, err := kafkaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := sarama.NewConsumer(
+		kafkaBrokers(),
+		cfg,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var streams []messageStream
+	for _, lane := range lanes() {
+
+		topic := laneTopic(lane)
+
+		partitions, err := consumer.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("topic %s: %w", topic, err)
+		}
+
+		for _, partition := range partitions {
+			// Start where the last run left off (offsets.go).
+			pc, err := consumePartition(consumer, topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			s := &kafkaStream{pc: pc, topic: topic, partition: partition}
+			s.next.Store(-1)
+			streams = append(streams, s)
+		}
+	}
+
+	return streams, nil
+}
+
+type kafkaStream struct {
+	pc        sarama.PartitionConsumer
+	topic     string
+	partition int32
+	next      atomic.Int64 // last committed, -1 before the first
+}
+
+func (s *kafkaStream) Name() string {
+	return fmt.Sprintf("%s/%d", s.topic, s.partition)
+}
+
+func (s *kafkaStream) Messages() <-chan busMessage {
+
+	out := make(chan busMessage)
+	go func() {
+		defer close(out)
+		for msg := range s.pc.Messages() {
+			out <- busMessage{value: msg.Value, offset: msg.Offset}
+		}
+	}()
+
+	return out
+}
+
+func (s *kafkaStream) Commit(next int64) {
+	saveOffset(s.topic, s.partition, next)
+	s.next.Store(next)
+}
+
+// Lag counts from the last commit to the partition's high-water mark,
+// once a message has been consumed.
+func (s *kafkaStream) Lag() (int64, error) {
+
+	next := s.next.Load()
+	if next < 0 {
+		return 0, nil
+	}
+
+	return max(s.pc.HighWaterMarkOffset()-next, 0), nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/xdg-go/scram"
+)
+
+// scramLogin runs scramClient against an xdg-go/scram server holding
+// stored, the credentials of user with password, and reports whether
+// the server accepted the login.
+func scramLogin(t *testing.T, hash scram.HashGeneratorFcn, user, password, login string) bool {
+
+	t.Helper()
+
+	stored, err := hash.NewClient(user, password, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := stored.GetStoredCredentials(scram.KeyFactors{Salt: "pepper", Iters: 4096})
+
+	server, err := hash.NewServer(func(string) (scram.StoredCredentials, error) { return creds, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv := server.NewConversation()
+
+	c := &scramClient{hash: hash}
+	if err := c.Begin(user, login, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := c.Step("")
+	for err == nil && !c.Done() {
+		var challenge string
+		if challenge, err = conv.Step(msg); err != nil {
+			break
+		}
+		msg, err = c.Step(challenge)
+	}
+
+	return err == nil && conv.Valid()
+}
+
+func TestSCRAMClient(t *testing.T) {
+
+	cases := []struct {
+		name            string
+		password, login string
+		want            bool
+	}{
+		{"ascii", "s3cret", "s3cret", true},
+		{"wrong password", "s3cret", "s3cre7", false},
+		{"non-ascii", "p\u00e4ssw\u00f6rd", "p\u00e4ssw\u00f6rd", true},
+		// SASLprep normalizes a decomposed ä (a + U+0308) to the stored one.
+		{"non-ascii decomposed", "p\u00e4ssw\u00f6rd", "pa\u0308ssw\u00f6rd", true},
+	}
+
+	for _, hash := range []struct {
+		name string
+		fcn  scram.HashGeneratorFcn
+	}{{"SHA-256", scram.SHA256}, {"SHA-512", scram.SHA512}} {
+		for _, c := range cases {
+			if got := scramLogin(t, hash.fcn, "ingest,user=1", c.password, c.login); got != c.want {
+				t.Errorf("%s %s: login accepted = %v, want %v", hash.name, c.name, got, c.want)
+			}
+		}
+	}
+}
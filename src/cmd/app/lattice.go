@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TYPE LATTICE ////////////////////////
+///////////////////////////////////////////////////////////
+
+// Every cleaned value is classified as the narrowest type it fits and
+// also counts for the types containing it: an integer is a FLOAT, a DATE
+// is a DATETIME at midnight. A column takes the first type of
+// typePrecedence that at least the threshold of its filled values fit,
+// so numbers win over dates and narrow types over wide ones, and the
+// result does not depend on row order. Columns fitting none are text.
+// JSON objects and arrays (nested API data) get a JSON column.
+
+var typePrecedence = []string{"INT", "FLOAT", "DATE", "DATETIME", "JSON"}
+
+// widerTypes lists the lattice types containing each type.
+var widerTypes = map[string][]string{
+	"INT":  {"FLOAT"},
+	"DATE": {"DATETIME"},
+}
+
+// classifyValue returns the narrowest lattice type of a cleaned value,
+// or TEXT. NaN and Inf are words, not numbers.
+func classifyValue(val string) string {
+
+	if _, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return "INT"
+	}
+
+	if f, err := strconv.ParseFloat(val, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+		return "FLOAT"
+	}
+
+	if matchesAnyLayout(val, dateLayouts) {
+		return "DATE"
+	}
+
+	if matchesAnyLayout(val, dateTimeLayouts) {
+		return "DATETIME"
+	}
+
+	if isJSONDocument(val) {
+		return "JSON"
+	}
+
+	return "TEXT"
+}
+
+// isJSONDocument reports whether v is a JSON object or array. Scalars
+// like 5 or "x" are valid JSON too but belong to the other types.
+func isJSONDocument(v string) bool {
+
+	v = strings.TrimSpace(v)
+	if len(v) < 2 {
+		return false
+	}
+
+	if !(v[0] == '{' && v[len(v)-1] == '}') && !(v[0] == '[' && v[len(v)-1] == ']') {
+		return false
+	}
+
+	return json.Valid([]byte(v))
+}
+
+// typeVotes counts, per lattice type, the values fitting it.
+type typeVotes map[string]int
+
+func (v typeVotes) add(kind string) {
+
+	v[kind]++
+	for _, w := range widerTypes[kind] {
+		v[w]++
+	}
+}
+
+// pick returns the first type in precedence order that threshold of
+// total values fit, or TEXT.
+func (v typeVotes) pick(total int, threshold float64) string {
+
+	for _, t := range typePrecedence {
+		if total > 0 && float64(v[t]) >= float64(total)*threshold {
+			return t
+		}
+	}
+
+	return "TEXT"
+}
+
+// precedenceRule explains in words why a column got its type.
+func precedenceRule(chosen, family string, votes typeVotes, total int, threshold float64) string {
+
+	if total == 0 {
+		return chosen + ": no filled values"
+	}
+
+	if family == "TEXT" {
+		return fmt.Sprintf("%s: none of %s fit %.0f%% of filled values",
+			chosen, strings.Join(typePrecedence, " > "), threshold*100)
+	}
+
+	return fmt.Sprintf("%s: %.0f%% of filled values fit %s (threshold %.0f%%, precedence %s)",
+		chosen, float64(votes[family])*100/float64(total), family, threshold*100,
+		strings.Join(typePrecedence, " > "))
+}
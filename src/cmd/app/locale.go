@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// NUMBER LOCALES //////////////////////
+///////////////////////////////////////////////////////////
+
+// number_format tells how a source writes numbers:
+//   us:   1,234.56 (what cleanValue has always assumed)
+//   eu:   1.234,56 or 1 234,56
+//   auto: (default) eu for columns whose values can only be read that way
+//
+// European columns are rewritten to plain 1234.56 before inference, so
+// inference and insertion see the same canonical value.
+
+// numberCell matches a number with optional grouping, wrapped in at most
+// a few characters of currency symbol, code or unit.
+var numberCell = regexp.MustCompile(`^(\D{0,4}?)([-+]?\d[\d.,' \x{00a0}\x{202f}]*\d|\d)(\D{0,4})$`)
+
+func validNumberFormat(f string) error {
+
+	switch f {
+	case "", "auto", "us", "eu":
+		return nil
+	default:
+		return fmt.Errorf("unknown number_format %q (use auto, us or eu)", f)
+	}
+}
+
+// localizeNumbers rewrites European-formatted columns of a preview and
+// re-infers their types.
+func localizeNumbers(p Preview, format string) Preview {
+
+	if format == "us" {
+		return p
+	}
+
+	var changed []int
+
+	for c := range p.Columns {
+		if format == "eu" || columnLooksEuropean(p.Rows, c) {
+			changed = append(changed, c)
+		}
+	}
+
+	if len(changed) == 0 {
+		return p
+	}
+
+	for _, r := range p.Rows {
+		for _, c := range changed {
+			if c < len(r) {
+				r[c] = europeanToCanonical(r[c])
+			}
+		}
+	}
+
+	types := inferTypes(p.Columns, p.Rows)
+	for _, c := range changed {
+		p.Types[p.Columns[c]] = types[p.Columns[c]]
+	}
+
+	fmt.Printf("✓ Read %d columns with European number format\n", len(changed))
+
+	return p
+}
+
+// columnLooksEuropean reports whether some value in the column is only
+// valid as a European number and none is only valid as a US one.
+func columnLooksEuropean(rows [][]string, c int) bool {
+
+	eu, us := false, false
+
+	for _, r := range rows {
+		if c >= len(r) {
+			continue
+		}
+
+		m := numberCell.FindStringSubmatch(strings.TrimSpace(r[c]))
+		if m == nil {
+			continue
+		}
+
+		switch numberEvidence(m[2]) {
+		case "eu":
+			eu = true
+		case "us":
+			us = true
+		}
+	}
+
+	return eu && !us
+}
+
+// numberEvidence classifies a bare number as "eu", "us" or "" when it
+// reads the same (or equally plausibly) either way.
+func numberEvidence(n string) string {
+
+	dot := strings.LastIndex(n, ".")
+	comma := strings.LastIndex(n, ",")
+
+	switch {
+	case dot >= 0 && comma >= 0:
+		if comma > dot {
+			return "eu"
+		}
+		return "us"
+	case strings.Count(n, ".") > 1:
+		return "eu"
+	case strings.Count(n, ",") > 1:
+		return "us"
+	case comma >= 0:
+		// "1,234" groups thousands either way; "12,5" is a decimal comma.
+		if len(n)-comma-1 != 3 {
+			return "eu"
+		}
+	case dot >= 0:
+		if len(n)-dot-1 != 3 {
+			return "us"
+		}
+	}
+
+	return ""
+}
+
+// europeanToCanonical drops grouping dots and spaces and turns the
+// decimal comma into a point, keeping any symbol around the number.
+func europeanToCanonical(v string) string {
+
+	m := numberCell.FindStringSubmatch(strings.TrimSpace(v))
+	if m == nil {
+		return v
+	}
+
+	n := strings.NewReplacer(".", "", " ", "", "'", "", "\u00a0", "", "\u202f", "").Replace(m[2])
+	n = strings.Replace(n, ",", ".", 1)
+
+	return m[1] + n + m[3]
+}
+
+// localeStream converts the numeric columns of rows the consumer streams
+// straight from the source when the request says number_format=eu.
+type localeStream struct {
+	rowStream
+	cols []int
+}
+
+func (s *localeStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range s.cols {
+		if c < len(row) {
+			row[c] = europeanToCanonical(row[c])
+		}
+	}
+
+	return row, nil
+}
+
+func isNumericType(t string) bool {
+
+	switch strings.SplitN(t, "(", 2)[0] {
+	case "TINYINT", "SMALLINT", "MEDIUMINT", "INT", "BIGINT", "FLOAT", "DOUBLE", "DECIMAL":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// MAGNITUDE SUFFIXES //////////////////
+///////////////////////////////////////////////////////////
+
+// Financial tables abbreviate magnitudes: "12k", "3.4M", "1.2B", "2 bn".
+// Inference reads such values by their expanded digits, so a column of
+// them is numeric, and numeric columns get their cells expanded before
+// insertion. Text columns keep them as written, so a "3M" ticker stays
+// "3M".
+var magnitudeNumber = regexp.MustCompile(`(?i)^([-+]?\d*\.?\d+)\s*(k|m|mm|mn|b|bn|t|tn)$`)
+
+// magnitudeExponents maps each suffix to its power of ten.
+var magnitudeExponents = map[string]int{
+	"k": 3,
+	"m": 6, "mm": 6, "mn": 6,
+	"b": 9, "bn": 9,
+	"t": 12, "tn": 12,
+}
+
+// expandMagnitude writes a number with a magnitude suffix as plain
+// digits without rounding: "1.2B" is "1200000000".
+func expandMagnitude(v string) (string, bool) {
+
+	m := magnitudeNumber.FindStringSubmatch(v)
+	if m == nil {
+		return "", false
+	}
+
+	exp := magnitudeExponents[strings.ToLower(m[2])]
+
+	return expandScientific(m[1] + "e" + strconv.Itoa(exp))
+}
+
+// expandMagnitudeRule is the expand_magnitude cleaning rule.
+func expandMagnitudeRule(v string) string {
+
+	if plain, ok := expandMagnitude(strings.TrimSpace(v)); ok {
+		return plain
+	}
+
+	return v
+}
+
+// expandMagnitudeColumns rewrites suffixed values of the preview's
+// numeric columns as plain digits.
+func expandMagnitudeColumns(p Preview) Preview {
+
+	cols := numericColumns(p)
+	if len(cols) == 0 {
+		return p
+	}
+
+	for _, r := range p.Rows {
+		expandMagnitudeCells(r, cols)
+	}
+
+	return p
+}
+
+// numericColumns lists the numeric columns of p.
+func numericColumns(p Preview) []int {
+
+	var cols []int
+
+	for i, c := range p.Columns {
+		if isNumericType(p.Types[c]) {
+			cols = append(cols, i)
+		}
+	}
+
+	return cols
+}
+
+func expandMagnitudeCells(row []string, cols []int) {
+
+	for _, c := range cols {
+		if c >= len(row) {
+			continue
+		}
+		if v, ok := expandMagnitude(cleanValue(row[c])); ok {
+			row[c] = v
+		}
+	}
+}
+
+// magnitudeStream expands suffixed values in the numeric columns of rows
+// the consumer streams straight from the source.
+type magnitudeStream struct {
+	rowStream
+	cols []int
+}
+
+func (s *magnitudeStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	expandMagnitudeCells(row, s.cols)
+
+	return row, nil
+}
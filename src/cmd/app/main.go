@@ -24,18 +24,21 @@ Frontend dashboard interacts with this service.
 
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
-	"github.com/IBM/sarama"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/google/uuid"
 	_ "github.com/go-sql-driver/mysql"
@@ -45,7 +48,6 @@ import (
 //////////////////// GLOBALS /////////////////////////////
 ///////////////////////////////////////////////////////////
 
-var producer sarama.SyncProducer
 var db *sql.DB
 
 ///////////////////////////////////////////////////////////
@@ -53,16 +55,152 @@ var db *sql.DB
 ///////////////////////////////////////////////////////////
 
 type Preview struct {
-	Columns []string          `json:"columns"`
-	Types   map[string]string `json:"types"`
-	Rows    [][]string        `json:"rows"`
+	Columns  []string          `json:"columns"`
+	Types    map[string]string `json:"types"`
+	Rows     [][]string        `json:"rows"`
+	Tables   []TableInfo       `json:"tables,omitempty"`
+	Nested   []Preview         `json:"nested,omitempty"` // nested_tables=extract: tables found inside cells
+	Source   *SourceMeta       `json:"source,omitempty"`
+	Rejected []RejectedRow     `json:"rejected,omitempty"` // rows kept out by ragged_rows
+	NotNull  []string          `json:"not_null,omitempty"` // columns created NOT NULL: no empty cell in the preview
+
+	Categorical map[string][]string `json:"categorical,omitempty"`  // low-cardinality string columns and their values
+	DateLayouts map[string]string   `json:"date_layouts,omitempty"` // layout each DATE column was read with
+	Registered  []string            `json:"registered,omitempty"`   // columns typed from the table's registered schema
+	Semantic    map[string]string   `json:"semantic,omitempty"`     // column -> semantic type (isin, iban, email, ...)
+
+	Stats    map[string]ColumnStats `json:"stats,omitempty"`    // why each type was chosen; preview only
+	Filtered int                    `json:"filtered,omitempty"` // preview rows the filter dropped
+
+	typed    bool // types come from the source's own schema, not inference
+	appended int  // derived and lookup cells at the end of each row, which the consumer computes again
+}
+
+// TableInfo summarizes one <table> on a page so the user can pick it.
+type TableInfo struct {
+	Index   int      `json:"index"`
+	ID      string   `json:"id,omitempty"`
+	Caption string   `json:"caption,omitempty"`
+	Rows    int      `json:"rows"`
+	Columns int      `json:"columns"`
+	Headers []string `json:"headers,omitempty"`
 }
 
 type IngestRequest struct {
-	URL   string `json:"url"`
-	Table string `json:"table"`
-	Mode  string `json:"mode"`
-	Dedup bool   `json:"dedup"`
+	URL    string `json:"url"`
+	Table  string `json:"table"`
+	Mode   string `json:"mode"`
+	Dedup  bool   `json:"dedup"`
+	Format string `json:"format"` // "", "html", "csv", "tsv", "xlsx", "json", "ndjson", "xml", "feed", "fixed", "avro" or "gsheet"; empty means auto-detect
+	Sheet  string `json:"sheet"`  // xlsx: sheet name or zero-based index; gsheet: worksheet name
+	Range  string `json:"range"`  // gsheet only: A1 range such as "A1:F200"
+
+	RecordsPath string          `json:"records_path"` // json only: dotted path to the record array
+	RowSelector string          `json:"row_selector"` // xml: XPath of the record element; html: CSS selector of repeating rows
+	Fields      []FieldSelector `json:"fields"`       // optional explicit record-to-column mapping
+
+	S3 *S3Options `json:"s3,omitempty"` // s3:// URLs only; falls back to the AWS environment
+
+	Pagination *Pagination `json:"pagination,omitempty"` // html only: follow next pages
+
+	TableIndex    int    `json:"table_index"`    // html: which table on the page, zero-based
+	TableSelector string `json:"table_selector"` // html: CSS selector for the table, wins over table_index
+	ScopeSelector string `json:"scope_selector"` // html: only look inside this container (e.g. "#main-content")
+	ListTables    bool   `json:"list_tables"`    // preview only: also list every table on the page
+	Profile       string `json:"profile"`        // html: "wikipedia" for wikitable cleanup
+	IncludeFooter bool   `json:"include_footer"` // html: keep <tfoot> rows as data instead of skipping them
+	NestedTables  string `json:"nested_tables"`  // html: "flatten" (default), "ignore" or "extract"
+	ExtractLinks  bool   `json:"extract_links"`  // html: add a <column>_url column for cells with links
+
+	Render       bool   `json:"render"`        // html: execute the page's JavaScript in headless Chrome
+	WaitSelector string `json:"wait_selector"` // render only: wait until this selector is visible
+
+	FetchOptions *FetchOptions `json:"fetch_options,omitempty"` // headers, auth and cookies for the source
+
+	ArchivePattern string `json:"archive_pattern"` // zip only: glob selecting the file to ingest
+
+	Charset string `json:"charset"` // source encoding such as "windows-1252"; detected from headers, <meta> or the bytes when empty
+
+	Content     string `json:"content"`      // raw document to parse instead of fetching url
+	ContentType string `json:"content_type"` // MIME type or format name of content, sniffed when empty
+
+	ColumnWidths []int `json:"column_widths"` // fixed only: character width of each column, guessed when empty
+
+	GraphQL *GraphQLQuery `json:"graphql,omitempty"` // query POSTed to url; records_path is relative to "data"
+
+	Stream bool `json:"stream"` // csv/tsv/json/ndjson over http: the consumer streams the file instead of one big message
+
+	SkipRows       int      `json:"skip_rows"`                  // rows above the header to drop (banners, titles)
+	HeaderRowIndex *int     `json:"header_row_index,omitempty"` // header row after skip_rows, zero-based; html auto-detects when unset
+	ColumnNames    []string `json:"column_names"`               // names for a source without a header row
+	MaxRows        int      `json:"max_rows"`                   // cap on data rows, 0 means no limit
+	Transpose      bool     `json:"transpose"`                  // key/value tables: pivot so the first column becomes the header
+	HeaderScanRows int      `json:"header_scan_rows"`           // rows searched for the header when header_row_index is unset; default 10, negative disables
+
+	InferenceThreshold  *float64 `json:"inference_threshold,omitempty"` // share of filled values a type must fit; default 0.8 (INFERENCE_THRESHOLD)
+	InferenceSampleRows int      `json:"inference_sample_rows"`         // rows read to infer types, spread over the table; 0 reads all (INFERENCE_SAMPLE_ROWS)
+
+	Columns        []string          `json:"columns"`         // only ingest these columns, in this order
+	ExcludeColumns []string          `json:"exclude_columns"` // drop these columns
+	Rename         map[string]string `json:"rename"`          // detected name -> table column name, e.g. {"col_3": "ticker"}
+
+	DateOrder       string `json:"date_order"`       // slash dates: "auto" (default; decided per column, day-first when ambiguous), "dmy" or "mdy"
+	Timezone        string `json:"timezone"`         // IANA zone DATETIME values with an offset are converted to; default UTC
+	NumberFormat    string `json:"number_format"`    // "auto" (default), "us" or "eu" (1.234,56)
+	CurrencyColumns bool   `json:"currency_columns"` // add <col>_currency next to money columns
+	PercentAs       string `json:"percent_as"`       // "percent" (default, 7.5) or "fraction" (0.075)
+
+	Convert []CurrencyConversion `json:"convert"`  // money columns to add in another currency, as <col>_<to>
+	FXRates map[string]float64   `json:"fx_rates"` // units per unit of a common base, e.g. {"USD": 1, "EUR": 0.92}; over FX_RATES_URL
+
+	Cleaning       []CleaningRule            `json:"cleaning"`        // replaces the built-in cell cleaning
+	ColumnCleaning map[string][]CleaningRule `json:"column_cleaning"` // per-column cleaning, wins over cleaning
+	Transforms     map[string][]Transform    `json:"transforms"`      // per-column steps run on source cells; failed casts reject the row
+	Split          []ColumnSplit             `json:"split"`           // columns split into several, by delimiter or regex groups
+	Merge          []ColumnMerge             `json:"merge"`           // columns joined into one
+
+	RaggedRows string `json:"ragged_rows"` // rows not matching the header width: "pad" (default), "truncate" or "reject"
+
+	Types       map[string]string `json:"types"`        // column -> SQL type replacing the inferred one, e.g. {"zip": "VARCHAR(10)"}
+	FreshSchema bool              `json:"fresh_schema"` // infer types anew instead of starting from the table's registered schema
+
+	EnumColumns bool `json:"enum_columns"` // create the preview's categorical columns as ENUM
+
+	Detectors []DetectorRule `json:"detectors"` // extra semantic types, checked before the built-in ones
+
+	NullValues []string `json:"null_values"` // cells stored as NULL besides empty ones; default n/a, na, null, none, -, —, –
+
+	SourceColumns bool `json:"source_columns"` // add source_url, source_title, table_caption and fetched_at columns
+
+	Derived []DerivedColumn `json:"derived"` // columns computed per row from expressions, e.g. price * shares
+	Filter  string          `json:"filter"`  // only rows this expression holds for are inserted, e.g. country == 'US' AND revenue > 0
+	Lookups []Lookup        `json:"lookups"` // columns joined in from existing tables, e.g. ticker -> sector
+
+	Validate []ValidationRule `json:"validate"` // constraints rows must meet, e.g. required, min, regex; violating rows go to ingestion_rejects
+
+	Mapping  map[string]ValueMapping `json:"mapping"`  // column -> value dictionary and CASE-like rules, e.g. USA, U.S. -> US
+	Defaults map[string]string       `json:"defaults"` // column -> value for empty cells, e.g. 0, unknown or CURRENT_DATE
+
+	Mask map[string]string `json:"mask"` // column -> hash, last4, redact or tokenize, applied just before insert
+
+	DedupKeys     []string `json:"dedup_keys"`     // columns identifying a row; all columns when empty
+	DedupStrategy string   `json:"dedup_strategy"` // "keep_first" (default), "keep_last" or "skip_existing"; set with dedup_keys or dedup
+
+	UpsertKeys []string `json:"upsert_keys"` // mode upsert: columns identifying a row; a row whose keys exist updates it
+
+	Priority    string `json:"priority"`    // "high", "normal" (default) or "low"; higher priorities are consumed first
+	Transaction string `json:"transaction"` // "none", "chunk" or "job": what a failure rolls back; default INGEST_TRANSACTION
+	Sink        string `json:"sink"`        // "database" (default) or "clickhouse": where the rows are written; default INGEST_SINK
+
+	BatchID string `json:"-"` // set for child jobs of /ingest/batch
+}
+
+// FieldSelector maps part of a record to a column. Path is relative to
+// the record; Attr, when set, reads an attribute instead of the text.
+type FieldSelector struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Attr string `json:"attr"`
 }
 
 ///////////////////////////////////////////////////////////
@@ -71,19 +209,33 @@ type IngestRequest struct {
 
 func main() {
 
-	setupKafka()
+	setupBus()
 	setupDB()
 	ensureMetaTables()
+	ensureWebhookTables()
 
 	go startConsumer()
+	go startMailPoller()
+	go startWebhookFlusher()
+	go startGRPCServer()
 
 	http.Handle("/", http.FileServer(http.Dir("./web")))
 	http.HandleFunc("/preview", previewHandler)
 	http.HandleFunc("/ingest", ingestHandler)
+	http.HandleFunc("/upload", uploadHandler)
+	http.HandleFunc("/ingest/sftp", sftpIngestHandler)
+	http.HandleFunc("/ingest/batch", batchIngestHandler)
+	http.HandleFunc("/batch_status", batchStatusHandler)
+	http.HandleFunc("/discover", discoverHandler)
+	http.HandleFunc("/webhooks", registerWebhookHandler)
+	http.HandleFunc("/webhook/", webhookHandler)
 	http.HandleFunc("/tables", tablesHandler)
 	http.HandleFunc("/table", tableHandler)
 	http.HandleFunc("/job_status", jobStatusHandler)
+	http.HandleFunc("/queue/status", queueStatusHandler)
 	http.HandleFunc("/job_logs", jobLogsHandler)
+	http.HandleFunc("/job_rejects", jobRejectsHandler)
+	http.HandleFunc("/schema", schemaHandler)
 
 	fmt.Println("Server running")
 	http.ListenAndServe(":"+os.Getenv("APP_PORT"), nil)
@@ -93,24 +245,35 @@ func main() {
 //////////////////// SETUP ///////////////////////////////
 ///////////////////////////////////////////////////////////
 
-func setupKafka() {
-
-	cfg := sarama.NewConfig()
-	cfg.Producer.Return.Successes = true
+func setupBus() {
 
-	p, err := sarama.NewSyncProducer(
-		[]string{os.Getenv("KAFKA_BROKER")},
-		cfg,
-	)
+	b, err := newMessageBus()
 	if err != nil {
 		panic(err)
 	}
 
-	producer = p
+	bus = b
 }
 
 func setupDB() {
 
+	switch dbDriver {
+	case dbSQLite:
+		var err error
+		db, err = sql.Open("sqlite_mysql", sqliteDSN())
+		if err == nil {
+			err = db.Ping()
+		}
+		if err != nil {
+			panic(fmt.Sprintf("SQLite unavailable: %v", err))
+		}
+		fmt.Println("DB connected (SQLite)")
+		return
+	case dbMySQL:
+	default:
+		panic(fmt.Sprintf("unknown DB_DRIVER %q (use mysql or sqlite)", dbDriver))
+	}
+
 	dsn := os.Getenv("DB_USER") + ":" +
 		os.Getenv("DB_PASSWORD") +
 		"@tcp(" + os.Getenv("DB_HOST") +
@@ -152,6 +315,68 @@ func ensureMetaTables() {
 		message TEXT,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	)`)
+
+	db.Exec(`
+	CREATE TABLE IF NOT EXISTS ingestion_batches(
+		id VARCHAR(64) PRIMARY KEY,
+		total_jobs INT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+
+	db.Exec(`
+	CREATE TABLE IF NOT EXISTS ingestion_rejects(
+		id INT AUTO_INCREMENT PRIMARY KEY,
+		job_id VARCHAR(64),
+		row_index INT,
+		reason TEXT,
+		row_data TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		INDEX (job_id)
+	)`)
+	if dbDriver == dbSQLite {
+		db.Exec(`CREATE INDEX IF NOT EXISTS ingestion_rejects_job_id ON ingestion_rejects (job_id)`)
+	}
+
+	db.Exec(`
+	CREATE TABLE IF NOT EXISTS ingestion_schemas(
+		table_name VARCHAR(64) PRIMARY KEY,
+		columns_json TEXT,
+		types_json TEXT,
+		cleaning_json TEXT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+	)`)
+
+	db.Exec(`
+	CREATE TABLE IF NOT EXISTS ingestion_tokens(
+		token CHAR(28) PRIMARY KEY,
+		column_name VARCHAR(64),
+		value TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+
+	db.Exec(`
+	CREATE TABLE IF NOT EXISTS ingestion_offsets(
+		topic VARCHAR(255),
+		partition_id INT,
+		next_offset BIGINT,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+		PRIMARY KEY (topic, partition_id)
+	)`)
+
+	// Columns added after the first release. MySQL has no ADD COLUMN IF
+	// NOT EXISTS, so the duplicate-column error on restart is ignored.
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN batch_id VARCHAR(64)`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN source_url TEXT`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN source_title TEXT`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN table_caption TEXT`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN fetched_at DATETIME`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN source_timezones TEXT`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN filtered_rows INT DEFAULT 0`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN deduped_rows INT DEFAULT 0`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN chunks_total INT DEFAULT 0`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN chunks_received INT DEFAULT 0`)
+	db.Exec(`ALTER TABLE ingestion_jobs ADD COLUMN checkpoint_rows INT DEFAULT 0`)
+	db.Exec(`ALTER TABLE ingestion_schemas ADD COLUMN semantic_json TEXT`)
 }
 
 ///////////////////////////////////////////////////////////
@@ -160,10 +385,10 @@ func ensureMetaTables() {
 
 func previewHandler(w http.ResponseWriter, r *http.Request) {
 
-	var req struct{ URL string }
+	var req IngestRequest
 	json.NewDecoder(r.Body).Decode(&req)
 
-	p, err := parseTable(req.URL)
+	p, err := loadPreview(req)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -174,104 +399,898 @@ func previewHandler(w http.ResponseWriter, r *http.Request) {
 
 func ingestHandler(w http.ResponseWriter, r *http.Request) {
 
+	if rejectWhenBusy(w) {
+		return
+	}
+
 	var req IngestRequest
 	json.NewDecoder(r.Body).Decode(&req)
 
-	p, err := parseTable(req.URL)
+	p, err := loadPreview(req)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	jobID := startJob(p, req)
+
+	w.Write([]byte(jobID))
+}
+
+// uploadHandler accepts a multipart form with a "file" part plus the
+// usual ingest fields (table, mode, dedup, format). The file is previewed
+// as a fetched source would be. With preview=true the Preview is returned
+// instead of starting a job.
+func uploadHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.FormValue("preview") != "true" && rejectWhenBusy(w) {
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "missing file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	body, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	req := IngestRequest{
+		Table:  r.FormValue("table"),
+		Mode:   r.FormValue("mode"),
+		Dedup:  r.FormValue("dedup") == "true",
+		Format: r.FormValue("format"),
+		Sheet:  r.FormValue("sheet"),
+
+		RecordsPath: r.FormValue("records_path"),
+		RowSelector: r.FormValue("row_selector"),
+		RaggedRows:  r.FormValue("ragged_rows"),
+
+		Priority:    r.FormValue("priority"),
+		Transaction: r.FormValue("transaction"),
+		Sink:        r.FormValue("sink"),
+	}
+
+	if keys := r.FormValue("upsert_keys"); keys != "" {
+		req.UpsertKeys = strings.Split(keys, ",")
+	}
+
+	if err := validRaggedRows(req.RaggedRows); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validPriority(req.Priority); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validTransaction(req.Transaction); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validSink(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	src := &fetchedSource{
+		URL:         header.Filename,
+		ContentType: header.Header.Get("Content-Type"),
+		Body:        body,
+	}
+
+	p, err := loadPreviewWith(req, func(req IngestRequest) (Preview, error) {
+		return parseDocument(req, src)
+	})
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
 	}
 
+	if r.FormValue("preview") == "true" {
+		json.NewEncoder(w).Encode(p)
+		return
+	}
+
+	w.Write([]byte(startJob(p, req)))
+}
+
+// startJob records a new ingestion job and publishes it to Kafka.
+func startJob(p Preview, req IngestRequest) string {
+
 	jobID := uuid.New().String()
 
+	total, filtered := len(p.Rows), p.Filtered
+	secrets := isStreamed(req) && hasStreamSecret(req)
+	if isStreamed(req) {
+		// The consumer streams the source itself; only the schema travels.
+		p.Rows = nil
+		total, filtered = 0, 0
+	}
+	if secrets {
+		streamSecrets.Store(jobID, streamSecret{URL: req.URL, FetchOptions: req.FetchOptions, S3: req.S3})
+	}
+
+	meta := SourceMeta{}
+	if p.Source != nil {
+		meta = *p.Source
+	}
+
+	var zones []byte
+	if meta.TimeZones != nil {
+		zones, _ = json.Marshal(meta.TimeZones)
+	}
+
 	db.Exec(`
 	INSERT INTO ingestion_jobs
-	(id, table_name, total_rows, inserted_rows, status)
-	VALUES (?, ?, ?, 0, 'running')`,
-		jobID, req.Table, len(p.Rows))
+	(id, table_name, total_rows, inserted_rows, filtered_rows, status, batch_id,
+	 source_url, source_title, table_caption, fetched_at, source_timezones)
+	VALUES (?, ?, ?, 0, ?, 'running', NULLIF(?, ''), ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''))`,
+		jobID, req.Table, total, filtered, req.BatchID,
+		meta.URL, meta.Title, meta.Caption, meta.FetchedAt, string(zones))
+
+	if len(p.Rejected) > 0 {
+		recordRejects(jobID, p.Rejected)
+		logJob(jobID, fmt.Sprintf("%d rows rejected", len(p.Rejected)))
+		p.Rejected = nil
+	}
+
+	p.Stats = nil
+	p.Filtered = 0
+	rows := withoutDerived(p.Rows, p.appended)
+	p.Rows = nil
 
 	payload := map[string]interface{}{
-		"preview": p,
-		"table":   req.Table,
-		"mode":    req.Mode,
-		"dedup":   req.Dedup,
-		"job_id":  jobID,
+		"preview":  p,
+		"table":    req.Table,
+		"mode":     req.Mode,
+		"dedup":    req.Dedup,
+		"priority": req.Priority,
+		"job_id":   jobID,
+		"request":  publishedRequest(req),
+	}
+	if secrets {
+		payload["stream_secrets"] = true
 	}
 
-	b, _ := json.Marshal(payload)
+	if err := publishJob(jobID, payload, chunkRows(rows, kafkaChunkBytes)); err != nil {
+		streamSecrets.Delete(jobID)
+		fmt.Printf("❌ %v\n", err)
+		logJob(jobID, err.Error())
+		db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+	}
 
-	producer.SendMessage(&sarama.ProducerMessage{
-		Topic: "table_rows",
-		Value: sarama.ByteEncoder(b),
-	})
+	return jobID
+}
 
-	w.Write([]byte(jobID))
+// recordFailedJob stores a job that failed before reaching Kafka, so it
+// still shows up in job and batch status with its error in the logs.
+func recordFailedJob(req IngestRequest, cause error) string {
+
+	jobID := uuid.New().String()
+
+	db.Exec(`
+	INSERT INTO ingestion_jobs
+	(id, table_name, total_rows, inserted_rows, status, batch_id)
+	VALUES (?, ?, 0, 0, 'failed', NULLIF(?, ''))`,
+		jobID, req.Table, req.BatchID)
+
+	logJob(jobID, cause.Error())
+
+	return jobID
+}
+
+// logJob appends a message to the job's log shown in the dashboard.
+func logJob(jobID, msg string) {
+	db.Exec(`INSERT INTO ingestion_logs (job_id, message) VALUES (?, ?)`, jobID, msg)
+}
+
+// publishedRequest strips fetch credentials before a request leaves the
+// API: the consumer never refetches HTTP sources, so it does not need them.
+func publishedRequest(req IngestRequest) IngestRequest {
+
+	req.FetchOptions = req.FetchOptions.redact()
+	req.S3 = req.S3.redact()
+	req.URL = redactURL(req.URL)
+
+	// Pasted content is already parsed into the preview.
+	req.Content = ""
+
+	return req
 }
 
 ///////////////////////////////////////////////////////////
 //////////////////// FETCH + PARSE ///////////////////////
 ///////////////////////////////////////////////////////////
 
-func fetchDocument(url string) (*goquery.Document, error) {
+// fetchedSource is the raw response of a source URL, kept in memory so
+// it can be sniffed and routed to the right parser.
+type fetchedSource struct {
+	URL         string
+	ContentType string
+	Body        []byte
+}
+
+func fetchSource(url string, opts *FetchOptions) (*fetchedSource, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	fmt.Printf("🌐 Fetching %s\n", redactURL(url))
+
 	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
-	resp, err := http.DefaultClient.Do(req)
+	opts.apply(req)
+
+	client, err := opts.httpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
 	defer resp.Body.Close()
-	return goquery.NewDocumentFromReader(resp.Body)
+
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("source returned %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fetchedSource{
+		URL:         url,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        body,
+	}, nil
+}
+
+// detectFormat picks the parser for a source. An explicit format on the
+// request wins, then the URL extension, then the Content-Type header.
+func detectFormat(req IngestRequest, src *fetchedSource) string {
+
+	if req.Format != "" {
+		return strings.ToLower(req.Format)
+	}
+
+	if len(req.ColumnWidths) > 0 {
+		return "fixed"
+	}
+
+	path := strings.ToLower(src.URL)
+	if i := strings.IndexAny(path, "?#"); i != -1 {
+		path = path[:i]
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".csv"):
+		return "csv"
+	case strings.HasSuffix(path, ".tsv"), strings.HasSuffix(path, ".tab"):
+		return "tsv"
+	case strings.HasSuffix(path, ".xlsx"):
+		return "xlsx"
+	case strings.HasSuffix(path, ".json"):
+		return "json"
+	case strings.HasSuffix(path, ".ndjson"), strings.HasSuffix(path, ".jsonl"):
+		return "ndjson"
+	case strings.HasSuffix(path, ".xml"):
+		return "xml"
+	case strings.HasSuffix(path, ".avro"):
+		return "avro"
+	case strings.HasSuffix(path, ".rss"), strings.HasSuffix(path, ".atom"):
+		return "feed"
+	case strings.HasPrefix(src.ContentType, "text/csv"):
+		return "csv"
+	case strings.HasPrefix(src.ContentType, "text/tab-separated-values"):
+		return "tsv"
+	case strings.HasPrefix(src.ContentType, "application/vnd.openxmlformats-officedocument.spreadsheetml"):
+		return "xlsx"
+	case strings.HasPrefix(src.ContentType, "application/json"):
+		return "json"
+	case strings.HasPrefix(src.ContentType, "application/x-ndjson"),
+		strings.HasPrefix(src.ContentType, "application/jsonl"),
+		strings.HasPrefix(src.ContentType, "application/x-jsonlines"):
+		return "ndjson"
+	case strings.HasPrefix(src.ContentType, "application/avro"),
+		bytes.HasPrefix(src.Body, []byte("Obj\x01")):
+		return "avro"
+	case strings.HasPrefix(src.ContentType, "application/rss+xml"),
+		strings.HasPrefix(src.ContentType, "application/atom+xml"):
+		return "feed"
+	case strings.HasPrefix(src.ContentType, "application/xml"),
+		strings.HasPrefix(src.ContentType, "text/xml"):
+		return "xml"
+	}
+
+	if src.URL == "" {
+		return sniffFormat(src.Body)
+	}
+
+	return "html"
+}
+
+// sniffFormat guesses the format of pasted content that has no URL or
+// Content-Type to go by. Spreadsheet pastes arrive tab-separated.
+func sniffFormat(body []byte) string {
+
+	text := strings.TrimSpace(string(body))
+
+	switch {
+	case strings.HasPrefix(text, "<?xml"):
+		return "xml"
+	case strings.HasPrefix(text, "<"):
+		return "html"
+	}
+
+	firstLine := text
+	if i := strings.IndexByte(text, '\n'); i != -1 {
+		firstLine = text[:i]
+	}
+
+	switch {
+	case strings.HasPrefix(text, "{") && strings.HasSuffix(strings.TrimSpace(firstLine), "}") && firstLine != text:
+		return "ndjson"
+	case strings.HasPrefix(text, "["), strings.HasPrefix(text, "{"):
+		return "json"
+	}
+
+	if strings.Contains(firstLine, "\t") {
+		return "tsv"
+	}
+
+	return "csv"
+}
+
+func loadPreview(req IngestRequest) (Preview, error) {
+	return loadPreviewWith(req, loadSource)
+}
+
+// loadPreviewWith builds the preview of the rows load returns, validated,
+// cleaned and typed as every source's are. Uploads and SFTP files, which
+// arrive as bytes, pass their own load.
+func loadPreviewWith(req IngestRequest, load func(IngestRequest) (Preview, error)) (Preview, error) {
+
+	if err := validNumberFormat(req.NumberFormat); err != nil {
+		return Preview{}, err
+	}
+
+	if err := validPercentAs(req.PercentAs); err != nil {
+		return Preview{}, err
+	}
+
+	if err := validRaggedRows(req.RaggedRows); err != nil {
+		return Preview{}, err
+	}
+
+	if err := validDateOrder(req.DateOrder); err != nil {
+		return Preview{}, err
+	}
+
+	if err := validDedupStrategy(req.DedupStrategy); err != nil {
+		return Preview{}, err
+	}
+
+	if err := validPriority(req.Priority); err != nil {
+		return Preview{}, err
+	}
+
+	if err := validTransaction(req.Transaction); err != nil {
+		return Preview{}, err
+	}
+
+	if err := validSink(req); err != nil {
+		return Preview{}, err
+	}
+
+	loc, err := outputLocation(req.Timezone)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	inference, err := inferenceFor(req)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	detectors, err := requestDetectors(req)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err := load(req)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p = fitRows(p, req.RaggedRows)
+	p = detectNulls(p, newNullSet(req.NullValues))
+
+	p = stampSource(p, req)
+
+	p, err = selectColumns(p, req)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err = renameColumns(p, req.Rename)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err = applyTransforms(p, req.Transforms, req.Rename, inference)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err = reshapeColumns(p, req, inference)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p = reinferTypes(p, inference)
+	p = applyRegisteredSchema(p, req)
+	p = normalizeDates(p, req.DateOrder)
+	p = normalizeDateTimes(p, loc)
+	p = localizeNumbers(p, req.NumberFormat)
+	p, err = convertCurrencies(p, req)
+	if err != nil {
+		return Preview{}, err
+	}
+	p = detectCurrencies(p, req.CurrencyColumns)
+	p = convertPercentages(p, req.PercentAs)
+	p = expandScientificColumns(p)
+	p = expandMagnitudeColumns(p)
+
+	if req.Cleaning != nil || req.ColumnCleaning != nil {
+		pipeline, err := newCleaningPipeline(req, p.Columns)
+		if err != nil {
+			return Preview{}, err
+		}
+		p = pipeline.retype(p, inference)
+	}
+
+	if req.MaxRows > 0 && len(p.Rows) > req.MaxRows {
+		p.Rows = p.Rows[:req.MaxRows]
+	}
+
+	// Values inserted outside an ENUM fail, so sampled sources are only
+	// flagged, never converted.
+	if pipeline, err := newCleaningPipeline(req, p.Columns); err == nil {
+		p = flagCategorical(p, pipeline.clean, req.EnumColumns && !isStreamed(req))
+	}
+
+	if req.SourceColumns {
+		p = addSourceColumns(p)
+	}
+
+	if len(req.Derived) > 0 {
+		pipeline, err := newCleaningPipeline(req, p.Columns)
+		if err != nil {
+			return Preview{}, err
+		}
+		p, err = deriveColumns(p, req.Derived, pipeline.clean, isStreamed(req))
+		if err != nil {
+			return Preview{}, err
+		}
+	}
+
+	if len(req.Lookups) > 0 {
+		pipeline, err := newCleaningPipeline(req, p.Columns)
+		if err != nil {
+			return Preview{}, err
+		}
+		p, err = enrichColumns(p, req, pipeline.clean)
+		if err != nil {
+			return Preview{}, err
+		}
+	}
+
+	p, err = applyTypeOverrides(p, req.Types, req.Rename)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err = mapColumns(p, req)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err = applyDefaults(p, req)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	if req.Filter != "" || len(req.Validate) > 0 {
+		pipeline, err := newCleaningPipeline(req, p.Columns)
+		if err != nil {
+			return Preview{}, err
+		}
+		mappings, err := compileMappings(req, p.Columns)
+		if err != nil {
+			return Preview{}, err
+		}
+		if req.Filter != "" {
+			p, err = filterRows(p, req.Filter, pipeline.clean, mappings)
+			if err != nil {
+				return Preview{}, err
+			}
+		}
+		if len(req.Validate) > 0 {
+			p, err = validateRows(p, req, pipeline.clean, mappings)
+			if err != nil {
+				return Preview{}, err
+			}
+		}
+	}
+
+	if _, err := dedupKeyColumns(req, p.Columns); err != nil {
+		return Preview{}, err
+	}
+
+	if _, err := upsertKeyColumns(req, p.Columns); err != nil {
+		return Preview{}, err
+	}
+
+	// A sample of a streamed source cannot prove a column is never empty.
+	if !isStreamed(req) {
+		p.NotNull = notNullColumns(p)
+		p = nullableMappings(p, req)
+	}
+
+	p = requireColumns(p, req)
+
+	p, err = maskColumns(p, req.Mask, req.Rename)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p = detectSemantics(p, detectors)
+	p.Stats = columnStats(p, inference.Threshold)
+
+	return p, nil
 }
 
-func parseTable(url string) (Preview, error) {
+// gridLayoutFor describes where the header of a grid-shaped source
+// (csv, tsv, xlsx, fixed-width, sheets) is.
+func gridLayoutFor(req IngestRequest) gridLayout {
 
-	doc, err := fetchDocument(url)
+	n := req.SkipRows
+	if req.HeaderRowIndex != nil {
+		n += *req.HeaderRowIndex
+	}
+
+	if n < 0 {
+		n = 0
+	}
+
+	if req.Transpose {
+		// The header of a pivoted table is always its first column.
+		n = req.SkipRows
+	}
+
+	scan := 0
+	if req.HeaderRowIndex == nil && req.ColumnNames == nil && !req.Transpose {
+		scan = headerScanRows(req)
+	}
+
+	return gridLayout{Skip: n, Names: req.ColumnNames, Transpose: req.Transpose, Scan: scan}
+}
+
+func loadSource(req IngestRequest) (Preview, error) {
+
+	if req.Content != "" {
+		return parseDocument(req, pastedSource(req))
+	}
+
+	if req.Format == "gsheet" || (req.Format == "" && isGoogleSheetURL(req.URL)) {
+		return loadGoogleSheet(req)
+	}
+
+	if req.GraphQL != nil {
+		return loadGraphQL(req)
+	}
+
+	if isStreamed(req) {
+		return previewStreamed(req)
+	}
+
+	if isSFTPURL(req.URL) {
+		return previewSFTP(req)
+	}
+
+	src, err := fetchPage(req, req.URL)
 	if err != nil {
 		return Preview{}, fmt.Errorf("failed to fetch document: %w", err)
 	}
 
-	var cols []string
-	var rows [][]string
+	return parseDocument(req, src)
+}
 
-	table := doc.Find("table").First()
-	if table.Length() == 0 {
-		return Preview{}, fmt.Errorf("no table found in HTML")
+// pastedSource wraps inline request content. content_type may be a MIME
+// type ("text/csv") or a bare format name ("csv").
+func pastedSource(req IngestRequest) *fetchedSource {
+
+	src := &fetchedSource{
+		ContentType: req.ContentType,
+		Body:        []byte(req.Content),
 	}
 
-	table.Find("tr").Each(func(i int, tr *goquery.Selection) {
+	if ct := strings.ToLower(req.ContentType); ct != "" && !strings.Contains(ct, "/") {
+		src.URL = "pasted." + ct
+		src.ContentType = ""
+	}
 
-		var row []string
+	return src
+}
 
-		// MINIMAL FIX: Check if row has headers vs data
-		if tr.Find("th").Length() > 0 {
-			// Header row
-			tr.Find("th").Each(func(_ int, th *goquery.Selection) {
-				// MINIMAL FIX: Look for .dt-column-title first (DataTables), fallback to full text
-				text := th.Find(".dt-column-title").First().Text()
-				if text == "" {
-					text = th.Text()
-				}
-				row = append(row, strings.TrimSpace(text))
-			})
-			if i == 0 {
-				cols = row
+// fetchPage loads a page the way the request asks for: rendered through
+// headless Chrome when render is set, otherwise with a plain GET.
+func fetchPage(req IngestRequest, url string) (*fetchedSource, error) {
+
+	if req.Render {
+		return renderPage(url, req.WaitSelector, req.FetchOptions)
+	}
+
+	return fetchSource(url, req.FetchOptions)
+}
+
+// parseDocument routes an already-loaded source to its format parser.
+func parseDocument(req IngestRequest, src *fetchedSource) (Preview, error) {
+
+	src, err := unpackArchive(src, req.ArchivePattern)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	format := detectFormat(req, src)
+
+	if textFormats[format] {
+		if src, err = decodeSource(src, req.Charset); err != nil {
+			return Preview{}, err
+		}
+	}
+
+	switch format {
+	case "csv":
+		return parseCSV(src.Body, ',', gridLayoutFor(req))
+	case "tsv":
+		return parseCSV(src.Body, '\t', gridLayoutFor(req))
+	case "xlsx":
+		return parseXLSX(src.Body, req.Sheet, gridLayoutFor(req))
+	case "json":
+		return parseJSONRecords(src.Body, req.RecordsPath)
+	case "ndjson", "jsonl":
+		return parseNDJSON(src.Body)
+	case "fixed", "fixed_width":
+		return parseFixedWidth(src.Body, req.ColumnWidths, gridLayoutFor(req))
+	case "avro":
+		return parseAvro(src.Body)
+	case "xml":
+		if req.RowSelector == "" && isFeed(src.Body) {
+			return parseFeed(src.Body, req.Fields)
+		}
+		return parseXML(src.Body, req.RowSelector, req.Fields)
+	case "feed", "rss", "atom":
+		return parseFeed(src.Body, req.Fields)
+	case "html":
+		if req.RowSelector != "" {
+			return parseScrape(src.Body, req.ScopeSelector, req.RowSelector, req.Fields)
+		}
+		if req.Pagination != nil {
+			return parsePaginatedTable(req, src)
+		}
+		return parseTable(req, src.Body)
+	default:
+		return Preview{}, fmt.Errorf("unsupported format %q", req.Format)
+	}
+}
+
+func parseTable(req IngestRequest, body []byte) (Preview, error) {
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	title := strings.TrimSpace(doc.Find("title").First().Text())
+
+	doc, err = scopeDocument(doc, req.ScopeSelector)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err := parseTableIn(doc, req)
+	if p.Source != nil {
+		p.Source.Title = title
+	}
+
+	return p, err
+}
+
+// parseTableIn parses the selected table of an already scoped page.
+func parseTableIn(doc *goquery.Document, req IngestRequest) (Preview, error) {
+
+	if req.ListTables {
+		tables := listTables(doc)
+
+		p, err := parseSelectedTable(doc, req)
+		if err != nil && len(tables) == 0 {
+			return Preview{}, err
+		}
+
+		// The list is the point of the request, so a table that fails to
+		// parse does not hide the others.
+		p.Tables = tables
+		return p, nil
+	}
+
+	return parseSelectedTable(doc, req)
+}
+
+func parseSelectedTable(doc *goquery.Document, req IngestRequest) (Preview, error) {
+
+	var nested []Preview
+	if req.NestedTables == "extract" {
+		// Parsed before extractTable flattens them into the outer cells.
+		if table, err := selectTable(doc, req); err == nil {
+			nested = nestedPreviews(table, req)
+		}
+	}
+
+	cols, rows, err := extractTable(doc, req)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err := buildPreview(cols, rows)
+	p.Nested = nested
+
+	if table, err := selectTable(doc, req); err == nil {
+		p.Source = &SourceMeta{Caption: strings.TrimSpace(table.Find("caption").First().Text())}
+	}
+
+	return p, err
+}
+
+// scopeDocument narrows a page to the first element matching selector,
+// so navigation, banners and sidebars outside it are never considered.
+func scopeDocument(doc *goquery.Document, selector string) (*goquery.Document, error) {
+
+	if selector == "" {
+		return doc, nil
+	}
+
+	scope := doc.Find(selector).First()
+	if scope.Length() == 0 {
+		return nil, fmt.Errorf("no element matches scope selector %q", selector)
+	}
+
+	return goquery.NewDocumentFromNode(scope.Get(0)), nil
+}
+
+// selectTable finds the table named by the request: a CSS selector when
+// given, otherwise the table at TableIndex (the first one by default).
+func selectTable(doc *goquery.Document, req IngestRequest) (*goquery.Selection, error) {
+
+	if req.TableSelector != "" {
+		t := doc.Find(req.TableSelector).First()
+		if t.Length() == 0 {
+			return nil, fmt.Errorf("no element matches table selector %q", req.TableSelector)
+		}
+		if !t.Is("table") {
+			t = t.Find("table").First()
+			if t.Length() == 0 {
+				return nil, fmt.Errorf("no table inside %q", req.TableSelector)
 			}
-		} else {
-			// Data row
-			tr.Find("td").Each(func(_ int, td *goquery.Selection) {
-				row = append(row, strings.TrimSpace(td.Text()))
-			})
-			if len(row) > 0 {
-				rows = append(rows, row)
+		}
+		return t, nil
+	}
+
+	tables := doc.Find("table")
+	if req.Profile == "wikipedia" && doc.Find("table.wikitable").Length() > 0 {
+		// Infoboxes and navboxes are tables too; index among data tables.
+		tables = doc.Find("table.wikitable")
+	}
+	if tables.Length() == 0 {
+		return nil, fmt.Errorf("no table found in HTML")
+	}
+
+	if req.TableIndex < 0 || req.TableIndex >= tables.Length() {
+		return nil, fmt.Errorf("table index %d out of range (page has %d tables)",
+			req.TableIndex, tables.Length())
+	}
+
+	return tables.Eq(req.TableIndex), nil
+}
+
+func listTables(doc *goquery.Document) []TableInfo {
+
+	var list []TableInfo
+
+	doc.Find("table").Each(func(i int, t *goquery.Selection) {
+
+		info := TableInfo{
+			Index:   i,
+			ID:      t.AttrOr("id", ""),
+			Caption: strings.TrimSpace(t.Find("caption").First().Text()),
+		}
+
+		ownRows(t).Each(func(_ int, tr *goquery.Selection) {
+			info.Rows++
+			if n := tr.Find("th, td").Length(); n > info.Columns {
+				info.Columns = n
+			}
+			if info.Headers == nil && tr.Find("th").Length() > 0 {
+				tr.Find("th").Each(func(_ int, th *goquery.Selection) {
+					info.Headers = append(info.Headers, strings.TrimSpace(th.Text()))
+				})
+			}
+		})
+
+		list = append(list, info)
+	})
+
+	return list
+}
+
+// extractTable pulls the header and data rows out of the selected table.
+func extractTable(doc *goquery.Document, req IngestRequest) ([]string, [][]string, error) {
+
+	if err := validNestedMode(req.NestedTables); err != nil {
+		return nil, nil, err
+	}
+
+	table, err := selectTable(doc, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	collapseNestedTables(table, req.NestedTables)
+
+	if req.Profile == "wikipedia" {
+		return extractWikipediaTable(table, req)
+	}
+
+	grid := tableGrid(table, func(c *goquery.Selection) string {
+		if c.Is("th") {
+			// DataTables wraps the title next to sort controls.
+			if title := c.Find(".dt-column-title").First().Text(); title != "" {
+				return strings.TrimSpace(title)
 			}
 		}
+		return strings.TrimSpace(c.Text())
 	})
 
+	cols, rows := gridTable(grid, req)
+
+	return cols, rows, nil
+}
+
+// buildPreview is the common tail of every source parser: it validates
+// the extracted grid, normalizes column names and infers types.
+func buildPreview(cols []string, rows [][]string) (Preview, error) {
+
 	if len(cols) == 0 {
 		return Preview{}, fmt.Errorf("no columns found in table")
 	}
@@ -281,14 +1300,41 @@ func parseTable(url string) (Preview, error) {
 	}
 
 	cols = normalizeColumns(cols)
+	normalizeRows(rows)
+
+	fmt.Printf("✓ Parsed table: %d columns × %d rows\n", len(cols), len(rows))
+	fmt.Printf("✓ Columns: %v\n", cols)
+
+	return Preview{
+		Columns: cols,
+		Types:   inferTypes(cols, rows),
+		Rows:    rows,
+	}, nil
+}
+
+// buildTypedPreview is buildPreview for sources that carry their own
+// schema: types[i] is used for column i instead of inference.
+func buildTypedPreview(cols, types []string, rows [][]string) (Preview, error) {
+
+	if len(cols) == 0 {
+		return Preview{}, fmt.Errorf("no columns found in table")
+	}
+
+	cols = normalizeColumns(cols)
+	normalizeRows(rows)
+
+	typeMap := map[string]string{}
+	for i, c := range cols {
+		typeMap[c] = types[i]
+	}
 
-	fmt.Printf("✓ Parsed table: %d columns × %d rows\n", len(cols), len(rows))
-	fmt.Printf("✓ Columns: %v\n", cols)
+	fmt.Printf("✓ Parsed typed source: %d columns × %d rows\n", len(cols), len(rows))
 
 	return Preview{
 		Columns: cols,
-		Types:   inferTypes(cols, rows),
+		Types:   typeMap,
 		Rows:    rows,
+		typed:   true,
 	}, nil
 }
 
@@ -298,6 +1344,9 @@ func parseTable(url string) (Preview, error) {
 
 var invalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
 
+// dottedName matches the dots of a dotted path such as parent.child.
+var dottedName = regexp.MustCompile(`\b\.\b`)
+
 func normalizeColumns(cols []string) []string {
 
 	seen := map[string]int{}
@@ -305,9 +1354,10 @@ func normalizeColumns(cols []string) []string {
 
 	for i, c := range cols {
 
-		name := strings.ToLower(c)
+		name := strings.ToLower(normalizeText(c))
 		name = strings.ReplaceAll(name, " ", "_")
-		name = invalidChars.ReplaceAllString(name, "")
+		name = dottedName.ReplaceAllString(name, "_")
+		name = identifierName(name)
 		name = strings.Trim(name, "_")
 
 		if name == "" {
@@ -336,6 +1386,11 @@ func cleanForInference(v string) string {
 
 	v = strings.TrimSpace(v)
 
+	// Commas and brackets are structure in JSON, not formatting.
+	if isJSONDocument(v) {
+		return v
+	}
+
 	v = strings.ReplaceAll(v, ",", "")
 	v = strings.ReplaceAll(v, "$", "")
 	v = strings.ReplaceAll(v, "%", "")
@@ -345,21 +1400,62 @@ func cleanForInference(v string) string {
 		v = v[:i]
 	}
 
-	return strings.TrimSpace(v)
+	v = accountingNegative(strings.TrimSpace(v))
+
+	if plain, ok := expandScientific(v); ok {
+		return plain
+	}
+
+	if plain, ok := expandMagnitude(v); ok {
+		return plain
+	}
+
+	return v
+}
+
+// accountingNegative turns the accounting notation "(1234.50)" into
+// "-1234.50". Anything else in parentheses is left alone.
+func accountingNegative(v string) string {
+
+	inner, ok := strings.CutPrefix(v, "(")
+	if !ok {
+		return v
+	}
+
+	inner, ok = strings.CutSuffix(inner, ")")
+	if !ok {
+		return v
+	}
+
+	inner = strings.TrimSpace(inner)
+	if _, err := strconv.ParseFloat(inner, 64); err != nil || strings.HasPrefix(inner, "-") {
+		return v
+	}
+
+	return "-" + inner
 }
 
 var dateLayouts = []string{
 	"2006-01-02",
-	"02/01/2006",
-	"01/02/2006",
-	"02 Jan 2006",
+	dayFirstLayout,
+	monthFirstLayout,
+	"2006/1/2",
+	"2.1.2006",
+	"2 Jan 2006",
 	"Jan 2, 2006",
 }
 
 var dateTimeLayouts = []string{
 	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05 -07:00",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04 MST",
 	"2006-01-02 15:04:05",
 	"2006-01-02 15:04",
+	"2 Jan 2006 15:04 MST",
 	"02 Jan 2006 15:04",
 }
 
@@ -375,11 +1471,27 @@ func matchesAnyLayout(v string, layouts []string) bool {
 
 func inferTypes(cols []string, rows [][]string) map[string]string {
 
+	return inferTypesWith(cols, rows, func(_ int, v string) string {
+		return cleanForInference(v)
+	}, defaultInference)
+}
+
+// inferTypesWith infers types from values cleaned by clean, which gets
+// the column index and the raw value. A type must fit o.Threshold of the
+// filled values; a sampled column gets room for values outside the sample.
+func inferTypesWith(cols []string, rows [][]string, clean func(int, string) string, o inferenceOptions) map[string]string {
+
 	result := map[string]string{}
+	rows, sampled := sampleRows(rows, o.SampleRows)
 
 	for c := range cols {
 
-		var intCount, floatCount, dateCount, dtCount, total int
+		votes := typeVotes{}
+		total := 0
+		var shape digitShape
+		decimals := newDecimalStats()
+		var ints intRange
+		longest := 0
 
 		for _, r := range rows {
 
@@ -387,27 +1499,25 @@ func inferTypes(cols []string, rows [][]string) map[string]string {
 				continue
 			}
 
-			val := cleanForInference(r[c])
+			val := clean(c, r[c])
 			if val == "" {
 				continue
 			}
 
 			total++
-
-			if _, err := strconv.Atoi(val); err == nil {
-				intCount++
-			}
-
-			if _, err := strconv.ParseFloat(val, 64); err == nil {
-				floatCount++
-			}
-
-			if matchesAnyLayout(val, dateLayouts) {
-				dateCount++
-			}
-
-			if matchesAnyLayout(val, dateTimeLayouts) {
-				dtCount++
+			longest = max(longest, utf8.RuneCountInString(r[c]), utf8.RuneCountInString(val))
+
+			shape.add(val)
+			kind := classifyValue(val)
+			votes.add(kind)
+
+			switch kind {
+			case "INT":
+				n, _ := strconv.ParseInt(val, 10, 64)
+				ints.add(n)
+				decimals.add(r[c], val)
+			case "FLOAT":
+				decimals.add(r[c], val)
 			}
 		}
 
@@ -416,60 +1526,300 @@ func inferTypes(cols []string, rows [][]string) map[string]string {
 			continue
 		}
 
-		threshold := float64(total) * 0.8
-
-		switch {
-		case float64(intCount) >= threshold:
-			result[cols[c]] = "INT"
+		if shape.identifier(cols[c]) {
+			result[cols[c]] = stringType(longest)
+			continue
+		}
 
-		case float64(floatCount) >= threshold:
-			result[cols[c]] = "FLOAT"
+		switch kind := votes.pick(total, o.Threshold); kind {
+		case "INT":
+			result[cols[c]] = ints.sqlType()
+			if decimals.scientific {
+				result[cols[c]] = "DOUBLE"
+			}
 
-		case float64(dtCount) >= threshold:
-			result[cols[c]] = "DATETIME"
+		case "FLOAT":
+			result[cols[c]] = decimals.floatType()
+			if t, ok := decimals.decimalType(); ok {
+				result[cols[c]] = t
+			}
 
-		case float64(dateCount) >= threshold:
-			result[cols[c]] = "DATE"
+		case "DATE", "DATETIME", "JSON":
+			result[cols[c]] = kind
 
 		default:
-			result[cols[c]] = "TEXT"
+			result[cols[c]] = stringType(longest)
 		}
 	}
 
+	if sampled {
+		sampledTypes(result)
+	}
+
 	return result
 }
 
 
 
 ///////////////////////////////////////////////////////////
-//////////////////// KAFKA CONSUMER //////////////////////
+//////////////////// CONSUMER ////////////////////////////
 ///////////////////////////////////////////////////////////
 
 func startConsumer() {
 
-	cfg := sarama.NewConfig()
+	streams, err := bus.Streams()
+	states := watchStreams(streams, err)
+	if err != nil {
+		fmt.Printf("❌ Consumer: %v\n", err)
+		return
+	}
+
+	// One worker per stream: tables on different streams ingest in
+	// parallel, each table's jobs in order (transport.go).
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consumeJobs(stream, states[i])
+		}()
+	}
+	wg.Wait()
+}
+
+// runJob inserts one job's rows: its assembled chunks or, for streamed
+// jobs, the source itself.
+func runJob(payload map[string]interface{}, chunked [][]string) {
+
+	p := convertPreview(payload["preview"])
+	table := payload["table"].(string)
+	mode := payload["mode"].(string)
+	jobID := payload["job_id"].(string)
+	req := convertRequest(payload["request"])
+
+	if chunked != nil {
+		p.Rows = chunked
+	}
+
+	var rows rowStream = newSliceStream(p.Rows)
+
+	if isStreamed(req) {
+		held, _ := payload["stream_secrets"].(bool)
+		header, streamed, err := openJobStream(req, jobID, held)
+		if err != nil {
+			fmt.Printf("❌ Failed to open %s: %v\n", redactURL(req.URL), err)
+			logJob(jobID, err.Error())
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return
+		}
+		rows = &nullStream{rowStream: &textStream{rowStream: streamed}, nulls: newNullSet(req.NullValues)}
+
+		rows = &raggedStream{rowStream: rows, width: len(header), policy: req.RaggedRows, jobID: jobID}
+
+		// The source's own columns, as transforms and splits see them.
+		cols := normalizeColumns(header)
+		if keep, _ := columnSelection(cols, req); keep != nil {
+			rows = &projectStream{rowStream: rows, keep: keep}
+			cols = projectRow(cols, keep)
+		}
+		for i, c := range cols {
+			cols[i] = renamedColumn(c, req.Rename)
+		}
+
+		if len(req.Transforms) > 0 {
+			transforms, err := compileTransforms(req.Transforms, cols, req.Rename)
+			if err != nil {
+				fmt.Printf("❌ Invalid transforms: %v\n", err)
+				db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+				return
+			}
+			rows = &transformStream{rowStream: rows, transforms: transforms, jobID: jobID}
+		}
+
+		if len(req.Split) > 0 || len(req.Merge) > 0 {
+			r, err := compileReshape(req, cols)
+			if err != nil {
+				fmt.Printf("❌ Invalid split or merge: %v\n", err)
+				db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+				return
+			}
+			rows = &reshapeStream{rowStream: rows, reshape: r}
+		}
+
+		if len(req.Convert) > 0 {
+			converted := convertedColumns(req)
+			var cols []string
+			for _, c := range p.Columns {
+				if !converted[c] {
+					cols = append(cols, c)
+				}
+			}
+			convs, rates, err := compileConversions(req, cols)
+			if err != nil {
+				fmt.Printf("❌ Invalid currency conversion: %v\n", err)
+				logJob(jobID, err.Error())
+				db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+				return
+			}
+			rows = &convertStream{rowStream: rows, convs: convs, rates: rates}
+		}
+
+		if req.NumberFormat == "eu" {
+			var cols []int
+			for i, c := range p.Columns {
+				if isNumericType(p.Types[c]) {
+					cols = append(cols, i)
+				}
+			}
+			rows = &localeStream{rowStream: rows, cols: cols}
+		}
+
+		if cols := exactNumericColumns(p); len(cols) > 0 {
+			rows = &scientificStream{rowStream: rows, cols: cols}
+		}
+
+		if cols := numericColumns(p); len(cols) > 0 {
+			rows = &magnitudeStream{rowStream: rows, cols: cols}
+		}
+
+		if req.SourceColumns && p.Source != nil {
+			rows = &appendStream{
+				rowStream: rows,
+				width:     len(p.Columns) - len(sourceColumns) - len(req.Derived) - enrichedColumns(req),
+				values:    p.Source.values(),
+			}
+		}
+	}
+
+	if req.MaxRows > 0 {
+		rows = &limitStream{rowStream: rows, left: req.MaxRows}
+	}
+
+	// Dates are made ISO before cleaning, which drops the comma of
+	// "Jan 2, 2006".
+	loc, err := outputLocation(req.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	rows = newDateStream(rows, p, loc)
+
+	pipeline, err := newCleaningPipeline(req, p.Columns)
+	if err != nil {
+		fmt.Printf("❌ Invalid cleaning rules: %v\n", err)
+		db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+		return
+	}
+	rows = &cleanStream{rowStream: rows, pipeline: pipeline}
+
+	if len(req.Derived) > 0 {
+		width := len(p.Columns) - len(req.Derived) - enrichedColumns(req)
+		derived, err := compileDerived(req.Derived, p.Columns[:width])
+		if err != nil {
+			fmt.Printf("❌ Invalid derived columns: %v\n", err)
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return
+		}
+		rows = &derivedStream{rowStream: rows, width: width, derived: derived}
+	}
+
+	if len(req.Lookups) > 0 {
+		width := len(p.Columns) - enrichedColumns(req)
+		lookups, err := loadLookups(req, p.Columns[:width])
+		if err != nil {
+			fmt.Printf("❌ Invalid lookups: %v\n", err)
+			logJob(jobID, err.Error())
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return
+		}
+		rows = &enrichStream{rowStream: rows, width: width, lookups: lookups, jobID: jobID}
+	}
+
+	if len(req.Mapping) > 0 {
+		mappings, err := compileMappings(req, p.Columns)
+		if err != nil {
+			fmt.Printf("❌ Invalid mapping: %v\n", err)
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return
+		}
+		rows = &mapStream{rowStream: rows, mappings: mappings}
+	}
+
+	if len(req.Defaults) > 0 {
+		defaults, err := compileDefaults(req, p.Columns)
+		if err != nil {
+			fmt.Printf("❌ Invalid defaults: %v\n", err)
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return
+		}
+		rows = &defaultStream{rowStream: rows, defaults: defaults}
+	}
+
+	var filter *filterStream
+	if req.Filter != "" {
+		e, err := compileFilter(req.Filter, p.Columns)
+		if err != nil {
+			fmt.Printf("❌ Invalid filter: %v\n", err)
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return
+		}
+		filter = &filterStream{rowStream: rows, filter: e}
+		rows = filter
+	}
+
+	var validate *validateStream
+	if len(req.Validate) > 0 {
+		validations, err := compileValidations(req, p.Columns)
+		if err != nil {
+			fmt.Printf("❌ Invalid validation rules: %v\n", err)
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return
+		}
+		validate = &validateStream{rowStream: rows, validations: validations, jobID: jobID}
+		rows = validate
+	}
 
-	consumer, _ := sarama.NewConsumer(
-		[]string{os.Getenv("KAFKA_BROKER")},
-		cfg,
-	)
+	if len(req.Mask) > 0 {
+		masks, err := compileMasks(req.Mask, p.Columns, req.Rename)
+		if err != nil {
+			fmt.Printf("❌ Invalid mask: %v\n", err)
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return
+		}
+		rows = &maskStream{rowStream: rows, masks: masks, tokens: map[string]bool{}}
+	}
+
+	dedup, err := newDedupPolicy(req, p.Columns)
+	if err != nil {
+		fmt.Printf("❌ Invalid dedup: %v\n", err)
+		db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+		return
+	}
 
-	pc, _ := consumer.ConsumePartition(
-		"table_rows", 0, sarama.OffsetNewest,
-	)
+	keys, err := upsertKeyColumns(req, p.Columns)
+	if err != nil {
+		fmt.Printf("❌ Invalid upsert_keys: %v\n", err)
+		db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+		return
+	}
 
-	for msg := range pc.Messages() {
+	var completed bool
+	if sinkFor(req) == sinkClickHouse {
+		completed = insertClickHouse(p, rows, table, mode, jobID)
+	} else {
+		completed = insertRows(p, rows, table, mode, keys, dedup, transactionFor(req), jobID)
+	}
 
-		var payload map[string]interface{}
-		json.Unmarshal(msg.Value, &payload)
+	if filter != nil && filter.dropped > 0 {
+		db.Exec(`UPDATE ingestion_jobs SET filtered_rows = filtered_rows + ? WHERE id=?`, filter.dropped, jobID)
+		logJob(jobID, fmt.Sprintf("%d rows filtered out", filter.dropped))
+	}
 
-		p := convertPreview(payload["preview"])
-		table := payload["table"].(string)
-		mode := payload["mode"].(string)
-		dedup := payload["dedup"].(bool)
-		jobID := payload["job_id"].(string)
+	if validate != nil && validate.rejected > 0 {
+		logJob(jobID, fmt.Sprintf("%d rows failed validation", validate.rejected))
+	}
 
-		insertRows(p, table, mode, dedup, jobID)
+	if completed {
+		registerSchema(table, p, req)
 	}
 }
 
@@ -481,6 +1831,10 @@ func cleanValue(v string) string {
 	// Clean the value the same way we do for inference
 	v = strings.TrimSpace(v)
 
+	if isJSONDocument(v) {
+		return v
+	}
+
 	// Remove currency symbols and formatting
 	v = strings.ReplaceAll(v, ",", "")
 	v = strings.ReplaceAll(v, "$", "")
@@ -494,22 +1848,114 @@ func cleanValue(v string) string {
 		v = v[:i]
 	}
 
-	return strings.TrimSpace(v)
+	return accountingNegative(strings.TrimSpace(v))
+}
+
+// Rows are inserted in chunks of insertChunkRows, fewer for wide tables
+// so a statement stays under MySQL's 65535 placeholder limit.
+const (
+	insertChunkRows = 500
+	maxInsertParams = 60000
+)
+
+// rowStream yields data rows one at a time and returns io.EOF when done,
+// so the consumer can insert sources that are never held in memory.
+type rowStream interface {
+	Next() ([]string, error)
+	Close() error
+}
+
+type sliceStream struct {
+	rows [][]string
+	pos  int
+}
+
+func newSliceStream(rows [][]string) *sliceStream {
+	return &sliceStream{rows: rows}
+}
+
+func (s *sliceStream) Next() ([]string, error) {
+
+	if s.pos >= len(s.rows) {
+		return nil, io.EOF
+	}
+
+	s.pos++
+	return s.rows[s.pos-1], nil
+}
+
+func (s *sliceStream) Close() error { return nil }
+
+// limitStream ends a stream after a fixed number of rows.
+type limitStream struct {
+	rowStream
+	left int
+}
+
+func (s *limitStream) Next() ([]string, error) {
+
+	if s.left <= 0 {
+		return nil, io.EOF
+	}
+
+	s.left--
+	return s.rowStream.Next()
+}
+
+// drainRows reads up to limit rows (0 means all) and closes the stream.
+func drainRows(s rowStream, limit int) ([][]string, error) {
+
+	defer s.Close()
+
+	var rows [][]string
+
+	for limit <= 0 || len(rows) < limit {
+		r, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, r)
+	}
+
+	return rows, nil
 }
 
-func insertRows(p Preview, table, mode string, dedup bool, jobID string) {
+// insertRows creates or extends table and writes rows into it, committed
+// as scope says (transaction.go). It reports whether the job completed.
+func insertRows(p Preview, rows rowStream, table, mode string, upsertKeys []string, dedup *dedupPolicy, scope, jobID string) bool {
+
+	defer rows.Close()
 
 	fmt.Printf("📊 Starting ingestion for table '%s' (mode: %s, rows: %d)\n", table, mode, len(p.Rows))
 
-	if mode == "create" {
+	// A job the consumer was cut off in continues after the rows it had
+	// flushed (offsets.go); its table must not be dropped again.
+	resume, inserted := jobCheckpoint(jobID)
+	if resume > 0 {
+		fmt.Printf("↩️  Resuming job %s after row %d\n", jobID, resume)
+	}
+
+	if mode == "create" && resume == 0 {
 		db.Exec("DROP TABLE IF EXISTS " + table)
 		fmt.Printf("🗑️  Dropped existing table '%s'\n", table)
 	}
 
 	create := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s(", table)
 
+	notNull := map[string]bool{}
+	for _, c := range p.NotNull {
+		notNull[c] = true
+	}
+
 	for _, c := range p.Columns {
-		create += fmt.Sprintf("%s %s,", c, p.Types[c])
+		col := fmt.Sprintf("%s %s", c, p.Types[c])
+		if notNull[c] {
+			col += " NOT NULL"
+		}
+		create += col + ","
 	}
 
 	create = create[:len(create)-1] + ")"
@@ -517,61 +1963,252 @@ func insertRows(p Preview, table, mode string, dedup bool, jobID string) {
 	if _, err := db.Exec(create); err != nil {
 		fmt.Printf("❌ Failed to create table: %v\n", err)
 		db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
-		return
+		return false
+	}
+
+	if mode != "create" {
+		widenColumns(table, p, jobID)
+	}
+
+	columns := p.Columns
+	upsert := false
+	if mode == modeUpsert {
+		if err := ensureUpsertKey(table, upsertKeys); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			logJob(jobID, err.Error())
+			db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=?`, jobID)
+			return false
+		}
+		upsert = true
+	}
+	if dedup != nil {
+		ensureDedupKey(table)
+		columns = append(columns[:len(columns):len(columns)], dedupKeyColumn)
+		upsert = dedup.upsert()
 	}
 
 	fmt.Printf("✓ Created table schema\n")
 
-	inserted := 0
 	failed := 0
+	seen := 0
+	skipped := 0 // rows skip_existing found in the table
+
+	// The rows go through txn (transaction.go); with the job scope
+	// nothing is committed before the end, so a failure resets inserted.
+	txn := &jobTx{scope: scope}
+	defer txn.close()
+	base := inserted
+
+	var chunk [][]string
+	var nums []int // row number of each row in chunk
+
+	// flush writes the chunk as one multi-row INSERT. When that fails
+	// outside a transaction the rows are retried one at a time, so a bad
+	// row only fails itself; in one the job fails, with the first row that
+	// does not insert. done is how many rows have been handled with the
+	// chunk written.
+	flush := func(done int) error {
+
+		if len(chunk) == 0 {
+			return nil
+		}
 
-	for _, r := range p.Rows {
+		ok := len(chunk)
+		n, err := insertChunk(txn, table, columns, chunk, upsert)
+		if err != nil && txn.scope != txNone {
+			for i, r := range chunk {
+				if _, err := insertChunk(txn, table, columns, [][]string{r}, upsert); err != nil {
+					return fmt.Errorf("row %d: %w", nums[i], err)
+				}
+			}
+			return fmt.Errorf("rows %d to %d: %w", nums[0], nums[len(nums)-1], err)
+		}
+		if err != nil {
+			n = 0
+			for _, r := range chunk {
+				m, err := insertChunk(txn, table, columns, [][]string{r}, upsert)
+				if err != nil {
+					failed++
+					ok--
+					if failed <= 5 {
+						fmt.Printf("⚠️  Row insert error: %v\n", err)
+					}
+					continue
+				}
+				n += m
+			}
+		}
 
-		query := fmt.Sprintf("INSERT IGNORE INTO %s VALUES(", table)
+		chunk, nums = chunk[:0], nums[:0]
 
-		for range r {
-			query += "?,"
+		switch txn.scope {
+		case txChunk:
+			txn.Exec(`
+			UPDATE ingestion_jobs
+			SET inserted_rows=?, total_rows=GREATEST(total_rows, ?), checkpoint_rows=?
+			WHERE id=?`,
+				inserted+n, seen, done, jobID)
+			if err := txn.commit(); err != nil {
+				return err
+			}
+		case txJob:
+			// Progress only: a restart begins the job again.
+			db.Exec(`
+			UPDATE ingestion_jobs
+			SET inserted_rows=?, total_rows=GREATEST(total_rows, ?)
+			WHERE id=?`,
+				inserted+n, seen, jobID)
+		default:
+			db.Exec(`
+			UPDATE ingestion_jobs
+			SET inserted_rows=?, total_rows=GREATEST(total_rows, ?), checkpoint_rows=?
+			WHERE id=?`,
+				inserted+n, seen, done, jobID)
 		}
 
-		query = query[:len(query)-1] + ")"
+		inserted += n
+		if dedup != nil {
+			skipped += ok - n
+		}
+		fmt.Printf("📝 Progress: %d/%d rows inserted\n", inserted, seen)
 
-		args := make([]interface{}, len(r))
+		return nil
+	}
 
-		for i := range r {
-			args[i] = cleanValue(r[i])
+	// fail rolls back what the transaction holds and fails the job.
+	fail := func(err error) bool {
+		txn.rollback()
+		if txn.scope == txJob {
+			inserted = base
 		}
+		fmt.Printf("❌ Ingestion of '%s' failed: %v\n", table, err)
+		logJob(jobID, err.Error())
+		db.Exec(`
+		UPDATE ingestion_jobs
+		SET inserted_rows=?, total_rows=?, status='failed'
+		WHERE id=?`,
+			inserted, seen, jobID)
+		return false
+	}
 
-		result, err := db.Exec(query, args...)
+	for {
+
+		r, err := rows.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			failed++
-			if failed <= 5 {
-				fmt.Printf("⚠️  Row insert error: %v\n", err)
+			// Outside a job transaction the rows read so far are kept.
+			if txn.scope != txJob {
+				if err := flush(seen); err != nil {
+					return fail(err)
+				}
+			}
+			return fail(fmt.Errorf("reading source rows: %w", err))
+		}
+
+		seen++
+
+		if dedup != nil {
+			if r = dedup.admit(r); r == nil {
+				continue
 			}
+		}
+
+		// Rows written before a restart still go through dedup above, so
+		// its keys cover them.
+		if seen <= resume {
 			continue
 		}
 
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected > 0 {
-			inserted++
+		// A multi-row INSERT needs every row to be the same width.
+		if len(chunk) > 0 && len(r) != len(chunk[0]) {
+			if err := flush(seen - 1); err != nil {
+				return fail(err)
+			}
 		}
 
-		if inserted%50 == 0 {
-			db.Exec(`
-			UPDATE ingestion_jobs
-			SET inserted_rows=?
-			WHERE id=?`,
-				inserted, jobID)
-			fmt.Printf("📝 Progress: %d/%d rows inserted\n", inserted, len(p.Rows))
+		chunk = append(chunk, r)
+		nums = append(nums, seen)
+
+		if len(chunk) >= insertChunkRows || len(chunk)*len(r) >= maxInsertParams {
+			if err := flush(seen); err != nil {
+				return fail(err)
+			}
 		}
 	}
 
+	if err := flush(seen); err != nil {
+		return fail(err)
+	}
+
+	if err := txn.commit(); err != nil {
+		return fail(err)
+	}
+
+	deduped := skipped
+	if dedup != nil {
+		deduped += dedup.dropped
+	}
+
 	db.Exec(`
 	UPDATE ingestion_jobs
-	SET inserted_rows=?, status='completed'
+	SET inserted_rows=?, total_rows=?, deduped_rows=?, status='completed'
 	WHERE id=?`,
-		inserted, jobID)
+		inserted, seen, deduped, jobID)
+
+	fmt.Printf("✅ Ingestion complete: %d inserted, %d failed, %d duplicates\n", inserted, failed, deduped)
+
+	return true
+}
+
+// insertChunk inserts rows of equal width with one statement and returns
+// how many were new. Rows fill cols from the left. With upsert, rows
+// replace those sharing a unique key and all count as written.
+func insertChunk(exec execer, table string, cols []string, rows [][]string, upsert bool) (int, error) {
+
+	if len(rows[0]) < len(cols) {
+		cols = cols[:len(rows[0])]
+	}
+
+	tuple := "(" + strings.TrimSuffix(strings.Repeat("?,", len(rows[0])), ",") + ")"
+
+	query := fmt.Sprintf("INSERT IGNORE INTO %s (%s) VALUES %s", table, strings.Join(cols, ","),
+		strings.TrimSuffix(strings.Repeat(tuple+",", len(rows)), ","))
+
+	if upsert {
+		set := make([]string, len(cols))
+		for i, c := range cols {
+			set[i] = fmt.Sprintf("%s=VALUES(%s)", c, c)
+		}
+		query = strings.Replace(query, "INSERT IGNORE", "INSERT", 1) +
+			" ON DUPLICATE KEY UPDATE " + strings.Join(set, ",")
+	}
+
+	args := make([]interface{}, 0, len(rows)*len(rows[0]))
+	for _, r := range rows {
+		for _, v := range r {
+			if v == "" {
+				// Empty cells, including blanked placeholders, are NULL.
+				args = append(args, nil)
+				continue
+			}
+			args = append(args, v)
+		}
+	}
+
+	result, err := exec.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	if upsert {
+		// MySQL counts a replaced row twice.
+		return len(rows), nil
+	}
 
-	fmt.Printf("✅ Ingestion complete: %d inserted, %d failed\n", inserted, failed)
+	n, _ := result.RowsAffected()
+	return int(n), nil
 }
 
 ///////////////////////////////////////////////////////////
@@ -583,18 +2220,34 @@ func jobStatusHandler(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 
 	row := db.QueryRow(`
-	SELECT total_rows, inserted_rows, status
+	SELECT total_rows, inserted_rows, COALESCE(filtered_rows, 0), COALESCE(deduped_rows, 0), status,
+	       (SELECT COUNT(*) FROM ingestion_rejects WHERE job_id=ingestion_jobs.id),
+	       COALESCE(chunks_total, 0), COALESCE(chunks_received, 0),
+	       COALESCE(source_url, ''), COALESCE(source_title, ''),
+	       COALESCE(table_caption, ''), COALESCE(DATE_FORMAT(fetched_at, '%Y-%m-%d %H:%i:%s'), ''),
+	       COALESCE(source_timezones, '')
 	FROM ingestion_jobs WHERE id=?`, id)
 
-	var total, inserted int
-	var status string
+	var total, inserted, filtered, deduped, rejected, chunks, received int
+	var status, zones string
+	var source SourceMeta
+
+	row.Scan(&total, &inserted, &filtered, &deduped, &status, &rejected, &chunks, &received,
+		&source.URL, &source.Title, &source.Caption, &source.FetchedAt, &zones)
 
-	row.Scan(&total, &inserted, &status)
+	if zones != "" {
+		json.Unmarshal([]byte(zones), &source.TimeZones)
+	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"total":    total,
-		"inserted": inserted,
-		"status":   status,
+		"total":        total,
+		"inserted":     inserted,
+		"filtered":     filtered,
+		"deduplicated": deduped,
+		"rejected":     rejected,
+		"chunks":       map[string]int{"total": chunks, "received": received},
+		"status":       status,
+		"source":       source,
 	})
 }
 
@@ -717,4 +2370,14 @@ func convertPreview(v interface{}) Preview {
 	json.Unmarshal(b, &p)
 
 	return p
+}
+
+func convertRequest(v interface{}) IngestRequest {
+
+	b, _ := json.Marshal(v)
+
+	var req IngestRequest
+	json.Unmarshal(b, &req)
+
+	return req
 }
\ No newline at end of file
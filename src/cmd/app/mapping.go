@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// VALUE MAPPING ///////////////////////
+///////////////////////////////////////////////////////////
+
+// ValueMapping standardizes the values of a column, like a SQL CASE:
+//
+//	"mapping": {"country": {"values": {"USA": "US", "U.S.": "US", "United States": "US"}, "ignore_case": true},
+//	            "size": {"when": [{"if": "revenue > 1000000000", "then": "large"}], "else": "small"}}
+//
+// A cell found in values is replaced; otherwise the first rule whose
+// expression (see expr.go) holds gives the value, then else. Cells nothing
+// matches are kept. Rules see the cleaned row as it was before any
+// mapping, including derived and lookup columns.
+type ValueMapping struct {
+	Values     map[string]string `json:"values"`      // exact value -> replacement
+	IgnoreCase bool              `json:"ignore_case"` // match values ignoring case and surrounding spaces
+	When       []MappingRule     `json:"when"`        // conditions tried in order after values
+	Else       *string           `json:"else"`        // value when nothing matched; unmatched cells are kept when unset
+}
+
+// MappingRule gives a column the value Then on the rows If holds for.
+type MappingRule struct {
+	If   string `json:"if"`
+	Then string `json:"then"`
+}
+
+// columnMapping is the compiled mapping of one column.
+type columnMapping struct {
+	col    int
+	values map[string]string
+	fold   bool
+	when   []expr
+	then   []string
+	orElse *string
+}
+
+// compileMappings resolves the mappings of req against cols.
+func compileMappings(req IngestRequest, cols []string) ([]columnMapping, error) {
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	names := make([]string, 0, len(req.Mapping))
+	for name := range req.Mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out []columnMapping
+
+	for _, name := range names {
+
+		m := req.Mapping[name]
+		col := renamedColumn(name, req.Rename)
+		i, ok := index[col]
+		if !ok {
+			return nil, fmt.Errorf("mapping: unknown column %q (available: %s)", col, strings.Join(cols, ", "))
+		}
+		if len(m.Values) == 0 && len(m.When) == 0 && m.Else == nil {
+			return nil, fmt.Errorf("mapping: column %s has no values, when or else", col)
+		}
+
+		cm := columnMapping{col: i, values: m.Values, fold: m.IgnoreCase, orElse: m.Else}
+		if m.IgnoreCase {
+			cm.values = map[string]string{}
+			for k, v := range m.Values {
+				cm.values[foldValue(k)] = v
+			}
+		}
+
+		for _, rule := range m.When {
+			e, err := compileExpr(rule.If, cols)
+			if err != nil {
+				return nil, fmt.Errorf("mapping: column %s: %w", col, err)
+			}
+			cm.when = append(cm.when, e)
+			cm.then = append(cm.then, rule.Then)
+		}
+
+		out = append(out, cm)
+	}
+
+	return out, nil
+}
+
+func foldValue(v string) string {
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+// outputs lists every value the mapping can write.
+func (m columnMapping) outputs() []string {
+
+	var out []string
+	for _, v := range m.values {
+		out = append(out, v)
+	}
+	out = append(out, m.then...)
+	if m.orElse != nil {
+		out = append(out, *m.orElse)
+	}
+	sort.Strings(out)
+
+	return out
+}
+
+// apply returns the mapped value of the column in row.
+func (m columnMapping) apply(row []string) string {
+
+	cell := row[m.col]
+	key := cell
+	if m.fold {
+		key = foldValue(cell)
+	}
+	if v, ok := m.values[key]; ok && cell != "" {
+		return v
+	}
+
+	for i, e := range m.when {
+		if truthy(e.eval(row)) {
+			return m.then[i]
+		}
+	}
+
+	if m.orElse != nil {
+		return *m.orElse
+	}
+
+	return cell
+}
+
+// mapRow maps the cells of a cleaned row. Rules see the row before any
+// mapping, so the order of the mappings does not matter.
+func mapRow(row []string, mappings []columnMapping) []string {
+
+	if len(mappings) == 0 {
+		return row
+	}
+
+	out := append([]string{}, row...)
+	for _, m := range mappings {
+		if m.col < len(out) {
+			out[m.col] = m.apply(row)
+		}
+	}
+
+	return out
+}
+
+// mapColumns checks the mappings against the preview's types, growing
+// ENUM and VARCHAR columns to hold the mapped values. The preview rows
+// keep their source values: like masking, mapping runs in the consumer.
+func mapColumns(p Preview, req IngestRequest) (Preview, error) {
+
+	if len(req.Mapping) == 0 {
+		return p, nil
+	}
+
+	mappings, err := compileMappings(req, p.Columns)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	for _, m := range mappings {
+		name := p.Columns[m.col]
+		for _, v := range m.outputs() {
+			if v == "" {
+				continue
+			}
+			t, err := defaultType(v, p.Types[name])
+			if err != nil {
+				return Preview{}, fmt.Errorf("mapping: column %s is %s: %w", name, p.Types[name], err)
+			}
+			p.Types[name] = t
+		}
+	}
+
+	return p, nil
+}
+
+// nullableMappings drops from p.NotNull the columns a mapping can empty.
+func nullableMappings(p Preview, req IngestRequest) Preview {
+
+	mappings, err := compileMappings(req, p.Columns)
+	if err != nil || len(mappings) == 0 {
+		return p
+	}
+
+	nullable := map[string]bool{}
+	for _, m := range mappings {
+		for _, v := range m.outputs() {
+			if v == "" {
+				nullable[p.Columns[m.col]] = true
+			}
+		}
+	}
+
+	var notNull []string
+	for _, c := range p.NotNull {
+		if !nullable[c] {
+			notNull = append(notNull, c)
+		}
+	}
+	p.NotNull = notNull
+
+	return p
+}
+
+// mapStream maps cleaned rows on their way to MySQL.
+type mapStream struct {
+	rowStream
+	mappings []columnMapping
+}
+
+func (s *mapStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return mapRow(row, s.mappings), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// PII MASKING /////////////////////////
+///////////////////////////////////////////////////////////
+
+// Masking policies, applied by the consumer as the last step before a
+// row is inserted, so filters and derived columns still see the source:
+//
+//	hash      HMAC-SHA256 of the value, 64 hex characters
+//	last4     every character but the last four becomes *
+//	redact    the value is dropped (NULL)
+//	tokenize  a tok_ token; the value is kept in ingestion_tokens
+//
+// hash and tokenize are keyed with MASK_SECRET, so equal values still
+// join and deduplicate but cannot be recovered by hashing guesses.
+var maskPolicies = map[string]bool{"hash": true, "last4": true, "redact": true, "tokenize": true}
+
+var maskSecret = []byte(os.Getenv("MASK_SECRET"))
+
+// columnMask is the resolved policy of one column.
+type columnMask struct {
+	col    int
+	name   string
+	policy string
+}
+
+// compileMasks resolves the mask of a request against the table's
+// columns. Names may be the detected name of a renamed column.
+func compileMasks(defs map[string]string, cols []string, rename map[string]string) ([]columnMask, error) {
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	var out []columnMask
+
+	for name, policy := range defs {
+
+		col := renamedColumn(name, rename)
+		i, ok := index[col]
+		if !ok {
+			return nil, fmt.Errorf("mask: unknown column %q (available: %s)", col, strings.Join(cols, ", "))
+		}
+
+		policy = strings.ToLower(policy)
+		if !maskPolicies[policy] {
+			return nil, fmt.Errorf("mask: unknown policy %q for column %s (use hash, last4, redact or tokenize)", policy, col)
+		}
+
+		out = append(out, columnMask{i, col, policy})
+	}
+
+	return out, nil
+}
+
+func maskDigest(v string) string {
+
+	mac := hmac.New(sha256.New, maskSecret)
+	mac.Write([]byte(v))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func maskToken(v string) string {
+	return "tok_" + maskDigest(v)[:24]
+}
+
+func maskLast4(v string) string {
+
+	r := []rune(v)
+	if len(r) <= 4 {
+		return strings.Repeat("*", len(r))
+	}
+
+	return strings.Repeat("*", len(r)-4) + string(r[len(r)-4:])
+}
+
+// maskColumns sets the column types the masked values need. Redacted
+// columns hold only NULL, so they are never NOT NULL.
+func maskColumns(p Preview, defs map[string]string, rename map[string]string) (Preview, error) {
+
+	masks, err := compileMasks(defs, p.Columns, rename)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	redacted := map[string]bool{}
+
+	for _, m := range masks {
+		switch m.policy {
+		case "hash":
+			p.Types[m.name] = "CHAR(64)"
+		case "tokenize":
+			p.Types[m.name] = "CHAR(28)"
+		case "redact":
+			redacted[m.name] = true
+		case "last4":
+			if _, ok := varcharLength(p.Types[m.name]); ok || p.Types[m.name] == "TEXT" {
+				continue
+			}
+			longest := 0
+			for _, r := range p.Rows {
+				if m.col < len(r) {
+					longest = max(longest, utf8.RuneCountInString(r[m.col]))
+				}
+			}
+			p.Types[m.name] = stringType(longest)
+		}
+	}
+
+	var notNull []string
+	for _, c := range p.NotNull {
+		if !redacted[c] {
+			notNull = append(notNull, c)
+		}
+	}
+	p.NotNull = notNull
+
+	return p, nil
+}
+
+// maskStream masks cleaned rows on their way to MySQL.
+type maskStream struct {
+	rowStream
+	masks  []columnMask
+	tokens map[string]bool // tokens already in ingestion_tokens
+}
+
+func (s *maskStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	row = append([]string{}, row...)
+
+	for _, m := range s.masks {
+
+		if m.col >= len(row) || row[m.col] == "" {
+			continue
+		}
+
+		v := row[m.col]
+		switch m.policy {
+		case "hash":
+			row[m.col] = maskDigest(v)
+		case "last4":
+			row[m.col] = maskLast4(v)
+		case "redact":
+			row[m.col] = ""
+		case "tokenize":
+			token := maskToken(v)
+			if !s.tokens[token] {
+				db.Exec(`INSERT IGNORE INTO ingestion_tokens (token, column_name, value) VALUES (?, ?, ?)`,
+					token, m.name, v)
+				s.tokens[token] = true
+			}
+			row[m.col] = token
+		}
+	}
+
+	return row, nil
+}
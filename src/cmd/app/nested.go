@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// NESTED TABLES ///////////////////////
+///////////////////////////////////////////////////////////
+
+// nested_tables decides what happens to a <table> inside a cell:
+//   flatten (default): its cell text joins the outer cell's text
+//   ignore:            it is dropped from the outer cell
+//   extract:           flattened, and also returned as its own preview
+
+// ownRows returns the rows of table itself, leaving out rows of tables
+// nested inside its cells.
+func ownRows(table *goquery.Selection) *goquery.Selection {
+
+	return table.Find("tr").FilterFunction(func(_ int, tr *goquery.Selection) bool {
+		return tr.Closest("table").IsSelection(table)
+	})
+}
+
+// childTables returns the tables directly nested in table's cells.
+func childTables(table *goquery.Selection) *goquery.Selection {
+
+	return table.Find("table").FilterFunction(func(_ int, inner *goquery.Selection) bool {
+		return inner.Parent().Closest("table").IsSelection(table)
+	})
+}
+
+func validNestedMode(mode string) error {
+
+	switch mode {
+	case "", "flatten", "ignore", "extract":
+		return nil
+	default:
+		return fmt.Errorf("unknown nested_tables mode %q", mode)
+	}
+}
+
+// collapseNestedTables rewrites child tables in place so the outer cells
+// read as plain text.
+func collapseNestedTables(table *goquery.Selection, mode string) {
+
+	childTables(table).Each(func(_ int, inner *goquery.Selection) {
+		if mode == "ignore" {
+			inner.Remove()
+			return
+		}
+		inner.ReplaceWithHtml(" " + html.EscapeString(tableText(inner)) + " ")
+	})
+}
+
+// tableText joins every cell of a table, inner tables included, with spaces.
+func tableText(table *goquery.Selection) string {
+
+	var parts []string
+
+	table.Find("th, td").Each(func(_ int, c *goquery.Selection) {
+		if c.Find("table").Length() > 0 {
+			return
+		}
+		if text := strings.Join(strings.Fields(c.Text()), " "); text != "" {
+			parts = append(parts, text)
+		}
+	})
+
+	return strings.Join(parts, " ")
+}
+
+// nestedPreviews parses each child table as a table of its own. Tables
+// without a header or data rows are skipped.
+func nestedPreviews(table *goquery.Selection, req IngestRequest) []Preview {
+
+	var previews []Preview
+
+	childTables(table).Each(func(_ int, inner *goquery.Selection) {
+
+		grid := tableGrid(inner, func(c *goquery.Selection) string {
+			return strings.Join(strings.Fields(c.Text()), " ")
+		})
+
+		cols, rows := gridTable(grid, req)
+
+		if p, err := buildPreview(cols, rows); err == nil {
+			previews = append(previews, p)
+		}
+	})
+
+	return previews
+}
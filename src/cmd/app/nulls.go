@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// NULLS ///////////////////////////////
+///////////////////////////////////////////////////////////
+
+// Placeholder cells ("N/A", "—", "null") are rewritten to empty strings
+// while parsing, and empty cells are inserted as SQL NULL. null_values
+// replaces the default vocabulary; matching ignores case and spacing.
+var defaultNullValues = []string{"n/a", "na", "null", "none", "-", "—", "–"}
+
+type nullSet map[string]bool
+
+func newNullSet(values []string) nullSet {
+
+	if values == nil {
+		values = defaultNullValues
+	}
+
+	set := nullSet{}
+	for _, v := range values {
+		set[strings.ToLower(strings.TrimSpace(v))] = true
+	}
+
+	return set
+}
+
+func (n nullSet) has(v string) bool {
+	return n[strings.ToLower(strings.TrimSpace(v))]
+}
+
+// detectNulls blanks placeholder cells and re-infers the columns that had
+// any, so "N/A" no longer keeps a numeric column TEXT.
+func detectNulls(p Preview, nulls nullSet) Preview {
+
+	changed := map[int]bool{}
+
+	for _, r := range p.Rows {
+		for c, v := range r {
+			if v != "" && nulls.has(v) {
+				r[c] = ""
+				changed[c] = true
+			}
+		}
+	}
+
+	if len(changed) == 0 {
+		return p
+	}
+
+	types := inferTypes(p.Columns, p.Rows)
+	for c := range changed {
+		if c < len(p.Columns) {
+			p.Types[p.Columns[c]] = types[p.Columns[c]]
+		}
+	}
+
+	fmt.Printf("✓ Read placeholder cells as NULL in %d columns\n", len(changed))
+
+	return p
+}
+
+// notNullColumns lists the columns with a value in every row.
+func notNullColumns(p Preview) []string {
+
+	var cols []string
+
+	for c, name := range p.Columns {
+		full := len(p.Rows) > 0
+		for _, r := range p.Rows {
+			if c >= len(r) || r[c] == "" {
+				full = false
+				break
+			}
+		}
+		if full {
+			cols = append(cols, name)
+		}
+	}
+
+	return cols
+}
+
+// nullStream blanks placeholder cells of a streamed source.
+type nullStream struct {
+	rowStream
+	nulls nullSet
+}
+
+func (s *nullStream) Next() ([]string, error) {
+
+	r, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, v := range r {
+		if s.nulls.has(v) {
+			r[i] = ""
+		}
+	}
+
+	return r, nil
+}
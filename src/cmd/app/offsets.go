@@ -0,0 +1,105 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// CONSUMER OFFSETS ////////////////////
+///////////////////////////////////////////////////////////
+
+// The consumer checkpoints its position in ingestion_offsets, so jobs
+// published while it is down are consumed when it comes back. The saved
+// offset is that of the oldest message still needed: the schema message
+// of the oldest job whose chunks are still arriving, or the job being
+// inserted. After a crash those messages are read again; jobs that already
+// completed or failed are skipped, and a job cut off while inserting
+// resumes after ingestion_jobs.checkpoint_rows, the rows it had flushed.
+// On its very first start the consumer begins at the newest message.
+
+// consumerOffset returns where to start consuming a partition.
+func consumerOffset(topic string, partition int32) int64 {
+
+	var next int64
+	err := db.QueryRow(`
+	SELECT next_offset FROM ingestion_offsets WHERE topic=? AND partition_id=?`,
+		topic, partition).Scan(&next)
+	if err != nil {
+		return sarama.OffsetNewest
+	}
+
+	return next
+}
+
+// consumePartition opens a partition at its checkpoint. A checkpoint the
+// broker no longer holds (past retention) falls back to the oldest
+// message still there.
+func consumePartition(c sarama.Consumer, topic string, partition int32) (sarama.PartitionConsumer, error) {
+
+	offset := consumerOffset(topic, partition)
+
+	pc, err := c.ConsumePartition(topic, partition, offset)
+	if err == sarama.ErrOffsetOutOfRange {
+		fmt.Printf("⚠️  Offset %d of %s/%d is gone, starting at the oldest message\n", offset, topic, partition)
+		pc, err = c.ConsumePartition(topic, partition, sarama.OffsetOldest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("consume %s/%d: %w", topic, partition, err)
+	}
+
+	if offset >= 0 {
+		fmt.Printf("↩️  Resuming %s/%d at offset %d\n", topic, partition, offset)
+	}
+
+	return pc, nil
+}
+
+// saveOffset records that every message before next has been handled.
+func saveOffset(topic string, partition int32, next int64) {
+	db.Exec(`
+	INSERT INTO ingestion_offsets (topic, partition_id, next_offset) VALUES (?, ?, ?)
+	ON DUPLICATE KEY UPDATE next_offset=VALUES(next_offset)`,
+		topic, partition, next)
+}
+
+// resumeOffset is the offset to restart from after the message at
+// offset has been handled: the oldest pending job's schema message, or
+// the next message.
+func resumeOffset(pending map[string]*pendingJob, offset int64) int64 {
+
+	next := offset + 1
+	for _, job := range pending {
+		next = min(next, job.offset)
+	}
+
+	return next
+}
+
+// jobFinished reports whether a replayed job already completed or failed.
+func jobFinished(jobID string) bool {
+
+	var status string
+	err := db.QueryRow(`SELECT status FROM ingestion_jobs WHERE id=?`, jobID).Scan(&status)
+
+	return err == nil && status != "running"
+}
+
+// jobCheckpoint returns how many rows of a job were handled, and how many
+// of those inserted, before the consumer stopped: 0 for a job not
+// started yet.
+func jobCheckpoint(jobID string) (int, int) {
+
+	var rows, inserted sql.NullInt64
+	db.QueryRow(`
+	SELECT checkpoint_rows, inserted_rows FROM ingestion_jobs WHERE id=?`,
+		jobID).Scan(&rows, &inserted)
+
+	if rows.Int64 == 0 {
+		return 0, 0
+	}
+
+	return int(rows.Int64), int(inserted.Int64)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TYPE OVERRIDES //////////////////////
+///////////////////////////////////////////////////////////
+
+// sqlTypePattern lists the column types a request may ask for. Types end
+// up in CREATE TABLE, so anything else is refused.
+var sqlTypePattern = regexp.MustCompile(`^(?:` +
+	`(?:TINYINT|SMALLINT|MEDIUMINT|INT|INTEGER|BIGINT)(?: UNSIGNED)?` +
+	`|(?:DECIMAL|NUMERIC)(?:\(\d{1,2}(?:,\d{1,2})?\))?` +
+	`|FLOAT|DOUBLE|BOOLEAN|BOOL` +
+	`|DATE|DATETIME|TIMESTAMP|TIME|YEAR` +
+	`|(?:VARCHAR|CHAR)\(\d{1,5}\)` +
+	`|TEXT|MEDIUMTEXT|LONGTEXT|JSON` +
+	`)$`)
+
+// applyTypeOverrides replaces inferred types with the ones the request
+// asks for, e.g. TEXT for a zip-code column that looked numeric. Column
+// names are matched after normalization and may be the detected name of
+// a renamed column.
+func applyTypeOverrides(p Preview, overrides, rename map[string]string) (Preview, error) {
+
+	for name, t := range overrides {
+
+		col := renamedColumn(name, rename)
+		if _, ok := p.Types[col]; !ok {
+			return Preview{}, fmt.Errorf("types: unknown column %q (available: %s)", col, strings.Join(p.Columns, ", "))
+		}
+
+		t = strings.Join(strings.Fields(strings.ToUpper(t)), " ")
+		t = strings.ReplaceAll(t, ", ", ",")
+		if !sqlTypePattern.MatchString(t) {
+			return Preview{}, fmt.Errorf("types: unsupported type %q for column %s", t, col)
+		}
+
+		p.Types[col] = t
+	}
+
+	return p, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// PAGINATION //////////////////////////
+///////////////////////////////////////////////////////////
+
+// Pagination describes how to reach the pages after the first one.
+// Either NextSelector points at the "next" link on each page, or
+// URLTemplate contains a {page} token filled with 2, 3, ... MaxPages.
+type Pagination struct {
+	NextSelector string `json:"next_selector"`
+	URLTemplate  string `json:"url_template"`
+	MaxPages     int    `json:"max_pages"`
+}
+
+const (
+	defaultMaxPages = 10
+	maxPagesLimit   = 100
+)
+
+// parsePaginatedTable reads the table on the first page and every page
+// reachable through the pagination rule, concatenating their rows. The
+// header comes from the first page. It stops at the page limit, on a
+// missing or repeated link, or on a page with no table rows.
+func parsePaginatedTable(req IngestRequest, first *fetchedSource) (Preview, error) {
+
+	pg := req.Pagination
+
+	if pg.NextSelector == "" && pg.URLTemplate == "" {
+		return Preview{}, fmt.Errorf("pagination needs next_selector or url_template")
+	}
+
+	limit := pg.MaxPages
+	if limit <= 0 {
+		limit = defaultMaxPages
+	}
+	if limit > maxPagesLimit {
+		limit = maxPagesLimit
+	}
+
+	var cols []string
+	var rows [][]string
+
+	src := first
+	visited := map[string]bool{first.URL: true}
+
+	for page := 1; ; page++ {
+
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(src.Body))
+		if err != nil {
+			return Preview{}, fmt.Errorf("failed to parse page %d: %w", page, err)
+		}
+
+		// Links on each page resolve against that page.
+		pageReq := req
+		pageReq.URL = src.URL
+
+		// The next link is looked up on the whole page, the table only
+		// inside the scope.
+		scoped, err := scopeDocument(doc, req.ScopeSelector)
+		if err != nil {
+			return Preview{}, fmt.Errorf("page %d: %w", page, err)
+		}
+
+		pageCols, pageRows, err := extractTable(scoped, pageReq)
+		if err != nil && page == 1 {
+			return Preview{}, err
+		}
+
+		if cols == nil {
+			cols = pageCols
+		}
+
+		if len(pageRows) == 0 {
+			break
+		}
+
+		rows = append(rows, pageRows...)
+		fmt.Printf("📄 Page %d: %d rows\n", page, len(pageRows))
+
+		if page >= limit {
+			break
+		}
+
+		next := nextPageURL(doc, src.URL, pg, page+1)
+		if next == "" || visited[next] {
+			break
+		}
+		visited[next] = true
+
+		src, err = fetchPage(req, next)
+		if err != nil {
+			return Preview{}, fmt.Errorf("failed to fetch page %d: %w", page+1, err)
+		}
+
+		src, err = decodeSource(src, req.Charset)
+		if err != nil {
+			return Preview{}, fmt.Errorf("page %d: %w", page+1, err)
+		}
+	}
+
+	return buildPreview(cols, rows)
+}
+
+func nextPageURL(doc *goquery.Document, current string, pg *Pagination, page int) string {
+
+	if pg.URLTemplate != "" {
+		return strings.ReplaceAll(pg.URLTemplate, "{page}", strconv.Itoa(page))
+	}
+
+	href, ok := doc.Find(pg.NextSelector).First().Attr("href")
+	if !ok || strings.TrimSpace(href) == "" {
+		return ""
+	}
+
+	base, err := url.Parse(current)
+	if err != nil {
+		return ""
+	}
+
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+
+	return base.ResolveReference(ref).String()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// PERCENTAGES /////////////////////////
+///////////////////////////////////////////////////////////
+
+// Columns where most values end in "%" are stored as DECIMAL. percent_as
+// picks the stored value: "percent" (default) keeps 7.5 for "7.5%",
+// "fraction" stores 0.075.
+
+var percentCell = regexp.MustCompile(`^([-+]?)(\d[\d,]*)(?:\.(\d+))?\s*%$`)
+
+func validPercentAs(v string) error {
+
+	switch v {
+	case "", "percent", "fraction":
+		return nil
+	default:
+		return fmt.Errorf("unknown percent_as %q (use percent or fraction)", v)
+	}
+}
+
+// parsePercent returns the number in a "7.5%" cell, divided by 100 when
+// fraction is set, together with its number of decimals.
+func parsePercent(v string, fraction bool) (string, int, bool) {
+
+	v = strings.TrimSpace(v)
+
+	negative := false
+	if inner, ok := strings.CutPrefix(v, "("); ok && strings.HasSuffix(inner, ")") {
+		// Accounting negative: "(2.5%)".
+		negative, v = true, strings.TrimSpace(strings.TrimSuffix(inner, ")"))
+	}
+
+	m := percentCell.FindStringSubmatch(v)
+	if m == nil {
+		return "", 0, false
+	}
+
+	sign, whole, frac := m[1], strings.ReplaceAll(m[2], ",", ""), m[3]
+	if negative && sign == "" {
+		sign = "-"
+	}
+	if sign == "+" {
+		sign = ""
+	}
+
+	if fraction {
+		// Shift the decimal point in the digits rather than dividing a
+		// float, so 7.5% is exactly 0.075.
+		for len(whole) < 3 {
+			whole = "0" + whole
+		}
+		frac = whole[len(whole)-2:] + frac
+		whole = strings.TrimLeft(whole[:len(whole)-2], "0")
+		if whole == "" {
+			whole = "0"
+		}
+	}
+
+	if frac == "" {
+		return sign + whole, 0, true
+	}
+
+	return sign + whole + "." + frac, len(frac), true
+}
+
+// convertPercentages rewrites percentage columns as DECIMAL values.
+func convertPercentages(p Preview, as string) Preview {
+
+	fraction := as == "fraction"
+	converted := 0
+
+	for c, name := range p.Columns {
+
+		total, parsed, scale := 0, 0, 0
+
+		for _, r := range p.Rows {
+			if c >= len(r) || strings.TrimSpace(r[c]) == "" {
+				continue
+			}
+			total++
+			if _, s, ok := parsePercent(r[c], fraction); ok {
+				parsed++
+				scale = max(scale, s)
+			}
+		}
+
+		if parsed == 0 || float64(parsed) < float64(total)*0.8 {
+			continue
+		}
+
+		for _, r := range p.Rows {
+			if c < len(r) {
+				if v, _, ok := parsePercent(r[c], fraction); ok {
+					r[c] = v
+				}
+			}
+		}
+
+		p.Types[name] = fmt.Sprintf("DECIMAL(20,%d)", min(max(scale, 2), 10))
+		converted++
+	}
+
+	if converted > 0 {
+		fmt.Printf("✓ Stored %d percentage columns as DECIMAL\n", converted)
+	}
+
+	return p
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// PRIORITY LANES //////////////////////
+///////////////////////////////////////////////////////////
+
+// A request's priority is "high", "normal" (the default) or "low", so an
+// urgent market-data refresh is not stuck behind a bulk backfill. Each
+// consumer stream starts its ready jobs highest priority first when a
+// worker frees up; a table's jobs still run in the order they came.
+// Streams have workers of their own, so a stream never holds back its
+// jobs for another's. That orders only the jobs a stream has read: a
+// high job on one Kafka partition does not go before low ones on
+// another, nor before its own partition's unread backlog.
+//
+// With PRIORITY_LANES=true each priority also travels apart: high jobs on
+// "<topic>-high", low jobs on "<topic>-low" and normal ones on the job
+// topic as before (the same names serve as RabbitMQ queues and NATS
+// subjects; SQS takes SQS_QUEUE_URL_HIGH and SQS_QUEUE_URL_LOW), so a
+// backlog of low jobs in the broker does not delay the consumer reaching
+// a high one, and high jobs never wait for workers busy with low ones.
+// Priority across partitions needs lanes.
+
+type jobPriority int
+
+const (
+	priorityHigh jobPriority = iota
+	priorityNormal
+	priorityLow
+)
+
+var priorityNames = [...]string{"high", "normal", "low"}
+
+func (p jobPriority) String() string {
+	return priorityNames[p]
+}
+
+var priorityLanes, _ = strconv.ParseBool(os.Getenv("PRIORITY_LANES"))
+
+func validPriority(priority string) error {
+
+	if _, ok := parsePriority(priority); !ok {
+		return fmt.Errorf("unknown priority %q (use high, normal or low)", priority)
+	}
+
+	return nil
+}
+
+func parsePriority(priority string) (jobPriority, bool) {
+
+	if priority == "" {
+		return priorityNormal, true
+	}
+
+	for p, name := range priorityNames {
+		if name == priority {
+			return jobPriority(p), true
+		}
+	}
+
+	return priorityNormal, false
+}
+
+// payloadPriority reads a schema message's priority; jobs published
+// without one are normal.
+func payloadPriority(payload map[string]interface{}) jobPriority {
+
+	name, _ := payload["priority"].(string)
+	p, _ := parsePriority(name)
+
+	return p
+}
+
+// lanes lists the priorities that travel apart, highest first.
+func lanes() []jobPriority {
+
+	if !priorityLanes {
+		return []jobPriority{priorityNormal}
+	}
+
+	return []jobPriority{priorityHigh, priorityNormal, priorityLow}
+}
+
+// laneOf is the lane jobs of priority p travel in.
+func laneOf(p jobPriority) jobPriority {
+
+	if !priorityLanes {
+		return priorityNormal
+	}
+
+	return p
+}
+
+// laneTopic names lane p's topic, queue or subject.
+func laneTopic(p jobPriority) string {
+
+	if p == priorityNormal {
+		return jobsTopic
+	}
+
+	return jobsTopic + "-" + p.String()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// PROVENANCE //////////////////////////
+///////////////////////////////////////////////////////////
+
+// SourceMeta records where a table came from. It is stored on the job
+// and, with source_columns, added to every row.
+type SourceMeta struct {
+	URL       string `json:"url"`
+	Title     string `json:"title,omitempty"`   // html: page <title>
+	Caption   string `json:"caption,omitempty"` // html: table <caption>
+	FetchedAt string `json:"fetched_at"`        // UTC, "2006-01-02 15:04:05"
+
+	TimeZones map[string][]string `json:"time_zones,omitempty"` // DATETIME column -> zones its values carried
+}
+
+var sourceColumns = []string{"source_url", "source_title", "table_caption", "fetched_at"}
+
+// stampSource fills in the source URL and fetch time after a load.
+func stampSource(p Preview, req IngestRequest) Preview {
+
+	if p.Source == nil {
+		p.Source = &SourceMeta{}
+	}
+
+	p.Source.URL = redactURL(req.URL)
+	if req.Content != "" {
+		p.Source.URL = "inline content"
+	}
+
+	p.Source.FetchedAt = time.Now().UTC().Format("2006-01-02 15:04:05")
+
+	return p
+}
+
+func (m *SourceMeta) values() []string {
+	return []string{m.URL, m.Title, m.Caption, m.FetchedAt}
+}
+
+// addSourceColumns appends the provenance columns to a preview.
+func addSourceColumns(p Preview) Preview {
+
+	values := p.Source.values()
+
+	for _, c := range sourceColumns {
+		p.Columns = append(p.Columns, c)
+		p.Types[c] = "TEXT"
+	}
+	p.Types["fetched_at"] = "DATETIME"
+
+	for r, row := range p.Rows {
+		p.Rows[r] = append(alignRow(row, len(p.Columns)-len(values)), values...)
+	}
+
+	return p
+}
+
+// appendStream adds fixed values to every streamed row, for provenance
+// columns on sources the consumer reads itself.
+type appendStream struct {
+	rowStream
+	width  int
+	values []string
+}
+
+func (s *appendStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(alignRow(row, s.width), s.values...), nil
+}
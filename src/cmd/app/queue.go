@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// QUEUE STATUS ////////////////////////
+///////////////////////////////////////////////////////////
+
+// GET /queue/status reports the consumer's lag (messages waiting in the
+// broker), the jobs in flight (ingestion_jobs still running: queued,
+// assembling or inserting) and each stream's worker. With QUEUE_MAX_LAG
+// or QUEUE_MAX_IN_FLIGHT set, the ingest endpoints answer 429 while either
+// is exceeded, so a backlog stops growing instead of piling up in the
+// broker. The figures are refreshed at most every queueStatusTTL.
+
+var (
+	queueMaxLag      = queueLimitFromEnv("QUEUE_MAX_LAG")
+	queueMaxInFlight = queueLimitFromEnv("QUEUE_MAX_IN_FLIGHT")
+)
+
+const (
+	queueStatusTTL = 5 * time.Second
+	// A stream with lag that has taken no message for this long, while
+	// not running a job, is stalled.
+	queueStallAfter = 2 * time.Minute
+)
+
+// queueLimitFromEnv reads a threshold; 0, the default, is no limit.
+func queueLimitFromEnv(env string) int64 {
+
+	n, err := strconv.ParseInt(os.Getenv(env), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
+
+// streamState is what consumeJobs reports about its stream.
+type streamState struct {
+	stream messageStream
+
+	mu      sync.Mutex
+	pending int       // jobs whose chunks are arriving
+	jobs    []string  // IDs of the jobs being inserted
+	last    time.Time // last message taken
+	stopped bool
+}
+
+func (s *streamState) received(pending int) {
+	s.mu.Lock()
+	s.pending, s.last = pending, time.Now()
+	s.mu.Unlock()
+}
+
+func (s *streamState) running(jobIDs []string, pending int) {
+	s.mu.Lock()
+	s.jobs, s.pending = jobIDs, pending
+	s.mu.Unlock()
+}
+
+func (s *streamState) stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+}
+
+var (
+	consumerMu      sync.Mutex
+	consumerStreams []*streamState
+	consumerErr     error // why the consumer could not start
+)
+
+// watchStreams registers the consumer's streams with /queue/status.
+func watchStreams(streams []messageStream, err error) []*streamState {
+
+	consumerMu.Lock()
+	defer consumerMu.Unlock()
+
+	consumerErr = err
+	for _, s := range streams {
+		consumerStreams = append(consumerStreams, &streamState{stream: s, last: time.Now()})
+	}
+
+	return consumerStreams
+}
+
+type StreamStatus struct {
+	Name          string   `json:"name"`
+	Lag           int64    `json:"lag"`
+	PendingJobs   int      `json:"pending_jobs"`
+	RunningJobs   []string `json:"running_jobs"`
+	LastMessageAt string   `json:"last_message_at"`
+	Health        string   `json:"health"`
+	Error         string   `json:"error,omitempty"`
+}
+
+type QueueStatus struct {
+	Bus       string           `json:"bus"`
+	Health    string           `json:"health"` // ok, starting, stalled or down
+	Error     string           `json:"error,omitempty"`
+	Lag       int64            `json:"lag"`
+	InFlight  int64            `json:"in_flight"`
+	Workers   int              `json:"workers"` // per stream
+	Limits    map[string]int64 `json:"limits"`
+	Accepting bool             `json:"accepting"`
+	Streams   []StreamStatus   `json:"streams"`
+}
+
+var (
+	queueStatusMu   sync.Mutex
+	queueStatusAt   time.Time
+	queueStatusLast QueueStatus
+)
+
+// queueStatus returns the current status, at most queueStatusTTL old.
+func queueStatus() QueueStatus {
+
+	queueStatusMu.Lock()
+	defer queueStatusMu.Unlock()
+
+	if time.Since(queueStatusAt) < queueStatusTTL {
+		return queueStatusLast
+	}
+
+	bus := messageBusKind
+	if bus == "" {
+		bus = "kafka"
+	}
+
+	q := QueueStatus{
+		Bus:     bus,
+		Health:  "ok",
+		Workers: consumerWorkers,
+		Limits:  map[string]int64{"max_lag": queueMaxLag, "max_in_flight": queueMaxInFlight},
+		Streams: []StreamStatus{},
+	}
+
+	db.QueryRow(`SELECT COUNT(*) FROM ingestion_jobs WHERE status='running'`).Scan(&q.InFlight)
+
+	consumerMu.Lock()
+	streams, err := consumerStreams, consumerErr
+	consumerMu.Unlock()
+
+	switch {
+	case err != nil:
+		q.Health, q.Error = "down", err.Error()
+	case len(streams) == 0:
+		q.Health = "starting"
+	}
+
+	for _, s := range streams {
+
+		st := StreamStatus{Name: s.stream.Name(), Health: "ok"}
+
+		lag, err := s.stream.Lag()
+		if err != nil {
+			st.Error = err.Error()
+		}
+		st.Lag = lag
+		q.Lag += lag
+
+		s.mu.Lock()
+		st.PendingJobs, st.RunningJobs = s.pending, append([]string{}, s.jobs...)
+		st.LastMessageAt = s.last.UTC().Format(time.RFC3339)
+		switch {
+		case s.stopped:
+			st.Health = "down"
+		case lag > 0 && len(s.jobs) == 0 && time.Since(s.last) > queueStallAfter:
+			st.Health = "stalled"
+		}
+		s.mu.Unlock()
+
+		if st.Health == "down" || (st.Health == "stalled" && q.Health == "ok") {
+			q.Health = st.Health
+		}
+
+		q.Streams = append(q.Streams, st)
+	}
+
+	q.Accepting = queueBusy(q) == ""
+
+	queueStatusAt, queueStatusLast = time.Now(), q
+
+	return q
+}
+
+// queueBusy says why new jobs are turned away, or "" when they are not.
+func queueBusy(q QueueStatus) string {
+
+	if queueMaxLag > 0 && q.Lag > queueMaxLag {
+		return fmt.Sprintf("queue lag %d exceeds QUEUE_MAX_LAG %d", q.Lag, queueMaxLag)
+	}
+
+	if queueMaxInFlight > 0 && q.InFlight > queueMaxInFlight {
+		return fmt.Sprintf("%d jobs in flight exceed QUEUE_MAX_IN_FLIGHT %d", q.InFlight, queueMaxInFlight)
+	}
+
+	return ""
+}
+
+// busyReason says why ingestion is paused, or "" while the queue is
+// under its thresholds. The gRPC stream and the mailbox poller check it
+// themselves; HTTP handlers call rejectWhenBusy.
+func busyReason() string {
+
+	if queueMaxLag == 0 && queueMaxInFlight == 0 {
+		return ""
+	}
+
+	return queueBusy(queueStatus())
+}
+
+// rejectWhenBusy answers 429 and returns true while the queue is over a
+// threshold. Ingest handlers call it before doing any work.
+func rejectWhenBusy(w http.ResponseWriter) bool {
+
+	reason := busyReason()
+	if reason == "" {
+		return false
+	}
+
+	w.Header().Set("Retry-After", "30")
+	http.Error(w, "ingestion paused: "+reason, http.StatusTooManyRequests)
+
+	return true
+}
+
+func queueStatusHandler(w http.ResponseWriter, r *http.Request) {
+
+	q := queueStatus()
+
+	if q.Health == "down" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(q)
+}
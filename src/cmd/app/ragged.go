@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// RAGGED ROWS /////////////////////////
+///////////////////////////////////////////////////////////
+
+// Ragged-row policies, from most to least lenient:
+//
+//	pad      short rows get empty cells, extra cells are dropped (default)
+//	truncate extra cells are dropped, short rows are rejected
+//	reject   any row that does not match the header width is rejected
+//
+// Rejected rows are kept out of the table and stored in ingestion_rejects.
+var raggedPolicies = map[string]bool{"": true, "pad": true, "truncate": true, "reject": true}
+
+func validRaggedRows(policy string) error {
+
+	if !raggedPolicies[policy] {
+		return fmt.Errorf("unknown ragged_rows %q (use pad, truncate or reject)", policy)
+	}
+
+	return nil
+}
+
+// RejectedRow is a source row kept out of the table. Row is the 1-based
+// data row number.
+type RejectedRow struct {
+	Row    int      `json:"row"`
+	Reason string   `json:"reason"`
+	Cells  []string `json:"cells"`
+}
+
+// fitRow applies the policy to one row. It returns the row at header
+// width, or a reason when the row is rejected.
+func fitRow(row []string, width int, policy string) ([]string, string) {
+
+	switch {
+	case len(row) == width:
+		return row, ""
+	case len(row) < width && policy != "" && policy != "pad":
+		return nil, fmt.Sprintf("row has %d cells, header has %d", len(row), width)
+	case len(row) > width && policy == "reject":
+		return nil, fmt.Sprintf("row has %d cells, header has %d", len(row), width)
+	}
+
+	return alignRow(row, width), ""
+}
+
+// fitRows applies the policy to the preview rows. Rejected rows move to
+// p.Rejected and the types are inferred again without them.
+func fitRows(p Preview, policy string) Preview {
+
+	var kept [][]string
+
+	for i, row := range p.Rows {
+		fitted, reason := fitRow(row, len(p.Columns), policy)
+		if reason != "" {
+			p.Rejected = append(p.Rejected, RejectedRow{Row: i + 1, Reason: reason, Cells: row})
+			continue
+		}
+		kept = append(kept, fitted)
+	}
+
+	p.Rows = kept
+
+	if len(p.Rejected) > 0 {
+		fmt.Printf("⚠️  Rejected %d ragged rows\n", len(p.Rejected))
+		p.Types = inferTypes(p.Columns, p.Rows)
+	}
+
+	return p
+}
+
+// raggedStream applies the policy to a streamed source, recording the
+// rows it rejects against the job.
+type raggedStream struct {
+	rowStream
+	width  int
+	policy string
+	jobID  string
+	row    int
+}
+
+func (s *raggedStream) Next() ([]string, error) {
+
+	for {
+		r, err := s.rowStream.Next()
+		if err != nil {
+			return nil, err
+		}
+		s.row++
+
+		fitted, reason := fitRow(r, s.width, s.policy)
+		if reason == "" {
+			return fitted, nil
+		}
+
+		recordRejects(s.jobID, []RejectedRow{{Row: s.row, Reason: reason, Cells: r}})
+	}
+}
+
+// recordRejects stores rejected rows in the job's error bucket.
+func recordRejects(jobID string, rejected []RejectedRow) {
+
+	for _, r := range rejected {
+		cells, _ := json.Marshal(r.Cells)
+		db.Exec(`
+		INSERT INTO ingestion_rejects (job_id, row_index, reason, row_data)
+		VALUES (?, ?, ?, ?)`,
+			jobID, r.Row, r.Reason, string(cells))
+	}
+}
+
+// jobRejectsHandler lists the rows a job kept out of its table.
+func jobRejectsHandler(w http.ResponseWriter, r *http.Request) {
+
+	id := r.URL.Query().Get("id")
+
+	rows, err := db.Query(`
+	SELECT row_index, reason, row_data
+	FROM ingestion_rejects
+	WHERE job_id=?
+	ORDER BY id
+	LIMIT 500`, id)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer rows.Close()
+
+	rejected := []RejectedRow{}
+
+	for rows.Next() {
+		var rej RejectedRow
+		var cells string
+		rows.Scan(&rej.Row, &rej.Reason, &cells)
+		json.Unmarshal([]byte(cells), &rej.Cells)
+		rejected = append(rejected, rej)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rejected)
+}
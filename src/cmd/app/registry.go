@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// AVRO MESSAGES ///////////////////////
+///////////////////////////////////////////////////////////
+
+// With SCHEMA_REGISTRY_URL set, job messages are Avro records of
+// ingestionSchema in the Confluent wire format (a zero byte, the 4-byte
+// schema ID, the Avro body), registered under the subject
+// "<topic>-value" (and "<topic>-high-value" and "<topic>-low-value" with
+// PRIORITY_LANES), so consumers outside this service can read jobs with
+// any Confluent deserializer. Schema messages carry the table, columns
+// and types; chunk messages the rows. The preview and request this
+// service's consumer needs travel as JSON in the payload field.
+//
+// The consumer decodes each message with the schema its ID names, so
+// messages written under an earlier version of ingestionSchema, and
+// plain JSON messages, still read. While the registry cannot be reached
+// the consumer waits on the message rather than skipping it. SCHEMA_REGISTRY_USER and
+// SCHEMA_REGISTRY_PASSWORD (a Confluent Cloud API key and secret) are
+// sent as basic auth.
+
+var schemaRegistryURL = strings.TrimRight(os.Getenv("SCHEMA_REGISTRY_URL"), "/")
+
+const ingestionSchema = `{
+  "type": "record",
+  "name": "IngestionMessage",
+  "namespace": "fintech_pipeline",
+  "doc": "A job's schema message (chunks set) or one chunk of its rows (chunk set).",
+  "fields": [
+    {"name": "job_id", "type": "string"},
+    {"name": "table", "type": ["null", "string"], "default": null},
+    {"name": "mode", "type": ["null", "string"], "default": null},
+    {"name": "columns", "type": {"type": "array", "items": "string"}, "default": []},
+    {"name": "types", "type": {"type": "map", "values": "string"}, "default": {}},
+    {"name": "chunks", "type": ["null", "int"], "default": null, "doc": "number of chunk messages that follow"},
+    {"name": "chunk", "type": ["null", "int"], "default": null, "doc": "0-based index of this chunk"},
+    {"name": "rows", "type": {"type": "array", "items": {"type": "array", "items": "string"}}, "default": []},
+    {"name": "payload", "type": ["null", "string"], "default": null, "doc": "preview and request as JSON"}
+  ]
+}`
+
+var (
+	registryMu     sync.Mutex
+	registryID     int // ID of ingestionSchema once registered
+	registryCodecs = map[int]*goavro.Codec{}
+)
+
+// registryDown is a registry call that failed because the registry did
+// not answer, or answered with a server error. It says nothing about the
+// message it was for, which reads once the registry is back.
+type registryDown struct {
+	err error
+}
+
+func (e *registryDown) Error() string {
+	return e.err.Error()
+}
+
+func (e *registryDown) Unwrap() error {
+	return e.err
+}
+
+// registryCall sends one request to the schema registry and decodes its
+// JSON answer into out.
+func registryCall(method, path string, body, out interface{}) error {
+
+	var r io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, schemaRegistryURL+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	if user := os.Getenv("SCHEMA_REGISTRY_USER"); user != "" {
+		req.SetBasicAuth(user, os.Getenv("SCHEMA_REGISTRY_PASSWORD"))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return &registryDown{fmt.Errorf("schema registry: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("schema registry: %s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+		if resp.StatusCode >= 500 {
+			return &registryDown{err}
+		}
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// producerCodec registers ingestionSchema on first use and returns its ID
+// and codec.
+func producerCodec() (int, *goavro.Codec, error) {
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if registryID != 0 {
+		return registryID, registryCodecs[registryID], nil
+	}
+
+	codec, err := goavro.NewCodec(ingestionSchema)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var registered struct {
+		ID int `json:"id"`
+	}
+	// The same schema under each lane's topic gets the same ID.
+	body := map[string]string{"schema": codec.Schema()}
+	for _, lane := range lanes() {
+		if err := registryCall("POST", "/subjects/"+laneTopic(lane)+"-value/versions", body, &registered); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	registryID = registered.ID
+	registryCodecs[registryID] = codec
+
+	return registryID, codec, nil
+}
+
+// schemaCodec returns the codec of a registered schema by ID.
+func schemaCodec(id int) (*goavro.Codec, error) {
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if codec, ok := registryCodecs[id]; ok {
+		return codec, nil
+	}
+
+	var schema struct {
+		Schema string `json:"schema"`
+	}
+	if err := registryCall("GET", fmt.Sprintf("/schemas/ids/%d", id), nil, &schema); err != nil {
+		return nil, err
+	}
+
+	codec, err := goavro.NewCodec(schema.Schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema %d: %w", id, err)
+	}
+	registryCodecs[id] = codec
+
+	return codec, nil
+}
+
+// encodeMessage serializes a job's schema message or chunk message: as
+// Avro when SCHEMA_REGISTRY_URL is set, else as enveloped JSON
+// (envelope.go).
+func encodeMessage(msg map[string]interface{}) ([]byte, error) {
+
+	if schemaRegistryURL == "" {
+		return sealMessage(msg)
+	}
+
+	id, codec, err := producerCodec()
+	if err != nil {
+		return nil, err
+	}
+
+	record := map[string]interface{}{
+		"job_id":  msg["job_id"],
+		"table":   nil,
+		"mode":    nil,
+		"columns": []interface{}{},
+		"types":   map[string]interface{}{},
+		"chunks":  nil,
+		"chunk":   nil,
+		"rows":    []interface{}{},
+		"payload": nil,
+	}
+
+	if i, ok := msg["chunk"].(int); ok {
+		record["chunk"] = goavro.Union("int", i)
+		rows := msg["rows"].([][]string)
+		out := make([]interface{}, len(rows))
+		for r, row := range rows {
+			cells := make([]interface{}, len(row))
+			for c, v := range row {
+				cells[c] = v
+			}
+			out[r] = cells
+		}
+		record["rows"] = out
+	} else {
+		p := msg["preview"].(Preview)
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		columns := make([]interface{}, len(p.Columns))
+		types := map[string]interface{}{}
+		for i, c := range p.Columns {
+			columns[i] = c
+			types[c] = p.Types[c]
+		}
+		record["table"] = goavro.Union("string", msg["table"])
+		record["mode"] = goavro.Union("string", msg["mode"])
+		record["columns"] = columns
+		record["types"] = types
+		record["chunks"] = goavro.Union("int", msg["chunks"])
+		record["payload"] = goavro.Union("string", string(payload))
+	}
+
+	body, err := codec.BinaryFromNative(nil, record)
+	if err != nil {
+		return nil, fmt.Errorf("avro: %w", err)
+	}
+
+	b := make([]byte, 5, 5+len(body))
+	binary.BigEndian.PutUint32(b[1:], uint32(id))
+
+	return append(b, body...), nil
+}
+
+// decodeWaiting decodes a message, waiting out schema registry outages
+// instead of skipping it: the stream neither moves on nor is committed
+// past the message until the registry answers.
+func decodeWaiting(b []byte, where string) (map[string]interface{}, error) {
+
+	wait := time.Second
+
+	for {
+		payload, err := decodeMessage(b)
+
+		var down *registryDown
+		if !errors.As(err, &down) {
+			return payload, err
+		}
+
+		fmt.Printf("⏳ %s: %v; retrying in %s\n", where, err, wait)
+		time.Sleep(wait)
+		wait = min(2*wait, time.Minute)
+	}
+}
+
+// decodeMessage reads a message written by encodeMessage into the shape
+// assembleJob expects.
+func decodeMessage(b []byte) (map[string]interface{}, error) {
+
+	if len(b) < 5 || b[0] != 0 {
+		return openMessage(b)
+	}
+
+	codec, err := schemaCodec(int(binary.BigEndian.Uint32(b[1:5])))
+	if err != nil {
+		return nil, err
+	}
+
+	native, _, err := codec.NativeFromBinary(b[5:])
+	if err != nil {
+		return nil, fmt.Errorf("avro: %w", err)
+	}
+	record, ok := native.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("avro: message is not a record")
+	}
+
+	if chunk, ok := record["chunk"].(map[string]interface{}); ok {
+		return map[string]interface{}{
+			"job_id": record["job_id"],
+			"chunk":  float64(chunk["int"].(int32)),
+			"rows":   record["rows"],
+		}, nil
+	}
+
+	payload, ok := record["payload"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("avro: job %v has neither chunk nor payload", record["job_id"])
+	}
+
+	var msg map[string]interface{}
+	if err := json.Unmarshal([]byte(payload["string"].(string)), &msg); err != nil {
+		return nil, fmt.Errorf("avro: payload: %w", err)
+	}
+
+	return msg, nil
+}
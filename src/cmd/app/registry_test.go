@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+// avroMessage writes msg in the Confluent wire format under schema id.
+func avroMessage(t *testing.T, id int, msg map[string]interface{}) []byte {
+
+	t.Helper()
+
+	codec, err := goavro.NewCodec(ingestionSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := make([]byte, 5)
+	binary.BigEndian.PutUint32(b[1:], uint32(id))
+	b, err = codec.BinaryFromNative(b, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRegistryDown(t *testing.T) {
+
+	defer func() { schemaRegistryURL = "" }()
+
+	cases := []struct {
+		name   string
+		status int
+		down   bool
+	}{
+		{"server error", http.StatusServiceUnavailable, true},
+		{"unknown schema", http.StatusNotFound, false},
+	}
+
+	for _, c := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "no", c.status)
+		}))
+		schemaRegistryURL = srv.URL
+
+		var down *registryDown
+		if err := registryCall("GET", "/schemas/ids/1", nil, &struct{}{}); errors.As(err, &down) != c.down {
+			t.Errorf("%s: registryCall = %v, want registryDown: %v", c.name, err, c.down)
+		}
+		srv.Close()
+	}
+
+	schemaRegistryURL = "http://127.0.0.1:1"
+	var down *registryDown
+	if err := registryCall("GET", "/schemas/ids/1", nil, &struct{}{}); !errors.As(err, &down) {
+		t.Errorf("unreachable: registryCall = %v, want registryDown", err)
+	}
+}
+
+func TestDecodeWaitingRetriesOutage(t *testing.T) {
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"schema": ingestionSchema})
+	}))
+	defer srv.Close()
+	schemaRegistryURL = srv.URL
+	defer func() { schemaRegistryURL = "" }()
+
+	b := avroMessage(t, 7001, map[string]interface{}{
+		"job_id":  "j1",
+		"chunk":   goavro.Union("int", int32(0)),
+		"rows":    []interface{}{[]interface{}{"a"}},
+		"payload": nil,
+	})
+
+	payload, err := decodeWaiting(b, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload["job_id"] != "j1" || calls.Load() != 2 {
+		t.Errorf("decodeWaiting = %v after %d registry calls, want job j1 after 2", payload, calls.Load())
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// HEADLESS RENDER /////////////////////
+///////////////////////////////////////////////////////////
+
+// renderTimeout bounds navigation, script execution and the selector wait.
+const renderTimeout = 30 * time.Second
+
+// renderPage loads a page in headless Chrome so client-side scripts can
+// build the table, then returns the rendered DOM as HTML. Chrome is
+// started locally (CHROME_PATH overrides the binary) unless
+// CHROME_REMOTE_URL points at a running DevTools endpoint. Fetch options
+// are sent as extra request headers on every request the page makes.
+func renderPage(pageURL, waitSelector string, fetchOpts *FetchOptions) (*fetchedSource, error) {
+
+	var allocCtx context.Context
+	var cancelAlloc context.CancelFunc
+
+	if remote := os.Getenv("CHROME_REMOTE_URL"); remote != "" {
+		allocCtx, cancelAlloc = chromedp.NewRemoteAllocator(context.Background(), remote)
+	} else {
+		opts := append(chromedp.DefaultExecAllocatorOptions[:],
+			chromedp.NoSandbox,
+			chromedp.DisableGPU,
+		)
+		if path := os.Getenv("CHROME_PATH"); path != "" {
+			opts = append(opts, chromedp.ExecPath(path))
+		}
+
+		// Chrome cannot take proxy credentials on the command line, so
+		// only the proxy address is passed through.
+		proxy, err := fetchOpts.proxyURL()
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			opts = append(opts, chromedp.ProxyServer(proxy.Scheme+"://"+proxy.Host))
+		}
+
+		allocCtx, cancelAlloc = chromedp.NewExecAllocator(context.Background(), opts...)
+	}
+	defer cancelAlloc()
+
+	ctx, cancel := chromedp.NewContext(allocCtx)
+	defer cancel()
+
+	ctx, cancelTimeout := context.WithTimeout(ctx, renderTimeout)
+	defer cancelTimeout()
+
+	wait := chromedp.WaitReady("body", chromedp.ByQuery)
+	if waitSelector != "" {
+		wait = chromedp.WaitVisible(waitSelector, chromedp.ByQuery)
+	}
+
+	headers := network.Headers{}
+	for k, v := range fetchOpts.authHeaders() {
+		headers[k] = v
+	}
+
+	if fetchOpts != nil && len(fetchOpts.Cookies) > 0 {
+		var parts []string
+		for name, value := range fetchOpts.Cookies {
+			parts = append(parts, name+"="+value)
+		}
+		headers["Cookie"] = strings.Join(parts, "; ")
+	}
+
+	var html string
+
+	err := chromedp.Run(ctx,
+		network.Enable(),
+		network.SetExtraHTTPHeaders(headers),
+		chromedp.Navigate(pageURL),
+		wait,
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", redactURL(pageURL), err)
+	}
+
+	return &fetchedSource{
+		URL:         pageURL,
+		ContentType: "text/html; charset=utf-8",
+		Body:        []byte(html),
+	}, nil
+}
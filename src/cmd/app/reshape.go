@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// SPLIT + MERGE ///////////////////////
+///////////////////////////////////////////////////////////
+
+// ColumnSplit splits one column into several, e.g. "Austin, TX" into
+// city and state. Parts are trimmed; missing parts are empty.
+type ColumnSplit struct {
+	Column    string   `json:"column"`
+	Into      []string `json:"into"`
+	Delimiter string   `json:"delimiter"` // the last part keeps any further delimiters
+	Pattern   string   `json:"pattern"`   // regex whose groups fill into, instead of delimiter
+	Keep      bool     `json:"keep"`      // keep the source column too
+}
+
+// ColumnMerge joins several columns into one, skipping empty cells.
+type ColumnMerge struct {
+	Columns   []string `json:"columns"`
+	Into      string   `json:"into"`
+	Separator string   `json:"separator"`
+	Keep      bool     `json:"keep"` // keep the source columns too
+}
+
+// reshape maps a row of the source columns to the columns left after
+// splits and merges. Splits run first, so merges may use their parts.
+type reshape struct {
+	columns []string
+	cells   []func(row []string) string
+	added   map[string]bool // columns made by a split or merge
+}
+
+func compileReshape(req IngestRequest, cols []string) (*reshape, error) {
+
+	r := &reshape{added: map[string]bool{}}
+	for i, c := range cols {
+		r.columns = append(r.columns, c)
+		r.cells = append(r.cells, sourceCell(i))
+	}
+
+	for _, s := range req.Split {
+		if err := r.split(s, req.Rename); err != nil {
+			return nil, fmt.Errorf("split %s: %w", s.Column, err)
+		}
+	}
+
+	for _, m := range req.Merge {
+		if err := r.merge(m, req.Rename); err != nil {
+			return nil, fmt.Errorf("merge into %s: %w", m.Into, err)
+		}
+	}
+
+	return r, nil
+}
+
+func sourceCell(i int) func([]string) string {
+	return func(row []string) string {
+		if i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+}
+
+func (r *reshape) index(name string) int {
+
+	for i, c := range r.columns {
+		if c == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// unused reports whether a new column name is free.
+func (r *reshape) unused(names []string) error {
+
+	for _, n := range names {
+		if r.index(n) >= 0 {
+			return fmt.Errorf("column %s already exists", n)
+		}
+	}
+
+	return nil
+}
+
+func (r *reshape) split(s ColumnSplit, rename map[string]string) error {
+
+	i := r.index(renamedColumn(s.Column, rename))
+	if i < 0 {
+		return fmt.Errorf("unknown column (available: %s)", strings.Join(r.columns, ", "))
+	}
+	if len(s.Into) == 0 {
+		return fmt.Errorf("no into columns")
+	}
+
+	var parts func(string) []string
+
+	switch {
+	case s.Pattern != "":
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %w", s.Pattern, err)
+		}
+		if re.NumSubexp() < len(s.Into) {
+			return fmt.Errorf("pattern has %d groups for %d columns", re.NumSubexp(), len(s.Into))
+		}
+		parts = func(v string) []string {
+			m := re.FindStringSubmatch(v)
+			if m == nil {
+				return nil
+			}
+			return m[1:]
+		}
+	case s.Delimiter != "":
+		parts = func(v string) []string { return strings.SplitN(v, s.Delimiter, len(s.Into)) }
+	default:
+		return fmt.Errorf("delimiter or pattern required")
+	}
+
+	into := normalizeColumns(s.Into)
+	src := r.cells[i]
+
+	var names []string
+	var cells []func([]string) string
+
+	if s.Keep {
+		names, cells = append(names, r.columns[i]), append(cells, src)
+	} else {
+		r.columns[i] = "" // the source name may be reused
+	}
+	if err := r.unused(into); err != nil {
+		return err
+	}
+
+	for k, name := range into {
+		k := k
+		r.added[name] = true
+		names = append(names, name)
+		cells = append(cells, func(row []string) string {
+			if p := parts(src(row)); k < len(p) {
+				return strings.TrimSpace(p[k])
+			}
+			return ""
+		})
+	}
+
+	r.columns = append(r.columns[:i], append(names, r.columns[i+1:]...)...)
+	r.cells = append(r.cells[:i], append(cells, r.cells[i+1:]...)...)
+
+	return nil
+}
+
+func (r *reshape) merge(m ColumnMerge, rename map[string]string) error {
+
+	if len(m.Columns) < 2 {
+		return fmt.Errorf("at least two columns required")
+	}
+
+	var srcs []func([]string) string
+	var at []int
+
+	for _, c := range m.Columns {
+		i := r.index(renamedColumn(c, rename))
+		if i < 0 {
+			return fmt.Errorf("unknown column %q (available: %s)", c, strings.Join(r.columns, ", "))
+		}
+		srcs = append(srcs, r.cells[i])
+		at = append(at, i)
+	}
+
+	merged := func(row []string) string {
+		var vals []string
+		for _, src := range srcs {
+			if v := strings.TrimSpace(src(row)); v != "" {
+				vals = append(vals, v)
+			}
+		}
+		return strings.Join(vals, m.Separator)
+	}
+
+	first := at[0]
+	for _, i := range at {
+		first = min(first, i)
+	}
+
+	// The merged column takes the place of the first source, or follows it
+	// when the sources stay.
+	remove := map[int]bool{}
+	if !m.Keep {
+		for _, i := range at {
+			remove[i] = true
+		}
+	}
+
+	var columns []string
+	var cells []func([]string) string
+	into := normalizeColumns([]string{m.Into})[0]
+	r.added[into] = true
+
+	for i := range r.columns {
+		if i == first && !m.Keep {
+			columns, cells = append(columns, into), append(cells, merged)
+		}
+		if !remove[i] {
+			columns, cells = append(columns, r.columns[i]), append(cells, r.cells[i])
+		}
+		if i == first && m.Keep {
+			columns, cells = append(columns, into), append(cells, merged)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, c := range columns {
+		if seen[c] {
+			return fmt.Errorf("column %s already exists", c)
+		}
+		seen[c] = true
+	}
+
+	r.columns, r.cells = columns, cells
+
+	return nil
+}
+
+func (r *reshape) row(row []string) []string {
+
+	out := make([]string, len(r.cells))
+	for i, cell := range r.cells {
+		out[i] = cell(row)
+	}
+
+	return out
+}
+
+// reshapeColumns splits and merges the preview's columns. The new
+// columns are inferred from their values; untouched ones keep their type.
+func reshapeColumns(p Preview, req IngestRequest, o inferenceOptions) (Preview, error) {
+
+	if len(req.Split) == 0 && len(req.Merge) == 0 {
+		return p, nil
+	}
+
+	r, err := compileReshape(req, p.Columns)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	for i, row := range p.Rows {
+		p.Rows[i] = r.row(row)
+	}
+
+	types := inferTypesWith(r.columns, p.Rows, func(_ int, v string) string {
+		return cleanForInference(v)
+	}, o)
+
+	old := p.Types
+	p.Types = map[string]string{}
+	for _, c := range r.columns {
+		if r.added[c] {
+			p.Types[c] = types[c]
+		} else {
+			p.Types[c] = old[c]
+		}
+	}
+	p.Columns = r.columns
+
+	return p, nil
+}
+
+// reshapeStream splits and merges the columns of a streamed source.
+type reshapeStream struct {
+	rowStream
+	reshape *reshape
+}
+
+func (s *reshapeStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return s.reshape.row(row), nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// S3 SOURCE ///////////////////////////
+///////////////////////////////////////////////////////////
+
+// S3Options overrides the AWS environment (AWS_REGION, AWS_ACCESS_KEY_ID,
+// ...) for a single request. Endpoint targets S3-compatible stores such
+// as MinIO and defaults to S3_ENDPOINT.
+type S3Options struct {
+	Region          string `json:"region"`
+	Endpoint        string `json:"endpoint"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+}
+
+// redact returns a copy without the credentials, for publishing; the
+// consumer gets them back through streamSecrets.
+func (o *S3Options) redact() *S3Options {
+
+	if o == nil {
+		return nil
+	}
+
+	return &S3Options{Region: o.Region, Endpoint: o.Endpoint}
+}
+
+func isS3URL(u string) bool {
+	return strings.HasPrefix(u, "s3://")
+}
+
+func parseS3URL(u string) (bucket, key string, err error) {
+
+	rest := strings.TrimPrefix(u, "s3://")
+
+	i := strings.Index(rest, "/")
+	if i <= 0 || i == len(rest)-1 {
+		return "", "", fmt.Errorf("invalid S3 URL %q, expected s3://bucket/key", u)
+	}
+
+	return rest[:i], rest[i+1:], nil
+}
+
+func newS3Client(ctx context.Context, opts *S3Options) (*s3.Client, error) {
+
+	if opts == nil {
+		opts = &S3Options{}
+	}
+
+	var loaders []func(*config.LoadOptions) error
+
+	if opts.Region != "" {
+		loaders = append(loaders, config.WithRegion(opts.Region))
+	}
+
+	if opts.AccessKeyID != "" {
+		loaders = append(loaders, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(
+				opts.AccessKeyID, opts.SecretAccessKey, opts.SessionToken),
+		))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loaders...)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("S3_ENDPOINT")
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
+
+// openS3Rows starts reading an object and returns its header plus a
+// stream over the data rows. Objects ending in .gz (or stored with gzip
+// content encoding) are decompressed on the fly.
+func openS3Rows(ctx context.Context, req IngestRequest) ([]string, rowStream, error) {
+
+	bucket, key, err := parseS3URL(req.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := newS3Client(ctx, req.S3)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure S3 client: %w", err)
+	}
+
+	obj, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get s3://%s/%s: %w", bucket, key, err)
+	}
+
+	name := strings.ToLower(key)
+	gzipped := strings.HasSuffix(name, ".gz") || aws.ToString(obj.ContentEncoding) == "gzip"
+
+	return openRows(obj.Body, name, aws.ToString(obj.ContentType), gzipped, req)
+}
+
+// stackedCloser closes a decoder together with the stream beneath it.
+type stackedCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (s *stackedCloser) Close() error {
+
+	var first error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
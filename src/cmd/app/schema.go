@@ -0,0 +1,181 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// SCHEMA REGISTRY /////////////////////
+///////////////////////////////////////////////////////////
+
+// Each ingested table's schema is kept in ingestion_schemas: the column
+// types MySQL ended up with, and the cleaning rules and semantic types of
+// the last job. A later ingestion into the same table starts from those
+// types, so a run whose rows happen to look different (no decimals
+// today, all dates before the 13th) does not recreate the table with
+// other types. Data that no longer fits a registered type still gets the
+// inferred one.
+
+// TableSchema is the registered schema of one table.
+type TableSchema struct {
+	Table          string                    `json:"table"`
+	Columns        []string                  `json:"columns"`
+	Types          map[string]string         `json:"types"`
+	Cleaning       []CleaningRule            `json:"cleaning,omitempty"`
+	ColumnCleaning map[string][]CleaningRule `json:"column_cleaning,omitempty"`
+	Semantic       map[string]string         `json:"semantic,omitempty"` // column -> semantic type
+	UpdatedAt      string                    `json:"updated_at"`
+}
+
+// registerSchema records the table's current MySQL schema, the cleaning
+// rules of req and the semantic types of p after a job wrote to it.
+func registerSchema(table string, p Preview, req IngestRequest) {
+
+	cols, types, err := existingTableSchema(table)
+	if err != nil {
+		return
+	}
+
+	colsJSON, _ := json.Marshal(cols)
+	typesJSON, _ := json.Marshal(types)
+	cleaningJSON, _ := json.Marshal(map[string]interface{}{
+		"cleaning":        req.Cleaning,
+		"column_cleaning": req.ColumnCleaning,
+	})
+	semanticJSON, _ := json.Marshal(p.Semantic)
+
+	_, err = db.Exec(`
+	INSERT INTO ingestion_schemas (table_name, columns_json, types_json, cleaning_json, semantic_json)
+	VALUES (?, ?, ?, ?, ?)
+	ON DUPLICATE KEY UPDATE
+		columns_json=VALUES(columns_json),
+		types_json=VALUES(types_json),
+		cleaning_json=VALUES(cleaning_json),
+		semantic_json=VALUES(semantic_json)`,
+		table, string(colsJSON), string(typesJSON), string(cleaningJSON), string(semanticJSON))
+	if err != nil {
+		fmt.Printf("⚠️  Failed to register schema of %s: %v\n", table, err)
+	}
+}
+
+// registeredSchema returns the schema registered for table, or nil.
+func registeredSchema(table string) (*TableSchema, error) {
+
+	var cols, types, cleaning, semantic, updated string
+
+	err := db.QueryRow(`
+	SELECT columns_json, types_json, COALESCE(cleaning_json, ''), COALESCE(semantic_json, ''), updated_at
+	FROM ingestion_schemas
+	WHERE table_name=?`, table).Scan(&cols, &types, &cleaning, &semantic, &updated)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &TableSchema{Table: table, UpdatedAt: updated}
+	json.Unmarshal([]byte(cols), &s.Columns)
+	json.Unmarshal([]byte(types), &s.Types)
+	json.Unmarshal([]byte(semantic), &s.Semantic)
+
+	var rules struct {
+		Cleaning       []CleaningRule            `json:"cleaning"`
+		ColumnCleaning map[string][]CleaningRule `json:"column_cleaning"`
+	}
+	json.Unmarshal([]byte(cleaning), &rules)
+	s.Cleaning = rules.Cleaning
+	s.ColumnCleaning = rules.ColumnCleaning
+
+	return s, nil
+}
+
+// applyRegisteredSchema replaces inferred types with the registered ones
+// of req.Table where the data still fits them.
+func applyRegisteredSchema(p Preview, req IngestRequest) Preview {
+
+	if req.Table == "" || req.FreshSchema || db == nil {
+		return p
+	}
+
+	s, err := registeredSchema(req.Table)
+	if err != nil || s == nil {
+		return p
+	}
+
+	for _, c := range p.Columns {
+
+		reg, ok := s.Types[c]
+		if !ok {
+			continue
+		}
+
+		t := reconcileType(reg, p.Types[c])
+		if t != reg {
+			fmt.Printf("⚠️  %s.%s no longer fits registered %s, using %s\n", req.Table, c, reg, t)
+		}
+
+		p.Types[c] = t
+		p.Registered = append(p.Registered, c)
+	}
+
+	return p
+}
+
+// reconcileType picks the type for a column registered as reg whose new
+// data was inferred as inferred: reg when the data fits it, reg widened
+// when the data outgrew it, inferred when the data no longer fits.
+func reconcileType(reg, inferred string) string {
+
+	if strings.EqualFold(reg, inferred) {
+		return reg
+	}
+
+	if t, ok := widenedType(reg, inferred); ok {
+		return t
+	}
+
+	if _, ok := enumValues(reg); ok {
+		if _, ok := enumValues(inferred); !ok {
+			return inferred
+		}
+		return reg
+	}
+
+	have, got := typeFamily(reg), typeFamily(inferred)
+
+	switch {
+	case have == got:
+		return reg
+	case have == "TEXT":
+		// Anything can be stored as text, as it was before.
+		return reg
+	case have == "FLOAT" && got == "INT", have == "DATETIME" && got == "DATE":
+		return reg
+	default:
+		return inferred
+	}
+}
+
+// schemaHandler returns the registered schema of ?table=.
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+
+	table := r.URL.Query().Get("table")
+
+	s, err := registeredSchema(table)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	if s == nil {
+		http.Error(w, fmt.Sprintf("no schema registered for %q", table), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// SCIENTIFIC NOTATION /////////////////
+///////////////////////////////////////////////////////////
+
+// Exports from Excel and scientific tools write numbers as 1.2E+09.
+// Inference reads such values by their expanded digits, so 1.2e9 counts
+// as a number, but a column with values in exponent notation becomes
+// DOUBLE even when every value is whole. Integer and DECIMAL columns,
+// set through types or the registered schema, get their values expanded
+// before insertion, since MySQL only parses exponents for floating-point
+// columns.
+
+// sciNumber splits a number in exponent notation into sign, integer
+// digits, fraction digits and exponent.
+var sciNumber = regexp.MustCompile(`^([-+]?)(\d*)(?:\.(\d*))?[eE]([-+]?\d{1,3})$`)
+
+// maxExpandedDigits is the most digits a DECIMAL column holds.
+const maxExpandedDigits = 65
+
+func isScientific(v string) bool {
+
+	m := sciNumber.FindStringSubmatch(v)
+	return m != nil && m[2]+m[3] != ""
+}
+
+// expandScientific writes a number in exponent notation as plain digits
+// without rounding: "1.2e9" is "1200000000", "-1.5E-3" is "-0.0015".
+// Values needing more than maxExpandedDigits digits are left alone.
+func expandScientific(v string) (string, bool) {
+
+	m := sciNumber.FindStringSubmatch(v)
+	if m == nil || m[2]+m[3] == "" {
+		return "", false
+	}
+
+	exp, err := strconv.Atoi(m[4])
+	if err != nil || exp > maxExpandedDigits || exp < -maxExpandedDigits {
+		return "", false
+	}
+
+	digits := m[2] + m[3]
+	point := len(m[2]) + exp
+
+	switch {
+	case point <= 0:
+		digits = strings.Repeat("0", 1-point) + digits
+		point = 1
+	case point > len(digits):
+		digits += strings.Repeat("0", point-len(digits))
+	}
+
+	whole := strings.TrimLeft(digits[:point], "0")
+	if whole == "" {
+		whole = "0"
+	}
+	frac := strings.TrimRight(digits[point:], "0")
+
+	if len(whole)+len(frac) > maxExpandedDigits {
+		return "", false
+	}
+
+	out := whole
+	if frac != "" {
+		out += "." + frac
+	}
+	if m[1] == "-" && strings.Trim(out, "0.") != "" {
+		out = "-" + out
+	}
+
+	return out, true
+}
+
+// expandScientificColumns rewrites exponent values of the preview's
+// integer and DECIMAL columns as plain digits.
+func expandScientificColumns(p Preview) Preview {
+
+	cols := exactNumericColumns(p)
+	if len(cols) == 0 {
+		return p
+	}
+
+	for _, r := range p.Rows {
+		expandScientificCells(r, cols)
+	}
+
+	return p
+}
+
+// exactNumericColumns lists the integer and DECIMAL columns of p.
+func exactNumericColumns(p Preview) []int {
+
+	var cols []int
+
+	for i, c := range p.Columns {
+		t := strings.ToUpper(p.Types[c])
+		if intRanks[intBase(t)] > 0 || strings.HasPrefix(t, "DECIMAL") {
+			cols = append(cols, i)
+		}
+	}
+
+	return cols
+}
+
+func expandScientificCells(row []string, cols []int) {
+
+	for _, c := range cols {
+		if c >= len(row) {
+			continue
+		}
+		if v, ok := expandScientific(strings.TrimSpace(row[c])); ok {
+			row[c] = v
+		}
+	}
+}
+
+// scientificStream expands exponent values in the integer and DECIMAL
+// columns of rows the consumer streams straight from the source.
+type scientificStream struct {
+	rowStream
+	cols []int
+}
+
+func (s *scientificStream) Next() ([]string, error) {
+
+	row, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	expandScientificCells(row, s.cols)
+
+	return row, nil
+}
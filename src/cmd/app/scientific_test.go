@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestExpandScientific(t *testing.T) {
+
+	cases := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"1.2e9", "1200000000", true},
+		{"1.2E+09", "1200000000", true},
+		{"-1.5E-3", "-0.0015", true},
+		{"5E-4", "0.0005", true},
+		{"-0e5", "0", true},
+		{"1e66", "", false},
+		{"e5", "", false},
+		{"12", "", false},
+	}
+
+	for _, c := range cases {
+		got, ok := expandScientific(c.in)
+		if got != c.want || ok != c.ok {
+			t.Errorf("expandScientific(%q) = %q, %v; want %q, %v", c.in, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestInferScientificColumns(t *testing.T) {
+
+	cases := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{"whole exponents", []string{"1.2e3", "4E2", "7e0"}, "DOUBLE"},
+		{"fractional exponents", []string{"5E-4", "1.5e-3"}, "DOUBLE"},
+		{"mixed with plain", []string{"12", "40", "1.2e3"}, "DOUBLE"},
+		{"plain integers", []string{"12", "40", "1200"}, "INT"},
+	}
+
+	for _, c := range cases {
+		rows := make([][]string, len(c.values))
+		for i, v := range c.values {
+			rows[i] = []string{v}
+		}
+		if got := inferTypes([]string{"x"}, rows)["x"]; got != c.want {
+			t.Errorf("%s: inferTypes = %s, want %s", c.name, got, c.want)
+		}
+	}
+}
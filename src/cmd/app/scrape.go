@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// SELECTOR SCRAPING ///////////////////
+///////////////////////////////////////////////////////////
+
+// parseScrape builds rows from repeating elements instead of a <table>:
+// every element matching rowSelector (e.g. "div.card") is a row, and each
+// field's CSS selector is evaluated inside it. An empty path means the
+// row element itself; attr reads an attribute (e.g. "href") instead of
+// the text. scope, when set, restricts the search to one container.
+func parseScrape(body []byte, scope, rowSelector string, fields []FieldSelector) (Preview, error) {
+
+	if len(fields) == 0 {
+		return Preview{}, fmt.Errorf("fields are required when scraping with row_selector")
+	}
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to parse HTML: %w", err)
+	}
+
+	doc, err = scopeDocument(doc, scope)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	matches := doc.Find(rowSelector)
+	if matches.Length() == 0 {
+		return Preview{}, fmt.Errorf("no elements match row selector %q", rowSelector)
+	}
+
+	cols := make([]string, len(fields))
+	for i, f := range fields {
+		cols[i] = f.Name
+	}
+
+	var rows [][]string
+
+	matches.Each(func(_ int, el *goquery.Selection) {
+
+		row := make([]string, len(fields))
+		empty := true
+
+		for i, f := range fields {
+
+			target := el
+			if f.Path != "" {
+				target = el.Find(f.Path).First()
+			}
+
+			if f.Attr != "" {
+				row[i] = strings.TrimSpace(target.AttrOr(f.Attr, ""))
+			} else {
+				row[i] = strings.Join(strings.Fields(target.Text()), " ")
+			}
+
+			if row[i] != "" {
+				empty = false
+			}
+		}
+
+		if !empty {
+			rows = append(rows, row)
+		}
+	})
+
+	return buildPreview(cols, rows)
+}
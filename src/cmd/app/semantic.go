@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// SEMANTIC TYPES //////////////////////
+///////////////////////////////////////////////////////////
+
+// Besides its SQL type a column can carry a semantic type: what its
+// values are (ISIN, IBAN, email, ticker), whatever column type stores
+// them. Detectors tag columns in the preview under "semantic", and the
+// tags are kept with the table's registered schema. Services embedding
+// the pipeline add detectors with registerDetector; a request can add
+// regex ones under "detectors".
+
+// semanticShare is how many of a column's filled values must match.
+const semanticShare = 0.9
+
+// semanticMinDistinct keeps two-value columns (BUY/SELL) from looking
+// like tickers.
+const semanticMinDistinct = 3
+
+type semanticDetector struct {
+	Name  string
+	Match func(string) bool
+}
+
+// DetectorRule is a request-supplied detector: columns whose values
+// match Pattern are tagged Name.
+type DetectorRule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+var (
+	emailAddress = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[A-Za-z]{2,}$`)
+	tickerSymbol = regexp.MustCompile(`^[A-Z]{1,5}([.-][A-Z]{1,2})?$`)
+	isinCode     = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{9}[0-9]$`)
+	ibanCode     = regexp.MustCompile(`^[A-Z]{2}[0-9]{2}[A-Z0-9]{11,30}$`)
+)
+
+// semanticDetectors is checked in order; the first detector matching a
+// column names it. Specific formats come before loose ones.
+var semanticDetectors = []semanticDetector{
+	{"isin", isISIN},
+	{"iban", isIBAN},
+	{"email", emailAddress.MatchString},
+	{"currency_code", func(v string) bool { return currencyCodes[v] }},
+	{"ticker", tickerSymbol.MatchString},
+}
+
+// registerDetector adds a detector ahead of the built-in ones.
+func registerDetector(name string, match func(string) bool) {
+	semanticDetectors = append([]semanticDetector{{name, match}}, semanticDetectors...)
+}
+
+// requestDetectors compiles a request's detectors, which run before the
+// registered ones.
+func requestDetectors(req IngestRequest) ([]semanticDetector, error) {
+
+	var out []semanticDetector
+
+	for _, d := range req.Detectors {
+		if strings.TrimSpace(d.Name) == "" {
+			return nil, fmt.Errorf("detector for %q needs a name", d.Pattern)
+		}
+		re, err := regexp.Compile(d.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("detector %s: %w", d.Name, err)
+		}
+		out = append(out, semanticDetector{d.Name, re.MatchString})
+	}
+
+	return append(out, semanticDetectors...), nil
+}
+
+// detectSemantics tags the columns of p with the first detector that
+// matches semanticShare of their filled values.
+func detectSemantics(p Preview, detectors []semanticDetector) Preview {
+
+	for c, name := range p.Columns {
+
+		var values []string
+		distinct := map[string]bool{}
+
+		for _, r := range p.Rows {
+			if c < len(r) && strings.TrimSpace(r[c]) != "" {
+				v := strings.TrimSpace(r[c])
+				values = append(values, v)
+				distinct[v] = true
+			}
+		}
+
+		if len(distinct) < semanticMinDistinct {
+			continue
+		}
+
+		for _, d := range detectors {
+
+			matched := 0
+			for _, v := range values {
+				if d.Match(v) {
+					matched++
+				}
+			}
+
+			if float64(matched) >= float64(len(values))*semanticShare {
+				if p.Semantic == nil {
+					p.Semantic = map[string]string{}
+				}
+				p.Semantic[name] = d.Name
+				break
+			}
+		}
+	}
+
+	return p
+}
+
+// isISIN checks the format and the Luhn check digit of an ISIN
+// (US0378331005), with letters counted as 10-35.
+func isISIN(v string) bool {
+
+	if !isinCode.MatchString(v) {
+		return false
+	}
+
+	var digits strings.Builder
+	for _, r := range v {
+		if r >= 'A' && r <= 'Z' {
+			fmt.Fprintf(&digits, "%d", r-'A'+10)
+		} else {
+			digits.WriteRune(r)
+		}
+	}
+
+	s := digits.String()
+	sum := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		n := int(s[i] - '0')
+		if (len(s)-1-i)%2 == 1 {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+
+	return sum%10 == 0
+}
+
+// isIBAN checks the format and the mod-97 checksum of an IBAN; spaces
+// between groups are allowed.
+func isIBAN(v string) bool {
+
+	v = strings.ReplaceAll(v, " ", "")
+	if !ibanCode.MatchString(v) {
+		return false
+	}
+
+	var digits strings.Builder
+	for _, r := range v[4:] + v[:4] {
+		if r >= 'A' && r <= 'Z' {
+			fmt.Fprintf(&digits, "%d", r-'A'+10)
+		} else {
+			digits.WriteRune(r)
+		}
+	}
+
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// SFTP SOURCE /////////////////////////
+///////////////////////////////////////////////////////////
+
+// SFTP sources are addressed as sftp://[user[:pass]@]host[:port]/dir/glob,
+// e.g. sftp://partner.example.com/outbox/trades_*.csv. Credentials not in
+// the URL come from SFTP_USER, SFTP_PASSWORD and SFTP_KEY_FILE; host keys
+// are verified against SFTP_KNOWN_HOSTS when it is set.
+
+func isSFTPURL(u string) bool {
+	return strings.HasPrefix(u, "sftp://")
+}
+
+func dialSFTP(u *url.URL) (*sftp.Client, *ssh.Client, error) {
+
+	user := os.Getenv("SFTP_USER")
+	password := os.Getenv("SFTP_PASSWORD")
+
+	if u.User != nil {
+		user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			password = p
+		}
+	}
+
+	var auth []ssh.AuthMethod
+
+	if keyFile := os.Getenv("SFTP_KEY_FILE"); keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read SFTP key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid SFTP key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	hostKey := ssh.InsecureIgnoreHostKey()
+	if known := os.Getenv("SFTP_KNOWN_HOSTS"); known != "" {
+		cb, err := knownhosts.New(known)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load known hosts: %w", err)
+		}
+		hostKey = cb
+	} else {
+		fmt.Println("⚠️  SFTP_KNOWN_HOSTS not set, skipping host key verification")
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to %s: %w", host, err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+
+	return client, conn, nil
+}
+
+// fetchSFTPFiles downloads every regular file matching the glob in the
+// URL path, in name order.
+func fetchSFTPFiles(raw string) ([]*fetchedSource, error) {
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SFTP URL: %w", err)
+	}
+
+	client, conn, err := dialSFTP(u)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer client.Close()
+
+	matches, err := client.Glob(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", u.Path, err)
+	}
+
+	sort.Strings(matches)
+
+	var files []*fetchedSource
+
+	for _, m := range matches {
+
+		info, err := client.Stat(m)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+
+		f, err := client.Open(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", m, err)
+		}
+
+		body, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", m, err)
+		}
+
+		files = append(files, &fetchedSource{URL: m, Body: body})
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", u.Path)
+	}
+
+	return files, nil
+}
+
+// previewSFTP previews the first file matching the pattern.
+func previewSFTP(req IngestRequest) (Preview, error) {
+
+	files, err := fetchSFTPFiles(req.URL)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	return parseDocument(req, files[0])
+}
+
+var fileTableChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// tableForFile fills the "{file}" token of a table name template with
+// the file's base name, reduced to characters safe in a table name.
+func tableForFile(template, file string) string {
+
+	base := strings.ToLower(path.Base(file))
+	base = strings.TrimSuffix(base, path.Ext(base))
+	base = strings.Trim(fileTableChars.ReplaceAllString(base, "_"), "_")
+
+	return strings.ReplaceAll(template, "{file}", base)
+}
+
+// sftpIngestHandler starts one job per matching file, each previewed as
+// a fetched source would be. A "{file}" token in the table name is
+// replaced by the file's base name; otherwise all files go to the same
+// table and every file after the first is appended.
+func sftpIngestHandler(w http.ResponseWriter, r *http.Request) {
+
+	if rejectWhenBusy(w) {
+		return
+	}
+
+	var req IngestRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	if !isSFTPURL(req.URL) {
+		http.Error(w, "url must be an sftp:// pattern", http.StatusBadRequest)
+		return
+	}
+
+	if req.Stream {
+		http.Error(w, "stream is not available for sftp sources; each file is read whole", http.StatusBadRequest)
+		return
+	}
+
+	if err := validPriority(req.Priority); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := validSink(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files, err := fetchSFTPFiles(req.URL)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	type fileJob struct {
+		File  string `json:"file"`
+		JobID string `json:"job_id,omitempty"`
+		Error string `json:"error,omitempty"`
+	}
+
+	var jobs []fileJob
+	started := 0
+
+	for _, f := range files {
+
+		fileReq := req
+
+		if strings.Contains(req.Table, "{file}") {
+			fileReq.Table = tableForFile(req.Table, f.URL)
+		} else if started > 0 && fileReq.Mode == "create" {
+			fileReq.Mode = "append"
+		}
+
+		p, err := loadPreviewWith(fileReq, func(req IngestRequest) (Preview, error) {
+			return parseDocument(req, f)
+		})
+		if err != nil {
+			jobs = append(jobs, fileJob{File: f.URL, Error: err.Error()})
+			continue
+		}
+
+		jobs = append(jobs, fileJob{File: f.URL, JobID: startJob(p, fileReq)})
+		started++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// SQLITE TARGET ///////////////////////
+///////////////////////////////////////////////////////////
+
+// With DB_DRIVER=sqlite the service keeps its tables, meta tables
+// included, in the SQLite file DB_PATH (default ingestion.db) instead of
+// MySQL, so together with MESSAGE_BUS=direct it runs as a single binary
+// with nothing else to start: for demos, laptops and CI.
+//
+// The SQL elsewhere is written for MySQL; sqliteSQL rewrites the few
+// MySQL-only forms it uses as each statement is prepared, and the
+// catalog lookups (widen.go, webhook.go) ask SQLite's pragma_table_info
+// instead of information_schema. SQLite columns take any value whatever
+// their declared type, so appends never need a column widened; a NOT
+// NULL column that receives empty cells cannot be relaxed, though, and
+// those rows fail. The file allows one writer at a time: others wait up
+// to busy_timeout. A job transaction would hold the lock against the
+// job's own progress and log writes until it ends, so transaction=job is
+// refused.
+
+const (
+	dbMySQL  = "mysql"
+	dbSQLite = "sqlite"
+)
+
+var dbDriver = strings.ToLower(os.Getenv("DB_DRIVER"))
+
+func init() {
+
+	if dbDriver == "" {
+		dbDriver = dbMySQL
+	}
+
+	sql.Register("sqlite_mysql", sqliteDriver{&sqlite.Driver{}})
+}
+
+// sqliteDSN opens DB_PATH in WAL mode, so readers do not block the
+// writer, with transactions taking the write lock as they begin.
+func sqliteDSN() string {
+
+	path := os.Getenv("DB_PATH")
+	if path == "" {
+		path = "ingestion.db"
+	}
+
+	q := url.Values{}
+	q.Add("_pragma", "busy_timeout(10000)")
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Set("_txlock", "immediate")
+	q.Set("_time_format", "sqlite")
+
+	return "file:" + path + "?" + q.Encode()
+}
+
+// sqliteDriver is the SQLite driver with MySQL statements rewritten.
+type sqliteDriver struct {
+	*sqlite.Driver
+}
+
+func (d sqliteDriver) Open(name string) (driver.Conn, error) {
+
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqliteConn{c}, nil
+}
+
+type sqliteConn struct {
+	driver.Conn
+}
+
+func (c sqliteConn) Prepare(query string) (driver.Stmt, error) {
+	return c.Conn.Prepare(sqliteSQL(query))
+}
+
+var (
+	sqliteAutoIncrement = regexp.MustCompile(`(?i)\bINT AUTO_INCREMENT PRIMARY KEY\b`)
+	sqliteDefaultNow    = regexp.MustCompile(`(?i)\bTIMESTAMP DEFAULT CURRENT_TIMESTAMP\b`)
+	sqliteOnUpdate      = regexp.MustCompile(`(?i)\s+ON UPDATE CURRENT_TIMESTAMP\b`)
+	sqliteInlineIndex   = regexp.MustCompile(`(?i),\s*INDEX\s*\(\w+\)`)
+	sqliteEnum          = regexp.MustCompile(`(?i)\bENUM\('(?:[^']|'')*'(?:,'(?:[^']|'')*')*\)`)
+	sqliteUniqueKey     = regexp.MustCompile(`(?i)^ALTER TABLE (\S+) ADD UNIQUE KEY (\w+) \(([\w,]+)\)$`)
+	sqliteInsertIgnore  = regexp.MustCompile(`(?i)\bINSERT IGNORE\b`)
+	sqliteOnDuplicate   = regexp.MustCompile(`(?i)\bON DUPLICATE KEY UPDATE\b`)
+	sqliteValuesOf      = regexp.MustCompile(`(?i)\bVALUES\((\w+)\)`)
+	sqliteGreatest      = regexp.MustCompile(`(?i)\bGREATEST\(`)
+	sqliteDateFormat    = regexp.MustCompile(`(?i)\bDATE_FORMAT\((\w+), '([^']*)'\)`)
+)
+
+// sqliteSQL rewrites the MySQL-only forms this service writes into their
+// SQLite equivalents; everything else passes as it is.
+func sqliteSQL(query string) string {
+
+	if strings.TrimSpace(query) == "SHOW TABLES" {
+		return `SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+	}
+
+	if m := sqliteUniqueKey.FindStringSubmatch(strings.TrimSpace(query)); m != nil {
+		return fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s_%s ON %s (%s)", m[1], m[2], m[1], m[3])
+	}
+
+	// CREATE TABLE. The meta tables' timestamps are TEXT so they read
+	// back as MySQL returns them, updated_at keeps the time the row was
+	// first written, and the inline indexes are created by
+	// ensureMetaTables.
+	query = sqliteAutoIncrement.ReplaceAllString(query, "INTEGER PRIMARY KEY AUTOINCREMENT")
+	query = sqliteDefaultNow.ReplaceAllString(query, "TEXT DEFAULT CURRENT_TIMESTAMP")
+	query = sqliteOnUpdate.ReplaceAllString(query, "")
+	query = sqliteInlineIndex.ReplaceAllString(query, "")
+	query = sqliteEnum.ReplaceAllString(query, "TEXT")
+
+	// INSERT. SQLite's INSERT OR IGNORE would also skip rows breaking a
+	// NOT NULL; only key conflicts are ignored here.
+	if sqliteInsertIgnore.MatchString(query) {
+		query = sqliteInsertIgnore.ReplaceAllString(query, "INSERT") + " ON CONFLICT DO NOTHING"
+	}
+	if loc := sqliteOnDuplicate.FindStringIndex(query); loc != nil {
+		query = query[:loc[0]] + "ON CONFLICT DO UPDATE SET" +
+			sqliteValuesOf.ReplaceAllString(query[loc[1]:], "excluded.$1")
+	}
+
+	// Functions.
+	query = sqliteGreatest.ReplaceAllString(query, "MAX(")
+	query = sqliteDateFormat.ReplaceAllStringFunc(query, func(s string) string {
+		m := sqliteDateFormat.FindStringSubmatch(s)
+		format := strings.NewReplacer("%i", "%M", "%s", "%S").Replace(m[2])
+		return fmt.Sprintf("strftime('%s', %s)", format, m[1])
+	})
+
+	return query
+}
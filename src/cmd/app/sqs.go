@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// SQS BUS /////////////////////////////
+///////////////////////////////////////////////////////////
+
+// With MESSAGE_BUS=sqs jobs go through the SQS queue SQS_QUEUE_URL, so
+// nothing but AWS needs running. With SNS_TOPIC_ARN set they are published
+// to that SNS topic instead, which fans them out to its subscribed queues;
+// this service consumes SQS_QUEUE_URL, one of them. Credentials and region
+// come from the AWS environment (AWS_REGION, an instance or task role,
+// ...); AWS_ENDPOINT_URL targets LocalStack.
+//
+// SQS does not keep a job's messages in order (nor a FIFO queue the way
+// the consumer holds them), so one worker reads the queue and assembles
+// chunks in whatever order they come. Messages stay in the queue, kept
+// invisible while held, until their job is written; a crashed consumer's
+// jobs reappear once sqsVisibility runs out. Bodies are base64, so Avro
+// passes; chunks default to 128 KB to stay under SQS's 256 KB message
+// limit. FIFO queues and topics (.fifo) get exactly-once publishing.
+//
+// With PRIORITY_LANES the high and low lanes need queues of their own,
+// SQS_QUEUE_URL_HIGH and SQS_QUEUE_URL_LOW, and with SNS topics of their
+// own, SNS_TOPIC_ARN_HIGH and SNS_TOPIC_ARN_LOW. Each queue is a stream.
+
+var (
+	sqsQueueURL = os.Getenv("SQS_QUEUE_URL")
+	snsTopicARN = os.Getenv("SNS_TOPIC_ARN")
+)
+
+const sqsVisibility = 15 * time.Minute
+
+type sqsBus struct {
+	sqs    *sqs.Client
+	sns    *sns.Client            // nil publishes to the queue directly
+	queues map[jobPriority]string // queue URL by lane
+	topics map[jobPriority]string // topic ARN by lane, with sns
+}
+
+// sqsLaneEnv reads a lane's setting: env for the normal lane, env_HIGH
+// or env_LOW for the others.
+func sqsLaneEnv(env string, lane jobPriority) string {
+
+	if lane == priorityNormal {
+		return os.Getenv(env)
+	}
+
+	return os.Getenv(env + "_" + strings.ToUpper(lane.String()))
+}
+
+func newSQSBus() (messageBus, error) {
+
+	if sqsQueueURL == "" {
+		return nil, fmt.Errorf("MESSAGE_BUS=sqs needs SQS_QUEUE_URL")
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("sqs: %w", err)
+	}
+
+	b := &sqsBus{sqs: sqs.NewFromConfig(cfg), queues: map[jobPriority]string{}, topics: map[jobPriority]string{}}
+	if snsTopicARN != "" {
+		b.sns = sns.NewFromConfig(cfg)
+	}
+
+	for _, lane := range lanes() {
+		if b.queues[lane] = sqsLaneEnv("SQS_QUEUE_URL", lane); b.queues[lane] == "" {
+			return nil, fmt.Errorf("PRIORITY_LANES with MESSAGE_BUS=sqs needs SQS_QUEUE_URL_%s", strings.ToUpper(lane.String()))
+		}
+		if b.sns == nil {
+			continue
+		}
+		if b.topics[lane] = sqsLaneEnv("SNS_TOPIC_ARN", lane); b.topics[lane] == "" {
+			return nil, fmt.Errorf("PRIORITY_LANES with SNS_TOPIC_ARN needs SNS_TOPIC_ARN_%s", strings.ToUpper(lane.String()))
+		}
+	}
+
+	return b, nil
+}
+
+// Publish sends the messages in the background, as the Kafka producer
+// does. A failed send fails the job and its remaining messages are
+// dropped.
+func (b *sqsBus) Publish(jobID string, lane jobPriority, key string, values [][]byte) error {
+
+	go func() {
+		for i, v := range values {
+			if err := b.send(lane, jobID, i, v); err != nil {
+				failDelivery(delivery{jobID: jobID, message: i, messages: len(values)}, err)
+				return
+			}
+		}
+		fmt.Printf("📤 Published job %s (%d messages)\n", jobID, len(values))
+	}()
+
+	return nil
+}
+
+func (b *sqsBus) send(lane jobPriority, jobID string, i int, v []byte) error {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	body := aws.String(base64.StdEncoding.EncodeToString(v))
+
+	// Each message its own group: ordering comes from assembleJob.
+	queue, topic := b.queues[lane], b.topics[lane]
+
+	var group, dedup *string
+	if strings.HasSuffix(topic, ".fifo") || (b.sns == nil && strings.HasSuffix(queue, ".fifo")) {
+		id := aws.String(jobID + "-" + strconv.Itoa(i))
+		group, dedup = id, id
+	}
+
+	if b.sns != nil {
+		_, err := b.sns.Publish(ctx, &sns.PublishInput{
+			TopicArn:               aws.String(topic),
+			Message:                body,
+			MessageGroupId:         group,
+			MessageDeduplicationId: dedup,
+		})
+		return err
+	}
+
+	_, err := b.sqs.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(queue),
+		MessageBody:            body,
+		MessageGroupId:         group,
+		MessageDeduplicationId: dedup,
+	})
+
+	return err
+}
+
+func (b *sqsBus) Streams() ([]messageStream, error) {
+
+	var streams []messageStream
+	for _, lane := range lanes() {
+		streams = append(streams, &sqsStream{client: b.sqs, url: b.queues[lane], held: map[string]*sqsHeld{}})
+	}
+
+	return streams, nil
+}
+
+// sqsStream numbers messages as they are received; those are its offsets.
+type sqsStream struct {
+	client *sqs.Client
+	url    string
+
+	mu   sync.Mutex
+	held map[string]*sqsHeld // by message ID, received and not yet deleted
+	next int64
+}
+
+type sqsHeld struct {
+	offset  int64
+	receipt string
+}
+
+func (s *sqsStream) Name() string {
+	return s.url
+}
+
+func (s *sqsStream) Messages() <-chan busMessage {
+
+	out := make(chan busMessage)
+	go s.keepInvisible()
+	go func() {
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			resp, err := s.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+				QueueUrl:            aws.String(s.url),
+				MaxNumberOfMessages: 10,
+				WaitTimeSeconds:     20,
+				VisibilityTimeout:   int32(sqsVisibility / time.Second),
+			})
+			cancel()
+			if err != nil {
+				fmt.Printf("⚠️  SQS receive failed: %v\n", err)
+				time.Sleep(5 * time.Second)
+				continue
+			}
+
+			for _, m := range resp.Messages {
+				id, receipt := aws.ToString(m.MessageId), aws.ToString(m.ReceiptHandle)
+
+				s.mu.Lock()
+				h, redelivered := s.held[id]
+				if redelivered {
+					// Only the latest receipt handle deletes it.
+					h.receipt = receipt
+				} else {
+					h = &sqsHeld{offset: s.next, receipt: receipt}
+					s.held[id] = h
+					s.next++
+				}
+				s.mu.Unlock()
+
+				if !redelivered {
+					out <- busMessage{value: sqsBody(aws.ToString(m.Body)), offset: h.offset}
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+// sqsBody undoes the SNS envelope, if the subscription does not use raw
+// delivery, and the base64.
+func sqsBody(body string) []byte {
+
+	if strings.HasPrefix(body, "{") {
+		var envelope struct {
+			Type    string
+			Message string
+		}
+		if json.Unmarshal([]byte(body), &envelope) == nil && envelope.Type == "Notification" {
+			body = envelope.Message
+		}
+	}
+
+	if b, err := base64.StdEncoding.DecodeString(body); err == nil {
+		return b
+	}
+
+	// Plain JSON from another producer.
+	return []byte(body)
+}
+
+// keepInvisible extends the visibility of held messages before it runs
+// out, so they are not delivered again while their job is assembled.
+func (s *sqsStream) keepInvisible() {
+
+	for range time.Tick(sqsVisibility / 3) {
+
+		s.mu.Lock()
+		var entries []sqstypes.ChangeMessageVisibilityBatchRequestEntry
+		for _, h := range s.held {
+			entries = append(entries, sqstypes.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.FormatInt(h.offset, 10)),
+				ReceiptHandle:     aws.String(h.receipt),
+				VisibilityTimeout: int32(sqsVisibility / time.Second),
+			})
+		}
+		s.mu.Unlock()
+
+		for len(entries) > 0 {
+			n := min(len(entries), 10)
+			_, err := s.client.ChangeMessageVisibilityBatch(context.Background(), &sqs.ChangeMessageVisibilityBatchInput{
+				QueueUrl: aws.String(s.url),
+				Entries:  entries[:n],
+			})
+			if err != nil {
+				fmt.Printf("⚠️  SQS visibility extension failed: %v\n", err)
+			}
+			entries = entries[n:]
+		}
+	}
+}
+
+// Commit deletes every held message before next.
+func (s *sqsStream) Commit(next int64) {
+
+	s.mu.Lock()
+	var entries []sqstypes.DeleteMessageBatchRequestEntry
+	for id, h := range s.held {
+		if h.offset < next {
+			entries = append(entries, sqstypes.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.FormatInt(h.offset, 10)),
+				ReceiptHandle: aws.String(h.receipt),
+			})
+			delete(s.held, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for len(entries) > 0 {
+		n := min(len(entries), 10)
+		_, err := s.client.DeleteMessageBatch(context.Background(), &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(s.url),
+			Entries:  entries[:n],
+		})
+		if err != nil {
+			fmt.Printf("⚠️  SQS delete failed: %v\n", err)
+		}
+		entries = entries[n:]
+	}
+}
+
+// Lag is SQS's approximate count of visible messages.
+func (s *sqsStream) Lag() (int64, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	attr := sqstypes.QueueAttributeNameApproximateNumberOfMessages
+	resp, err := s.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(s.url),
+		AttributeNames: []sqstypes.QueueAttributeName{attr},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(resp.Attributes[string(attr)], 10, 64)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// COLUMN STATISTICS ///////////////////
+///////////////////////////////////////////////////////////
+
+// ColumnStats explains a column's inferred type in the preview.
+type ColumnStats struct {
+	Votes      typeVotes `json:"votes"`      // non-null values fitting INT, FLOAT, DATE, DATETIME, JSON; TEXT counts the rest
+	Confidence float64   `json:"confidence"` // share of non-null values that fit the chosen type
+	Rule       string    `json:"rule"`       // the precedence rule that picked the type
+	NullRatio  float64   `json:"null_ratio"`
+	Distinct   int       `json:"distinct"`
+	Samples    []string  `json:"samples"` // first distinct non-null values
+	Warning    string    `json:"warning,omitempty"`
+}
+
+const (
+	statsSamples  = 5
+	lowConfidence = 0.9
+)
+
+// typeFamily maps a column type to the vote it is backed by.
+func typeFamily(t string) string {
+
+	base := strings.ToUpper(strings.SplitN(strings.TrimSpace(t), "(", 2)[0])
+	base = strings.Fields(base + " ")[0]
+
+	switch {
+	case intRanks[base] > 0:
+		return "INT"
+	case base == "DECIMAL" || base == "NUMERIC" || base == "FLOAT" || base == "DOUBLE":
+		return "FLOAT"
+	case base == "DATE":
+		return "DATE"
+	case base == "DATETIME" || base == "TIMESTAMP":
+		return "DATETIME"
+	case base == "JSON":
+		return "JSON"
+	default:
+		return "TEXT"
+	}
+}
+
+// columnStats computes the statistics of every column of a preview;
+// threshold is the one inference used.
+func columnStats(p Preview, threshold float64) map[string]ColumnStats {
+
+	stats := map[string]ColumnStats{}
+
+	for c, name := range p.Columns {
+
+		s := ColumnStats{Votes: typeVotes{}, Samples: []string{}}
+		seen := map[string]bool{}
+		nulls, filled := 0, 0
+		var shape digitShape
+
+		for _, r := range p.Rows {
+
+			if c >= len(r) || r[c] == "" {
+				nulls++
+				continue
+			}
+			filled++
+
+			if !seen[r[c]] {
+				seen[r[c]] = true
+				if len(s.Samples) < statsSamples {
+					s.Samples = append(s.Samples, r[c])
+				}
+			}
+
+			if val := cleanForInference(r[c]); val != "" {
+				shape.add(val)
+				s.Votes.add(classifyValue(val))
+			} else {
+				s.Votes.add("TEXT")
+			}
+		}
+
+		s.Distinct = len(seen)
+
+		if len(p.Rows) > 0 {
+			s.NullRatio = round2(float64(nulls) / float64(len(p.Rows)))
+		}
+
+		family := typeFamily(p.Types[name])
+		s.Rule = precedenceRule(p.Types[name], family, s.Votes, filled, threshold)
+
+		if family == "TEXT" && shape.identifier(name) {
+			s.Rule = p.Types[name] + ": fixed-width digit codes, zero-padded or in a code column, stay text"
+			s.Confidence = 1
+		} else if filled > 0 {
+			s.Confidence = round2(float64(s.Votes[family]) / float64(filled))
+			if s.Confidence < lowConfidence {
+				s.Warning = "low confidence: some values do not fit " + p.Types[name]
+			}
+		}
+
+		stats[name] = s
+	}
+
+	return stats
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// STREAMED SOURCES ////////////////////
+///////////////////////////////////////////////////////////
+
+// Large delimited and JSON files are never held in memory. The preview
+// samples the first streamPreviewRows rows; the job publishes only the
+// schema, and the consumer opens the source again and inserts it chunk
+// by chunk while reading. S3 objects always work this way; HTTP sources
+// do when the request sets "stream": true.
+
+const streamPreviewRows = 1000
+
+// streamSecrets hands the unredacted URL, fetch options and S3
+// credentials of a job to the consumer, which runs in this process,
+// without publishing them to the message bus. They are never written
+// anywhere, so a streamed job that needs them cannot be resumed after a
+// restart: it fails, saying so, and has to be ingested again.
+var streamSecrets sync.Map
+
+type streamSecret struct {
+	URL          string
+	FetchOptions *FetchOptions
+	S3           *S3Options
+}
+
+// hasStreamSecret reports whether publishedRequest masks anything the
+// consumer needs to open req's source.
+func hasStreamSecret(req IngestRequest) bool {
+
+	if req.URL != redactURL(req.URL) {
+		return true
+	}
+
+	if o := req.FetchOptions; o != nil {
+		if len(o.Headers) > 0 || len(o.Cookies) > 0 || o.BasicAuth != nil || o.BearerToken != "" || o.Proxy != redactURL(o.Proxy) {
+			return true
+		}
+	}
+
+	return req.S3 != nil && *req.S3 != *req.S3.redact()
+}
+
+// withStreamSecrets restores what publishedRequest masked, or fails when
+// the job had secrets this process no longer holds.
+func withStreamSecrets(req IngestRequest, jobID string, held bool) (IngestRequest, error) {
+
+	v, ok := streamSecrets.LoadAndDelete(jobID)
+	if !ok {
+		if held {
+			return req, fmt.Errorf("the source's credentials were held in memory only and are gone after a restart; ingest it again")
+		}
+		return req, nil
+	}
+
+	s := v.(streamSecret)
+	req.URL, req.FetchOptions, req.S3 = s.URL, s.FetchOptions, s.S3
+
+	return req, nil
+}
+
+// openJobStream opens the source of a streamed job, whose secrets, if
+// held, are restored first.
+func openJobStream(req IngestRequest, jobID string, held bool) ([]string, rowStream, error) {
+
+	req, err := withStreamSecrets(req, jobID, held)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return openStreamRows(context.Background(), req)
+}
+
+func isStreamed(req IngestRequest) bool {
+	return isS3URL(req.URL) || req.Stream
+}
+
+func openStreamRows(ctx context.Context, req IngestRequest) ([]string, rowStream, error) {
+
+	if isS3URL(req.URL) {
+		return openS3Rows(ctx, req)
+	}
+
+	return openHTTPRows(ctx, req)
+}
+
+func openHTTPRows(ctx context.Context, req IngestRequest) ([]string, rowStream, error) {
+
+	fmt.Printf("🌐 Streaming %s\n", redactURL(req.URL))
+
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", req.URL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.FetchOptions.apply(httpReq)
+
+	client, err := req.FetchOptions.httpClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("source returned %s", resp.Status)
+	}
+
+	name := strings.ToLower(path.Base(strings.SplitN(req.URL, "?", 2)[0]))
+	gzipped := strings.HasSuffix(name, ".gz") || strings.Contains(resp.Header.Get("Content-Type"), "gzip")
+
+	return openRows(resp.Body, name, resp.Header.Get("Content-Type"), gzipped, req)
+}
+
+// openRows decodes a source body as a row stream. name is the file name
+// used for format detection.
+func openRows(body io.ReadCloser, name, contentType string, gzipped bool, req IngestRequest) ([]string, rowStream, error) {
+
+	if gzipped {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			body.Close()
+			return nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		body = &stackedCloser{Reader: gz, closers: []io.Closer{gz, body}}
+		name = strings.TrimSuffix(name, ".gz")
+		contentType = ""
+	}
+
+	format := detectFormat(req, &fetchedSource{URL: name, ContentType: contentType})
+	if req.Format == "" && path.Ext(name) == "" && contentType == "" {
+		format = ""
+	}
+
+	if req.Transpose {
+		body.Close()
+		return nil, nil, fmt.Errorf("transpose cannot be used with streamed sources")
+	}
+
+	if textFormats[format] {
+		var err error
+		if body, err = decodeReader(body, contentType, req.Charset); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch format {
+	case "csv":
+		return openCSVStream(body, ',', gridLayoutFor(req))
+	case "tsv", "tab":
+		return openCSVStream(body, '\t', gridLayoutFor(req))
+	case "json":
+		return openJSONStream(body)
+	case "ndjson", "jsonl":
+		return openNDJSONStream(body)
+	default:
+		body.Close()
+		return nil, nil, fmt.Errorf("format %q cannot be streamed (use csv, tsv, json or ndjson)", format)
+	}
+}
+
+// previewStreamed samples the head of a streamed source.
+func previewStreamed(req IngestRequest) (Preview, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cols, stream, err := openStreamRows(ctx, req)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	rows, err := drainRows(stream, streamPreviewRows)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	p, err := buildPreview(cols, rows)
+	if err == nil {
+		sampledTypes(p.Types)
+	}
+
+	return p, err
+}
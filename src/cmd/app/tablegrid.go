@@ -0,0 +1,394 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TABLE GRID //////////////////////////
+///////////////////////////////////////////////////////////
+
+// maxSpan caps colspan/rowspan so a hostile page cannot blow up the grid.
+const maxSpan = 1000
+
+type gridCell struct {
+	Text   string
+	Header bool
+	Link   string // href of the first link in the cell
+}
+
+// gridRow is one <tr> after span expansion. Section is "thead", "tbody"
+// or "tfoot"; rows outside any section are reported as "tbody".
+type gridRow struct {
+	Cells   []gridCell
+	Section string
+}
+
+type pendingSpan struct {
+	cell gridCell
+	left int
+}
+
+// tableGrid lays the table's cells out on a grid, copying a cell with
+// colspan/rowspan into every position it covers.
+// Spans do not cross from one section into the next.
+func tableGrid(table *goquery.Selection, text func(*goquery.Selection) string) []gridRow {
+
+	var grid []gridRow
+	pending := map[int]pendingSpan{}
+	section := ""
+
+	ownRows(table).Each(func(_ int, tr *goquery.Selection) {
+
+		if s := rowSection(tr); s != section {
+			section = s
+			pending = map[int]pendingSpan{}
+		}
+
+		var row []gridCell
+		col := 0
+
+		// Cells spanning down from earlier rows take their columns first.
+		fill := func() {
+			for {
+				p, ok := pending[col]
+				if !ok {
+					return
+				}
+				row = append(row, p.cell)
+				if p.left--; p.left == 0 {
+					delete(pending, col)
+				} else {
+					pending[col] = p
+				}
+				col++
+			}
+		}
+
+		tr.ChildrenFiltered("th, td").Each(func(_ int, c *goquery.Selection) {
+
+			fill()
+
+			cell := gridCell{
+				Text:   text(c),
+				Header: c.Is("th"),
+				Link:   c.Find("a[href]").First().AttrOr("href", ""),
+			}
+			colspan := spanAttr(c, "colspan")
+			rowspan := spanAttr(c, "rowspan")
+
+			for k := 0; k < colspan; k++ {
+				row = append(row, cell)
+				if rowspan > 1 {
+					pending[col] = pendingSpan{cell: cell, left: rowspan - 1}
+				}
+				col++
+			}
+		})
+
+		fill()
+
+		grid = append(grid, gridRow{Cells: row, Section: section})
+	})
+
+	return grid
+}
+
+// rowSection names the table section a row belongs to. MediaWiki marks
+// totals rows with the sortbottom class instead of using <tfoot>.
+func rowSection(tr *goquery.Selection) string {
+
+	if tr.HasClass("sortbottom") {
+		return "tfoot"
+	}
+
+	switch goquery.NodeName(tr.Parent()) {
+	case "thead":
+		return "thead"
+	case "tfoot":
+		return "tfoot"
+	default:
+		return "tbody"
+	}
+}
+
+func spanAttr(c *goquery.Selection, name string) int {
+
+	n, err := strconv.Atoi(c.AttrOr(name, "1"))
+	if err != nil || n < 1 {
+		return 1
+	}
+
+	if n > maxSpan {
+		return maxSpan
+	}
+
+	return n
+}
+
+// alignRow pads or trims a data row to the header width.
+func alignRow(row []string, width int) []string {
+
+	if len(row) == width {
+		return row
+	}
+
+	if len(row) > width {
+		return row[:width]
+	}
+
+	return append(row, make([]string, width-len(row))...)
+}
+
+// isHeader reports whether a row names columns: anything in <thead>, or
+// a row made only of <th> cells.
+func (r gridRow) isHeader() bool {
+
+	if r.Section == "thead" {
+		return true
+	}
+
+	for _, c := range r.Cells {
+		if !c.Header {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r gridRow) texts() []string {
+
+	cells := make([]string, len(r.Cells))
+	for i, c := range r.Cells {
+		cells[i] = c.Text
+	}
+
+	return cells
+}
+
+// gridTable turns a span-expanded grid into column names and rows
+// aligned to them. skip_rows drops grid rows first; header_row_index then
+// forces the header row instead of detecting <thead>/<th> rows. With extract_links every column that holds a link
+// gets a <name>_url companion column, resolved against the page URL.
+func gridTable(grid []gridRow, req IngestRequest) ([]string, [][]string) {
+
+	if req.SkipRows > 0 {
+		grid = grid[min(req.SkipRows, len(grid)):]
+	}
+
+	var headers, data []gridRow
+
+	if req.Transpose {
+		// Footers are dropped before the pivot; the first source
+		// column is the header.
+		var body []gridRow
+		for _, row := range grid {
+			if len(row.Cells) > 0 && (row.Section != "tfoot" || req.IncludeFooter) {
+				body = append(body, row)
+			}
+		}
+		grid = transposeGrid(body)
+		if len(grid) > 0 && req.ColumnNames == nil {
+			headers, data = grid[:1], grid[1:]
+		} else {
+			data = grid
+		}
+	} else if h := req.HeaderRowIndex; h != nil && *h >= 0 && *h < len(grid) {
+		headers = grid[*h : *h+1]
+		_, data = splitGrid(grid[*h+1:], req.IncludeFooter)
+	} else {
+		headers, data = splitGrid(grid, req.IncludeFooter)
+		headers = dropBanners(headers)
+
+		// No marked header: look for a header-like row among the first
+		// rows, below any title or banner rows.
+		if len(headers) == 0 && req.ColumnNames == nil {
+			texts := make([][]string, len(data))
+			for i, row := range data {
+				texts[i] = row.texts()
+			}
+			if k := detectHeaderRow(texts, nil, headerScanRows(req)); k >= 0 {
+				if k > 0 {
+					fmt.Printf("🔎 Header detected at row %d\n", k)
+				}
+				headers, data = data[k:k+1], data[k+1:]
+			}
+		}
+	}
+
+	cols := joinHeaderRows(headers)
+
+	if req.ColumnNames != nil || len(cols) == 0 {
+		// Headerless tables get the supplied names, or col_N names
+		// generated from the widest row.
+		width := 0
+		for _, row := range data {
+			width = max(width, len(row.Cells))
+		}
+		cols = namedColumns(req.ColumnNames, width)
+	}
+
+	var linked []bool
+	if req.ExtractLinks {
+		linked = make([]bool, len(cols))
+		for _, row := range data {
+			for i, c := range row.Cells {
+				if i < len(linked) && c.Link != "" {
+					linked[i] = true
+				}
+			}
+		}
+	}
+
+	base, _ := url.Parse(req.URL)
+
+	var names []string
+	for i, c := range cols {
+		names = append(names, c)
+		if linked != nil && linked[i] {
+			names = append(names, c+" url")
+		}
+	}
+
+	rows := make([][]string, len(data))
+
+	for r, row := range data {
+		// Stricter ragged_rows policies see the row as the page has it.
+		if linked == nil && req.RaggedRows != "" && req.RaggedRows != "pad" {
+			rows[r] = row.texts()
+			continue
+		}
+
+		cells := alignRow(row.texts(), len(cols))
+		if linked == nil {
+			rows[r] = cells
+			continue
+		}
+
+		out := make([]string, 0, len(names))
+		for i, text := range cells {
+			out = append(out, text)
+			if linked[i] {
+				link := ""
+				if i < len(row.Cells) {
+					link = resolveLink(base, row.Cells[i].Link)
+				}
+				out = append(out, link)
+			}
+		}
+		rows[r] = out
+	}
+
+	return names, rows
+}
+
+func resolveLink(base *url.URL, href string) string {
+
+	if href == "" {
+		return ""
+	}
+
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || base == nil {
+		return href
+	}
+
+	return base.ResolveReference(ref).String()
+}
+
+// splitGrid sorts rows into header rows and data rows. Header rows after
+// the data has started are repeats and are dropped. Footer rows (totals,
+// notes) are data only when includeFooter is set.
+func splitGrid(grid []gridRow, includeFooter bool) ([]gridRow, []gridRow) {
+
+	var headers []gridRow
+	var rows []gridRow
+	footers := 0
+
+	for _, row := range grid {
+
+		if len(row.Cells) == 0 {
+			continue
+		}
+
+		if row.Section == "tfoot" {
+			if includeFooter {
+				rows = append(rows, row)
+			} else {
+				footers++
+			}
+			continue
+		}
+
+		if row.isHeader() {
+			if rows == nil {
+				headers = append(headers, row)
+			}
+			continue
+		}
+
+		rows = append(rows, row)
+	}
+
+	if footers > 0 {
+		fmt.Printf("✓ Skipped %d footer rows (set include_footer to keep them)\n", footers)
+	}
+
+	return headers, rows
+}
+
+// dropBanners removes header rows that repeat one value across the
+// whole table, such as a title cell with a full-width colspan.
+func dropBanners(headers []gridRow) []gridRow {
+
+	var kept []gridRow
+
+	for _, h := range headers {
+		seen := map[string]bool{}
+		for _, c := range h.Cells {
+			seen[c.Text] = true
+		}
+		if len(h.Cells) > 1 && len(seen) == 1 {
+			continue
+		}
+		kept = append(kept, h)
+	}
+
+	return kept
+}
+
+// joinHeaderRows merges stacked header rows into one name per column,
+// so a "Population" group over "2020" becomes "Population 2020".
+func joinHeaderRows(headers []gridRow) []string {
+
+	width := 0
+	for _, h := range headers {
+		if len(h.Cells) > width {
+			width = len(h.Cells)
+		}
+	}
+
+	cols := make([]string, width)
+
+	for i := range cols {
+		var parts []string
+		for _, h := range headers {
+			if i >= len(h.Cells) || h.Cells[i].Text == "" {
+				continue
+			}
+			if len(parts) > 0 && parts[len(parts)-1] == h.Cells[i].Text {
+				continue
+			}
+			parts = append(parts, h.Cells[i].Text)
+		}
+		cols[i] = strings.Join(parts, " ")
+	}
+
+	return cols
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"html"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TEXT NORMALIZATION //////////////////
+///////////////////////////////////////////////////////////
+
+// textReplacer maps invisible and typographic characters to plain ones:
+// non-breaking and thin spaces become spaces, zero-width characters and
+// stray BOMs disappear, smart quotes become ASCII quotes.
+var textReplacer = strings.NewReplacer(
+	"\u00a0", " ", "\u2007", " ", "\u2009", " ", "\u202f", " ",
+	"\u200b", "", "\u200c", "", "\u200d", "", "\u2060", "", "\ufeff", "",
+	"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201b", "'",
+	"\u201c", `"`, "\u201d", `"`, "\u201e", `"`, "\u201f", `"`,
+)
+
+// normalizeText decodes HTML entities left in the text (&amp;, &nbsp;),
+// applies textReplacer, composes the result to NFC so "é" is stored one
+// way whichever way the source spelled it, and trims it. JSON objects and
+// arrays are only trimmed.
+func normalizeText(v string) string {
+
+	if isJSONDocument(v) {
+		return strings.TrimSpace(v)
+	}
+
+	if strings.Contains(v, "&") {
+		v = html.UnescapeString(v)
+	}
+
+	v = textReplacer.Replace(v)
+
+	if !norm.NFC.IsNormalString(v) {
+		v = norm.NFC.String(v)
+	}
+
+	return strings.TrimSpace(v)
+}
+
+// normalizeRows applies normalizeText to every cell in place.
+func normalizeRows(rows [][]string) {
+
+	for _, r := range rows {
+		for i := range r {
+			r[i] = normalizeText(r[i])
+		}
+	}
+}
+
+// textStream normalizes the cells of a streamed source.
+type textStream struct {
+	rowStream
+}
+
+func (s *textStream) Next() ([]string, error) {
+
+	r, err := s.rowStream.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range r {
+		r[i] = normalizeText(r[i])
+	}
+
+	return r, nil
+}
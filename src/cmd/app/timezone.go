@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TIME ZONES //////////////////////////
+///////////////////////////////////////////////////////////
+
+// DATETIME values with an offset ("2024-03-01T09:30:00+05:30") or a zone
+// name ("2024-03-01 09:30:00 EST") are converted to UTC, or to the zone
+// named by timezone, so series from different markets line up. Values
+// without a zone are only reformatted. The zones seen per column are kept
+// in the job's source metadata.
+
+const mysqlDateTime = "2006-01-02 15:04:05"
+
+// zoneOffsets fixes the common abbreviations Go cannot resolve on its
+// own; unknown abbreviations would otherwise parse as UTC.
+var zoneOffsets = map[string]int{
+	"UTC": 0, "GMT": 0, "Z": 0,
+	"EST": -5 * 3600, "EDT": -4 * 3600, "CST": -6 * 3600, "CDT": -5 * 3600,
+	"MST": -7 * 3600, "MDT": -6 * 3600, "PST": -8 * 3600, "PDT": -7 * 3600,
+	"BST": 3600, "CET": 3600, "CEST": 2 * 3600, "EET": 2 * 3600, "EEST": 3 * 3600,
+	"IST": 5*3600 + 1800, "SGT": 8 * 3600, "HKT": 8 * 3600, "JST": 9 * 3600,
+	"AEST": 10 * 3600, "AEDT": 11 * 3600,
+}
+
+// outputLocation resolves the timezone option; empty means UTC.
+func outputLocation(name string) (*time.Location, error) {
+
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", name)
+	}
+
+	return loc, nil
+}
+
+// parseDateTime reads v with the first matching DATETIME layout, or DATE
+// layout for a bare date. zone is
+// the offset ("+05:30", "Z") or abbreviation the value carried, empty for
+// naive values.
+func parseDateTime(v string) (time.Time, string, bool) {
+
+	v = strings.TrimSpace(v)
+
+	for _, l := range dateTimeLayouts {
+
+		t, err := time.Parse(l, v)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case strings.Contains(l, "MST"):
+			name, offset := t.Zone()
+			if fixed, known := zoneOffsets[name]; known && offset != fixed {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(),
+					t.Nanosecond(), time.FixedZone(name, fixed))
+			}
+			return t, name, true
+		case strings.Contains(l, "Z07") || strings.Contains(l, "-07"):
+			return t, t.Format("Z07:00"), true
+		default:
+			return t, "", true
+		}
+	}
+
+	// DATE values in a DATETIME column are midnight.
+	for _, l := range dateLayouts {
+		if t, err := time.Parse(l, v); err == nil {
+			return t, "", true
+		}
+	}
+
+	return time.Time{}, "", false
+}
+
+// convertDateTime rewrites v as a MySQL DATETIME in loc. Values that do
+// not parse are left untouched.
+func convertDateTime(v string, loc *time.Location) (string, string) {
+
+	t, zone, ok := parseDateTime(v)
+	if !ok {
+		return v, ""
+	}
+
+	if zone != "" {
+		t = t.In(loc)
+	}
+
+	return t.Format(mysqlDateTime), zone
+}
+
+// normalizeDateTimes converts every DATETIME column of the preview and
+// records the zones each one carried.
+func normalizeDateTimes(p Preview, loc *time.Location) Preview {
+
+	for c, name := range p.Columns {
+
+		if !isDateTimeType(p.Types[name]) {
+			continue
+		}
+
+		zones := map[string]bool{}
+		for _, r := range p.Rows {
+			if c < len(r) && r[c] != "" {
+				var zone string
+				r[c], zone = convertDateTime(r[c], loc)
+				if zone != "" {
+					zones[zone] = true
+				}
+			}
+		}
+
+		if len(zones) == 0 || p.Source == nil {
+			continue
+		}
+
+		var seen []string
+		for z := range zones {
+			seen = append(seen, z)
+		}
+		sort.Strings(seen)
+
+		if p.Source.TimeZones == nil {
+			p.Source.TimeZones = map[string][]string{}
+		}
+		p.Source.TimeZones[name] = seen
+	}
+
+	return p
+}
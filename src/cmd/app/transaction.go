@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TRANSACTIONS ////////////////////////
+///////////////////////////////////////////////////////////
+
+// "transaction" (default INGEST_TRANSACTION, else none) sets how a job's
+// rows are committed:
+//
+//	none   each INSERT commits on its own; a batch that fails is retried
+//	       row by row and rows that still fail are skipped
+//	chunk  each INSERT batch commits together with the job's checkpoint,
+//	       so a resumed job neither repeats nor loses rows; a row that
+//	       fails rolls back its batch and fails the job
+//	job    the job's rows commit at once when the last is written; a row
+//	       that fails, or a source that breaks off, rolls back every row
+//	       and fails the job
+//
+// The failing row and the database's error go to the job's log. MySQL
+// commits DDL on its own, so the table a job creates, drops or widens
+// before its rows stays: a failed create job leaves an empty table. A
+// job transaction holds its rows' locks and undo log until the end, so
+// very large jobs are better off with chunk.
+
+const (
+	txNone  = "none"
+	txChunk = "chunk"
+	txJob   = "job"
+)
+
+var defaultTransaction = strings.ToLower(os.Getenv("INGEST_TRANSACTION"))
+
+func validTransaction(scope string) error {
+
+	switch scope {
+	case txJob:
+		if dbDriver == dbSQLite {
+			// The job's progress, log and reject writes would wait on the
+			// write lock its transaction holds until the end, and fail.
+			return fmt.Errorf("transaction job is not available with DB_DRIVER=sqlite (use chunk)")
+		}
+		return nil
+	case "", txNone, txChunk:
+		return nil
+	}
+
+	return fmt.Errorf("unknown transaction %q (use none, chunk or job)", scope)
+}
+
+// transactionFor returns the request's transaction scope.
+func transactionFor(req IngestRequest) string {
+
+	scope := req.Transaction
+	if scope == "" {
+		scope = defaultTransaction
+	}
+	if scope == txJob && dbDriver == dbSQLite {
+		// INGEST_TRANSACTION=job, or a source that skips validTransaction.
+		return txChunk
+	}
+	if validTransaction(scope) != nil || scope == "" {
+		return txNone
+	}
+
+	return scope
+}
+
+// execer runs statements on the database or in a transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// jobTx runs a job's INSERTs in the transaction of its scope, begun with
+// the first one. The job keeps one connection, on which each distinct
+// statement (a batch size and width) is prepared once and reused for
+// every batch; each chunk's transaction is begun on that connection too,
+// and as a transaction belongs to the connection, the statements run in
+// it without being prepared again. close releases both.
+type jobTx struct {
+	scope string
+	conn  *sql.Conn
+	tx    *sql.Tx
+	stmts map[string]*sql.Stmt // by query, prepared on conn
+}
+
+func (t *jobTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+
+	ctx := context.Background()
+
+	if t.conn == nil {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.conn, t.stmts = conn, map[string]*sql.Stmt{}
+	}
+
+	stmt, ok := t.stmts[query]
+	if !ok {
+		var err error
+		if stmt, err = t.conn.PrepareContext(ctx, query); err != nil {
+			return nil, err
+		}
+		t.stmts[query] = stmt
+	}
+
+	if t.scope != txNone && t.tx == nil {
+		tx, err := t.conn.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("begin transaction: %w", err)
+		}
+		t.tx = tx
+	}
+
+	return stmt.Exec(args...)
+}
+
+func (t *jobTx) commit() error {
+
+	if t.tx == nil {
+		return nil
+	}
+
+	err := t.tx.Commit()
+	t.tx = nil
+	if err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+
+	return nil
+}
+
+func (t *jobTx) rollback() {
+
+	if t.tx != nil {
+		t.tx.Rollback()
+		t.tx = nil
+	}
+}
+
+// close releases the job's prepared statements and its connection.
+func (t *jobTx) close() {
+
+	t.rollback()
+	for _, stmt := range t.stmts {
+		stmt.Close()
+	}
+	t.stmts = nil
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+}
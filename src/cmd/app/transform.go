@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TRANSFORMS //////////////////////////
+///////////////////////////////////////////////////////////
+
+// Transform is one step of a column's transforms, run in order on the
+// source cells before type detection and cleaning, e.g.
+//
+//	"transforms": {"isin": [{"op": "trim"}, {"op": "substring", "start": 0, "length": 12}]}
+//
+// A cast that fails rejects the row into ingestion_rejects instead of
+// inserting a wrong value. Empty (NULL) cells pass every step unchanged.
+type Transform struct {
+	Op      string            `json:"op"`      // trim, upper, lower, transliterate, regex_replace, substring, lookup or cast
+	Pattern string            `json:"pattern"` // regex_replace
+	Replace string            `json:"replace"` // regex_replace; $1 expands groups
+	Start   int               `json:"start"`   // substring: first character, 0-based
+	Length  int               `json:"length"`  // substring: characters kept; 0 keeps the rest
+	Map     map[string]string `json:"map"`     // lookup: value -> replacement
+	Default *string           `json:"default"` // lookup: value for misses; misses are kept when unset
+	Type    string            `json:"type"`    // cast: int, number, date, datetime or bool
+}
+
+type transformStep func(string) (string, error)
+
+// columnTransform is the compiled transforms of one column.
+type columnTransform struct {
+	col   int
+	name  string
+	steps []transformStep
+}
+
+var transformCasts = map[string]func(string) (string, bool){
+	"int": func(v string) (string, bool) {
+		n, err := strconv.ParseInt(cleanValue(v), 10, 64)
+		return strconv.FormatInt(n, 10), err == nil
+	},
+	"number": func(v string) (string, bool) {
+		v = cleanValue(v)
+		t := classifyValue(v)
+		return v, t == "INT" || t == "FLOAT"
+	},
+	"date": func(v string) (string, bool) {
+		t, _, ok := parseDateTime(v)
+		return t.Format("2006-01-02"), ok
+	},
+	"datetime": func(v string) (string, bool) {
+		t, _, ok := parseDateTime(v)
+		return t.Format("2006-01-02 15:04:05"), ok
+	},
+	"bool": func(v string) (string, bool) {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "1", "true", "t", "yes", "y":
+			return "1", true
+		case "0", "false", "f", "no", "n":
+			return "0", true
+		}
+		return "", false
+	},
+}
+
+func compileTransform(t Transform) (transformStep, error) {
+
+	plain := func(fn func(string) string) transformStep {
+		return func(v string) (string, error) { return fn(v), nil }
+	}
+
+	switch t.Op {
+	case "trim":
+		return plain(strings.TrimSpace), nil
+	case "upper":
+		return plain(strings.ToUpper), nil
+	case "lower":
+		return plain(strings.ToLower), nil
+	case "transliterate":
+		return plain(transliterate), nil
+
+	case "regex_replace":
+		re, err := regexp.Compile(t.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("regex_replace %q: %w", t.Pattern, err)
+		}
+		return plain(func(v string) string { return re.ReplaceAllString(v, t.Replace) }), nil
+
+	case "substring":
+		if t.Start < 0 || t.Length < 0 {
+			return nil, fmt.Errorf("substring: start and length must not be negative")
+		}
+		return plain(func(v string) string {
+			r := []rune(v)
+			if t.Start >= len(r) {
+				return ""
+			}
+			r = r[t.Start:]
+			if t.Length > 0 && t.Length < len(r) {
+				r = r[:t.Length]
+			}
+			return string(r)
+		}), nil
+
+	case "lookup":
+		if len(t.Map) == 0 {
+			return nil, fmt.Errorf("lookup: map is empty")
+		}
+		return plain(func(v string) string {
+			if m, ok := t.Map[v]; ok {
+				return m
+			}
+			if t.Default != nil {
+				return *t.Default
+			}
+			return v
+		}), nil
+
+	case "cast":
+		cast, ok := transformCasts[strings.ToLower(t.Type)]
+		if !ok {
+			return nil, fmt.Errorf("cast: unknown type %q (use int, number, date, datetime or bool)", t.Type)
+		}
+		return func(v string) (string, error) {
+			out, ok := cast(v)
+			if !ok {
+				return "", fmt.Errorf("cannot cast %q to %s", v, strings.ToLower(t.Type))
+			}
+			return out, nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown transform %q", t.Op)
+}
+
+// compileTransforms resolves the transforms of a request against the
+// table's columns. Column names are matched after normalization and may
+// be the detected name of a renamed column.
+func compileTransforms(defs map[string][]Transform, cols []string, rename map[string]string) ([]columnTransform, error) {
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	var out []columnTransform
+
+	for name, steps := range defs {
+
+		col := renamedColumn(name, rename)
+		i, ok := index[col]
+		if !ok {
+			return nil, fmt.Errorf("transforms: unknown column %q (available: %s)", col, strings.Join(cols, ", "))
+		}
+
+		ct := columnTransform{col: i, name: col}
+		for _, t := range steps {
+			step, err := compileTransform(t)
+			if err != nil {
+				return nil, fmt.Errorf("transforms: column %s: %w", col, err)
+			}
+			ct.steps = append(ct.steps, step)
+		}
+		out = append(out, ct)
+	}
+
+	return out, nil
+}
+
+// transformRow runs the transforms on a copy of row. It returns a reason
+// when a step fails.
+func transformRow(row []string, transforms []columnTransform) ([]string, string) {
+
+	out := append([]string{}, row...)
+
+	for _, ct := range transforms {
+
+		if ct.col >= len(out) || out[ct.col] == "" {
+			continue
+		}
+
+		v := out[ct.col]
+		for _, step := range ct.steps {
+			var err error
+			if v, err = step(v); err != nil {
+				return nil, fmt.Sprintf("column %s: %v", ct.name, err)
+			}
+		}
+		out[ct.col] = v
+	}
+
+	return out, ""
+}
+
+// applyTransforms transforms the preview rows. Rows a step fails on move
+// to p.Rejected, and the transformed columns are inferred again.
+func applyTransforms(p Preview, defs map[string][]Transform, rename map[string]string, o inferenceOptions) (Preview, error) {
+
+	if len(defs) == 0 {
+		return p, nil
+	}
+
+	transforms, err := compileTransforms(defs, p.Columns, rename)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	var kept [][]string
+
+	for i, row := range p.Rows {
+		out, reason := transformRow(row, transforms)
+		if reason != "" {
+			p.Rejected = append(p.Rejected, RejectedRow{Row: i + 1, Reason: reason, Cells: row})
+			continue
+		}
+		kept = append(kept, out)
+	}
+
+	if n := len(p.Rows) - len(kept); n > 0 {
+		fmt.Printf("⚠️  Rejected %d rows failing transforms\n", n)
+	}
+	p.Rows = kept
+
+	types := inferTypesWith(p.Columns, p.Rows, func(_ int, v string) string {
+		return cleanForInference(v)
+	}, o)
+	for _, ct := range transforms {
+		p.Types[ct.name] = types[ct.name]
+	}
+
+	return p, nil
+}
+
+// transformStream transforms a streamed source, recording the rows it
+// rejects against the job.
+type transformStream struct {
+	rowStream
+	transforms []columnTransform
+	jobID      string
+	row        int
+}
+
+func (s *transformStream) Next() ([]string, error) {
+
+	for {
+		r, err := s.rowStream.Next()
+		if err != nil {
+			return nil, err
+		}
+		s.row++
+
+		out, reason := transformRow(r, s.transforms)
+		if reason == "" {
+			return out, nil
+		}
+
+		recordRejects(s.jobID, []RejectedRow{{Row: s.row, Reason: reason, Cells: r}})
+	}
+}
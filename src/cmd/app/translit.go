@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// TRANSLITERATION /////////////////////
+///////////////////////////////////////////////////////////
+
+// Column names are ASCII identifiers. Rather than dropping every other
+// character ("Prämie" would become "prmie", a Cyrillic header col_0),
+// names are transliterated first: accents are stripped ("Prämie" ->
+// "pramie", "Côte" -> "cote") and Cyrillic and Greek letters are spelled
+// in Latin ("Цена" -> "tsena", "Τιμή" -> "timi"). Scripts without a table
+// here, such as CJK, still fall back to col_N.
+//
+// With UNICODE_COLUMNS=true names keep their letters in any script
+// instead, lower-cased ("Prämie" -> "prämie", "价格" -> "价格"). They are
+// written unquoted like any other name, which MySQL allows for characters
+// of the Basic Multilingual Plane; characters beyond it are dropped.
+var unicodeColumns = os.Getenv("UNICODE_COLUMNS") == "true"
+
+// invalidUnicodeChars is invalidChars for UNICODE_COLUMNS.
+var invalidUnicodeChars = regexp.MustCompile(`[^\p{L}\p{N}_]+`)
+
+// translitTable spells lower-case letters that do not decompose into a
+// Latin letter and accents.
+var translitTable = map[rune]string{
+	// Latin
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u", 'ђ': "dj", 'ј': "j",
+	'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th",
+	'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+	'ω': "o",
+}
+
+// transliterate spells s in ASCII as far as translitTable and accent
+// stripping allow, keeping the case of each letter. Characters it cannot
+// spell are kept.
+func transliterate(s string) string {
+
+	var b strings.Builder
+
+	for _, r := range norm.NFC.String(s) {
+
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+			continue
+		}
+
+		// "й" and "ё" are letters of their own, not accented ones.
+		if t, ok := translitLetter(r); ok {
+			b.WriteString(t)
+			continue
+		}
+
+		for _, d := range norm.NFD.String(string(r)) {
+			if unicode.Is(unicode.Mn, d) {
+				continue
+			}
+			if t, ok := translitLetter(d); ok {
+				b.WriteString(t)
+			} else {
+				b.WriteRune(d)
+			}
+		}
+	}
+
+	return b.String()
+}
+
+// translitLetter looks r up in translitTable, capitalizing the spelling
+// of upper-case letters.
+func translitLetter(r rune) (string, bool) {
+
+	lower := unicode.ToLower(r)
+	t, ok := translitTable[lower]
+	if !ok || lower == r || t == "" {
+		return t, ok
+	}
+
+	return strings.ToUpper(t[:1]) + t[1:], true
+}
+
+// identifierName is the column name normalizeColumns makes of a
+// lower-cased, underscored header.
+func identifierName(name string) string {
+
+	if !unicodeColumns {
+		return invalidChars.ReplaceAllString(transliterate(name), "")
+	}
+
+	// MySQL identifiers are limited to the Basic Multilingual Plane.
+	name = strings.Map(func(r rune) rune {
+		if r > 0xFFFF {
+			return -1
+		}
+		return r
+	}, name)
+
+	return invalidUnicodeChars.ReplaceAllString(name, "")
+}
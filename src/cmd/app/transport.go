@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// MESSAGE BUS /////////////////////////
+///////////////////////////////////////////////////////////
+
+// Jobs travel from the API to the consumer over a message bus chosen with
+// MESSAGE_BUS (or QUEUE_BACKEND): kafka (the default), or for teams
+// without a Kafka cluster rabbitmq (rabbitmq.go), nats (jetstream.go) or
+// sqs (sqs.go), or with no broker at all direct (direct.go). Either way a
+// job is the same messages, keyed by its table, and the consumer runs one
+// worker per ordered stream: a Kafka partition, a RabbitMQ queue, a
+// JetStream subject, the SQS queue or the in-process channel.
+var messageBusKind = messageBusFromEnv()
+
+func messageBusFromEnv() string {
+
+	kind := os.Getenv("MESSAGE_BUS")
+	if kind == "" {
+		kind = os.Getenv("QUEUE_BACKEND")
+	}
+
+	return strings.ToLower(strings.TrimSpace(kind))
+}
+
+var bus messageBus
+
+// CONSUMER_WORKERS is how many jobs each stream runs at once (default 1).
+var consumerWorkers = consumerWorkersFromEnv()
+
+func consumerWorkersFromEnv() int {
+
+	if n, err := strconv.Atoi(os.Getenv("CONSUMER_WORKERS")); err == nil && n > 0 {
+		return n
+	}
+
+	return 1
+}
+
+// CONSUMER_MAX_WAITING is how many assembled jobs, rows in memory, a
+// stream holds for a worker (default 100) before it stops reading.
+var consumerMaxWaiting = 100
+
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("CONSUMER_MAX_WAITING")); err == nil && n > 0 {
+		consumerMaxWaiting = n
+	}
+}
+
+type messageBus interface {
+	// Publish queues one job's encoded messages, in order, under key in
+	// the lane of priority.go. Messages the broker turns down later fail
+	// the job (failDelivery).
+	Publish(jobID string, lane jobPriority, key string, values [][]byte) error
+	// Streams opens the streams the consumer reads, of every lane.
+	Streams() ([]messageStream, error)
+}
+
+// messageStream is an ordered stream of messages. Offsets grow along it.
+type messageStream interface {
+	Name() string
+	Messages() <-chan busMessage
+	// Commit records that every message before offset next is handled,
+	// so a restarted consumer does not see it again.
+	Commit(next int64)
+	// Lag is how many messages wait in the broker, as far as it tells.
+	Lag() (int64, error)
+}
+
+type busMessage struct {
+	value  []byte
+	offset int64
+}
+
+func newMessageBus() (messageBus, error) {
+
+	switch messageBusKind {
+	case "", "kafka":
+		return newKafkaBus()
+	case "rabbitmq", "amqp":
+		return newRabbitBus()
+	case "nats", "jetstream":
+		return newNATSBus()
+	case "sqs", "sns":
+		return newSQSBus()
+	case "direct", "memory":
+		return newDirectBus()
+	}
+
+	return nil, fmt.Errorf("unknown MESSAGE_BUS %q (use kafka, rabbitmq, nats, sqs or direct)", messageBusKind)
+}
+
+// keyLane spreads keys over n lanes the way Kafka's hash partitioner
+// does.
+func keyLane(key string, n int) int {
+
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	return int(h.Sum32() % uint32(n))
+}
+
+// readyJob is an assembled job waiting for, or taken by, a worker.
+type readyJob struct {
+	id       string
+	table    string
+	priority jobPriority
+	payload  map[string]interface{}
+	rows     [][]string
+	offset   int64 // of the job's first message
+}
+
+// consumeJobs runs the jobs of one stream on up to consumerWorkers
+// workers, reporting to state (queue.go). A table's jobs run one at a
+// time, in order, so their DDL never races; a job waits while another on
+// its table runs, without holding up other tables. Of the jobs that may
+// start, higher priorities go first (priority.go). While
+// consumerMaxWaiting jobs wait, the stream is not read. The stream is
+// committed up to the oldest job not yet written.
+func consumeJobs(stream messageStream, state *streamState) {
+
+	fmt.Printf("🧵 Consuming %s\n", stream.Name())
+
+	// Jobs whose row chunks are still arriving (chunks.go).
+	pending := map[string]*pendingJob{}
+
+	var waiting []*readyJob
+	running := map[string]*readyJob{} // by table
+	done := make(chan *readyJob)
+	last := int64(-1)
+
+	// dispatch starts waiting jobs while workers are free: of each idle
+	// table's oldest job, highest priority first.
+	dispatch := func() {
+
+		var next []*readyJob
+		seen := map[string]bool{}
+		for _, j := range waiting {
+			if _, busy := running[j.table]; !busy && !seen[j.table] {
+				next = append(next, j)
+			}
+			seen[j.table] = true
+		}
+		sort.SliceStable(next, func(a, b int) bool { return next[a].priority < next[b].priority })
+
+		started := map[*readyJob]bool{}
+		for _, j := range next {
+			if len(running) >= consumerWorkers {
+				break
+			}
+			running[j.table] = j
+			started[j] = true
+			go func() {
+				runJob(j.payload, j.rows)
+				done <- j
+			}()
+		}
+
+		rest := waiting[:0]
+		for _, j := range waiting {
+			if !started[j] {
+				rest = append(rest, j)
+			}
+		}
+		waiting = rest
+
+		var ids []string
+		for _, j := range running {
+			ids = append(ids, j.id)
+		}
+		state.running(ids, len(pending))
+	}
+
+	commit := func() {
+		if last < 0 {
+			return
+		}
+		next := resumeOffset(pending, last)
+		for _, j := range waiting {
+			next = min(next, j.offset)
+		}
+		for _, j := range running {
+			next = min(next, j.offset)
+		}
+		stream.Commit(next)
+	}
+
+	msgs := stream.Messages()
+
+	for msgs != nil || len(running) > 0 || len(waiting) > 0 {
+
+		in := msgs
+		if len(waiting) >= consumerMaxWaiting {
+			in = nil // until a worker takes a job
+		}
+
+		select {
+		case msg, ok := <-in:
+			if !ok {
+				msgs = nil
+				continue
+			}
+
+			state.received(len(pending))
+			last = msg.offset
+
+			payload, err := decodeWaiting(msg.value, fmt.Sprintf("offset %d of %s", msg.offset, stream.Name()))
+			var bad *badMessage
+			if errors.As(err, &bad) {
+				// The rest of its messages are skipped as a failed job's.
+				delete(pending, bad.jobID)
+				logJob(bad.jobID, fmt.Sprintf("unreadable message at offset %d of %s: %v", msg.offset, stream.Name(), bad.err))
+				db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=? AND status='running'`, bad.jobID)
+			}
+			if err != nil {
+				fmt.Printf("⚠️  Skipped message at offset %d of %s: %v\n", msg.offset, stream.Name(), err)
+			} else {
+				id, _ := payload["job_id"].(string)
+				first := msg.offset
+				if job, ok := pending[id]; ok {
+					first = job.offset
+				}
+				if payload, rows, ok := assembleJob(pending, payload, msg.offset); ok {
+					table, _ := payload["table"].(string)
+					waiting = append(waiting, &readyJob{id: id, table: table, priority: payloadPriority(payload), payload: payload, rows: rows, offset: first})
+				}
+			}
+
+			expireJobs(pending)
+
+		case j := <-done:
+			delete(running, j.table)
+		}
+
+		dispatch()
+		commit()
+	}
+
+	state.stop()
+	fmt.Printf("⚠️  Stopped consuming %s\n", stream.Name())
+}
+
+// failDelivery fails the job of a message the broker did not accept.
+func failDelivery(d delivery, err error) {
+
+	what := "job message"
+	if d.message > 0 {
+		what = fmt.Sprintf("chunk %d of %d", d.message, d.messages-1)
+	}
+
+	fmt.Printf("❌ Job %s: %s not delivered: %v\n", d.jobID, what, err)
+	logJob(d.jobID, fmt.Sprintf("%s not delivered to the message bus: %v", what, err))
+	db.Exec(`UPDATE ingestion_jobs SET status='failed' WHERE id=? AND status='running'`, d.jobID)
+}
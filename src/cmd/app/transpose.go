@@ -0,0 +1,53 @@
+package main
+
+///////////////////////////////////////////////////////////
+//////////////////// TRANSPOSE ///////////////////////////
+///////////////////////////////////////////////////////////
+
+// Key/value tables run their headers down the first column. With
+// transpose the table is pivoted before the header is taken, so every
+// source row becomes a column and the first source column becomes the
+// header. skip_rows drops source rows before the pivot.
+
+// transposeRecords pivots a grid of records, padding short records.
+func transposeRecords(records [][]string) [][]string {
+
+	width := 0
+	for _, rec := range records {
+		width = max(width, len(rec))
+	}
+
+	out := make([][]string, width)
+	for j := range out {
+		out[j] = make([]string, len(records))
+		for i, rec := range records {
+			if j < len(rec) {
+				out[j][i] = rec[j]
+			}
+		}
+	}
+
+	return out
+}
+
+// transposeGrid is transposeRecords for HTML grids. The pivoted rows all
+// belong to the body; cells keep their header flag and link.
+func transposeGrid(grid []gridRow) []gridRow {
+
+	width := 0
+	for _, row := range grid {
+		width = max(width, len(row.Cells))
+	}
+
+	out := make([]gridRow, width)
+	for j := range out {
+		out[j] = gridRow{Cells: make([]gridCell, len(grid)), Section: "tbody"}
+		for i, row := range grid {
+			if j < len(row.Cells) {
+				out[j].Cells[i] = row.Cells[j]
+			}
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// UPSERT MODE /////////////////////////
+///////////////////////////////////////////////////////////
+
+// "mode": "upsert" refreshes a table in place: a row whose upsert_keys
+// match a row of the table updates it, any other row is inserted. A
+// reference table (tickers, FX rates, holidays) can so be ingested again
+// from the same source without growing or being dropped. The table is
+// created as in append mode when missing, and the key columns get a
+// UNIQUE index named after them, which the database checks each row
+// against (ON DUPLICATE KEY UPDATE). Rows with an empty key cell never
+// match and are inserted.
+
+const modeUpsert = "upsert"
+
+// upsertKeyColumns resolves upsert_keys against the table's columns.
+// Names may be the detected name of a renamed column.
+func upsertKeyColumns(req IngestRequest, cols []string) ([]string, error) {
+
+	if req.Mode != modeUpsert {
+		if len(req.UpsertKeys) > 0 {
+			return nil, fmt.Errorf("upsert_keys needs mode upsert")
+		}
+		return nil, nil
+	}
+
+	if len(req.UpsertKeys) == 0 {
+		return nil, fmt.Errorf("mode upsert needs upsert_keys, the columns identifying a row")
+	}
+	if dedupEnabled(req) {
+		return nil, fmt.Errorf("dedup does not apply to mode upsert; rows with the same upsert_keys replace each other")
+	}
+
+	index := map[string]bool{}
+	for _, c := range cols {
+		index[c] = true
+	}
+
+	var keys []string
+	for _, name := range req.UpsertKeys {
+		col := renamedColumn(name, req.Rename)
+		if !index[col] {
+			return nil, fmt.Errorf("upsert_keys: unknown column %q (available: %s)", col, strings.Join(cols, ", "))
+		}
+		keys = append(keys, col)
+	}
+
+	return keys, nil
+}
+
+// ensureUpsertKey adds the UNIQUE index on keys to table unless it is
+// there already.
+func ensureUpsertKey(table string, keys []string) error {
+
+	name := "uk_" + strings.Join(keys, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	_, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD UNIQUE KEY %s (%s)", table, name, strings.Join(keys, ",")))
+
+	var me *mysql.MySQLError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &me) && me.Number == 1061: // duplicate key name
+		return nil
+	case errors.As(err, &me) && me.Number == 1170: // TEXT column in a key
+		return fmt.Errorf("upsert key (%s): TEXT columns cannot be keys; give them a VARCHAR type in types", strings.Join(keys, ", "))
+	}
+
+	return fmt.Errorf("upsert key (%s): %w", strings.Join(keys, ", "), err)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// VALIDATION //////////////////////////
+///////////////////////////////////////////////////////////
+
+// ValidationRule is a constraint every inserted row must meet, e.g.
+//
+//	"validate": [{"column": "price", "required": true, "min": 0},
+//	             {"column": "isin", "pattern": "^[A-Z]{2}[A-Z0-9]{9}[0-9]$"},
+//	             {"check": "maturity >= issue_date", "message": "matures before issue"}]
+//
+// Rules see the row as it will be inserted: cleaned, mapped and with its
+// defaults. Empty (NULL) cells pass every rule but required, and a check
+// that is NULL passes, as a SQL CHECK constraint does. Rows that break a
+// rule are stored in ingestion_rejects with the reason instead of being
+// inserted.
+type ValidationRule struct {
+	Column   string   `json:"column"`   // column the rule is about; not needed for check
+	Required bool     `json:"required"` // the cell must not be empty
+	Min      *float64 `json:"min"`      // the cell must be a number >= min
+	Max      *float64 `json:"max"`      // the cell must be a number <= max
+	Pattern  string   `json:"pattern"`  // the cell must match this regex
+	Check    string   `json:"check"`    // expression (see expr.go) that must hold, e.g. low <= high
+	Message  string   `json:"message"`  // reason recorded for violating rows instead of the generated one
+}
+
+// validation is a compiled rule. test returns why row breaks it, or "".
+type validation struct {
+	test func(row []string) string
+}
+
+// compileValidations resolves the rules of req against cols.
+func compileValidations(req IngestRequest, cols []string) ([]validation, error) {
+
+	index := map[string]int{}
+	for i, c := range cols {
+		index[c] = i
+	}
+
+	var out []validation
+
+	for n, rule := range req.Validate {
+
+		v, err := compileValidation(rule, cols, index, req.Rename)
+		if err != nil {
+			return nil, fmt.Errorf("validate: rule %d: %w", n+1, err)
+		}
+		out = append(out, v)
+	}
+
+	return out, nil
+}
+
+func compileValidation(rule ValidationRule, cols []string, index map[string]int, rename map[string]string) (validation, error) {
+
+	var tests []func([]string) string
+
+	if rule.Check != "" {
+		e, err := compileExpr(rule.Check, cols)
+		if err != nil {
+			return validation{}, err
+		}
+		tests = append(tests, func(row []string) string {
+			if v := e.eval(row); v != "" && !truthy(v) {
+				return fmt.Sprintf("%s does not hold", rule.Check)
+			}
+			return ""
+		})
+	}
+
+	if rule.Column == "" {
+		if rule.Check == "" {
+			return validation{}, fmt.Errorf("needs a column or a check")
+		}
+		if rule.Required || rule.Min != nil || rule.Max != nil || rule.Pattern != "" {
+			return validation{}, fmt.Errorf("required, min, max and pattern need a column")
+		}
+		return validation{test: firstViolation(tests, rule.Message)}, nil
+	}
+
+	col := renamedColumn(rule.Column, rename)
+	i, ok := index[col]
+	if !ok {
+		return validation{}, fmt.Errorf("unknown column %q (available: %s)", col, strings.Join(cols, ", "))
+	}
+
+	cell := func(row []string) string {
+		if i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+
+	if rule.Required {
+		tests = append(tests, func(row []string) string {
+			if strings.TrimSpace(cell(row)) == "" {
+				return fmt.Sprintf("%s is required", col)
+			}
+			return ""
+		})
+	}
+
+	if rule.Min != nil || rule.Max != nil {
+		if rule.Min != nil && rule.Max != nil && *rule.Min > *rule.Max {
+			return validation{}, fmt.Errorf("min %v is above max %v", *rule.Min, *rule.Max)
+		}
+		tests = append(tests, func(row []string) string {
+			v := cell(row)
+			if v == "" {
+				return ""
+			}
+			f, ok := exprNumber(v)
+			switch {
+			case !ok:
+				return fmt.Sprintf("%s %q is not a number", col, v)
+			case rule.Min != nil && f < *rule.Min:
+				return fmt.Sprintf("%s %s is below %v", col, v, *rule.Min)
+			case rule.Max != nil && f > *rule.Max:
+				return fmt.Sprintf("%s %s is above %v", col, v, *rule.Max)
+			}
+			return ""
+		})
+	}
+
+	if rule.Pattern != "" {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return validation{}, fmt.Errorf("pattern %q: %w", rule.Pattern, err)
+		}
+		tests = append(tests, func(row []string) string {
+			if v := cell(row); v != "" && !re.MatchString(v) {
+				return fmt.Sprintf("%s %q does not match %s", col, v, rule.Pattern)
+			}
+			return ""
+		})
+	}
+
+	if len(tests) == 0 {
+		return validation{}, fmt.Errorf("column %s has no required, min, max, pattern or check", col)
+	}
+
+	return validation{test: firstViolation(tests, rule.Message)}, nil
+}
+
+// firstViolation runs tests in order and reports the first one broken,
+// as message when it is set.
+func firstViolation(tests []func([]string) string, message string) func([]string) string {
+
+	return func(row []string) string {
+		for _, t := range tests {
+			if reason := t(row); reason != "" {
+				if message != "" {
+					return message
+				}
+				return reason
+			}
+		}
+		return ""
+	}
+}
+
+// validateRow returns the reason row breaks the first rule it breaks.
+func validateRow(row []string, validations []validation) string {
+
+	for _, v := range validations {
+		if reason := v.test(row); reason != "" {
+			return "validation: " + reason
+		}
+	}
+
+	return ""
+}
+
+// validateRows moves the preview rows that break a rule to p.Rejected.
+// Cells are cleaned by clean and mapped first, as the consumer will see
+// them. Required columns are never NULL in the table.
+func validateRows(p Preview, req IngestRequest, clean func(int, string) string, mappings []columnMapping) (Preview, error) {
+
+	validations, err := compileValidations(req, p.Columns)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	var kept [][]string
+	rejected := 0
+	for i, row := range p.Rows {
+		cleaned := make([]string, len(p.Columns))
+		for c := range cleaned {
+			if c < len(row) {
+				cleaned[c] = clean(c, row[c])
+			}
+		}
+		if reason := validateRow(mapRow(cleaned, mappings), validations); reason != "" {
+			p.Rejected = append(p.Rejected, RejectedRow{Row: i + 1, Reason: reason, Cells: row})
+			rejected++
+			continue
+		}
+		kept = append(kept, row)
+	}
+	p.Rows = kept
+
+	if rejected > 0 {
+		fmt.Printf("⚠️  Rejected %d rows failing validation\n", rejected)
+	}
+
+	return p, nil
+}
+
+// requireColumns adds the columns a rule makes required to p.NotNull:
+// rows missing them are rejected, so even a streamed source never
+// inserts a NULL there.
+func requireColumns(p Preview, req IngestRequest) Preview {
+
+	notNull := map[string]bool{}
+	for _, c := range p.NotNull {
+		notNull[c] = true
+	}
+
+	for _, rule := range req.Validate {
+		col := renamedColumn(rule.Column, req.Rename)
+		if rule.Required && rule.Column != "" && !notNull[col] {
+			p.NotNull = append(p.NotNull, col)
+			notNull[col] = true
+		}
+	}
+
+	return p
+}
+
+// validateStream keeps the cleaned rows that break a rule out of the
+// table, recording them against the job.
+type validateStream struct {
+	rowStream
+	validations []validation
+	jobID       string
+	row         int
+	rejected    int
+}
+
+func (s *validateStream) Next() ([]string, error) {
+
+	for {
+		r, err := s.rowStream.Next()
+		if err != nil {
+			return nil, err
+		}
+		s.row++
+
+		reason := validateRow(r, s.validations)
+		if reason == "" {
+			return r, nil
+		}
+
+		s.rejected++
+		recordRejects(s.jobID, []RejectedRow{{Row: s.row, Reason: reason, Cells: r}})
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// VARCHAR SIZING //////////////////////
+///////////////////////////////////////////////////////////
+
+// Short string columns become VARCHAR(n) so they can be indexed; n is the
+// smallest of varcharSizes that leaves 50% headroom over the longest
+// observed value. Longer content stays TEXT.
+var varcharSizes = []int{16, 32, 64, 128, 255}
+
+// stringType sizes a text column from its longest value in characters.
+func stringType(longest int) string {
+
+	need := longest + longest/2
+
+	for _, n := range varcharSizes {
+		if need <= n {
+			return fmt.Sprintf("VARCHAR(%d)", n)
+		}
+	}
+
+	return "TEXT"
+}
+
+// varcharLength returns n for a VARCHAR(n) type.
+func varcharLength(t string) (int, bool) {
+
+	t = strings.ToUpper(strings.TrimSpace(t))
+
+	inner, ok := strings.CutPrefix(t, "VARCHAR(")
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(strings.TrimSuffix(inner, ")"))
+	return n, err == nil
+}
+
+// widerString reports whether string type next needs a longer column than
+// cur: a longer VARCHAR, or TEXT over a VARCHAR.
+func widerString(cur, next string) bool {
+
+	c, ok := varcharLength(cur)
+	if !ok {
+		return false
+	}
+
+	if strings.ToUpper(next) == "TEXT" {
+		return true
+	}
+
+	n, ok := varcharLength(next)
+	return ok && n > c
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// WEBHOOK PUSH ////////////////////////
+///////////////////////////////////////////////////////////
+
+// External systems register a target table once (POST /webhooks) and then
+// push JSON rows to /webhook/{source_id}. Rows are buffered per source
+// and flushed as one append job when the buffer fills or the flush
+// interval passes, so bursts of small pushes become a few Kafka messages.
+
+const (
+	webhookBatchRows     = 500
+	webhookFlushInterval = 2 * time.Second
+)
+
+type WebhookSource struct {
+	ID      string            `json:"source_id"`
+	Table   string            `json:"table"`
+	Columns []string          `json:"columns"`
+	Types   map[string]string `json:"types"`
+}
+
+type webhookBuffer struct {
+	source *WebhookSource
+	rows   [][]string
+}
+
+var webhookMu sync.Mutex
+var webhookSources = map[string]*WebhookSource{}
+var webhookBuffers = map[string]*webhookBuffer{}
+
+func ensureWebhookTables() {
+
+	db.Exec(`
+	CREATE TABLE IF NOT EXISTS webhook_sources(
+		id VARCHAR(64) PRIMARY KEY,
+		table_name VARCHAR(255),
+		columns_json TEXT,
+		types_json TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)`)
+}
+
+// registerWebhookHandler stores a source. Without columns the schema is
+// read from the existing table.
+func registerWebhookHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var src WebhookSource
+	json.NewDecoder(r.Body).Decode(&src)
+
+	if src.Table == "" {
+		http.Error(w, "table required", http.StatusBadRequest)
+		return
+	}
+
+	if len(src.Columns) == 0 {
+		cols, types, err := existingTableSchema(src.Table)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		src.Columns, src.Types = cols, types
+	} else {
+		src.Columns = normalizeColumns(src.Columns)
+		if src.Types == nil {
+			src.Types = map[string]string{}
+		}
+		for _, c := range src.Columns {
+			if src.Types[c] == "" {
+				src.Types[c] = "TEXT"
+			}
+		}
+	}
+
+	if src.ID == "" {
+		src.ID = uuid.New().String()
+	}
+
+	cols, _ := json.Marshal(src.Columns)
+	types, _ := json.Marshal(src.Types)
+
+	if _, err := db.Exec(`
+	REPLACE INTO webhook_sources (id, table_name, columns_json, types_json)
+	VALUES (?, ?, ?, ?)`,
+		src.ID, src.Table, string(cols), string(types)); err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	webhookMu.Lock()
+	webhookSources[src.ID] = &src
+	webhookMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(src)
+}
+
+func existingTableSchema(table string) ([]string, map[string]string, error) {
+
+	query := `
+	SELECT column_name, column_type
+	FROM information_schema.columns
+	WHERE table_schema = DATABASE() AND table_name = ?
+	ORDER BY ordinal_position`
+	if dbDriver == dbSQLite {
+		query = `SELECT name, type FROM pragma_table_info(?) ORDER BY cid`
+	}
+
+	rows, err := db.Query(query, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var cols []string
+	types := map[string]string{}
+
+	for rows.Next() {
+		var name, typ string
+		rows.Scan(&name, &typ)
+		if name == dedupKeyColumn {
+			continue // internal, see dedup.go
+		}
+		cols = append(cols, name)
+		// ENUM values keep their case; only the type name is upper-cased.
+		if i := strings.Index(typ, "("); i != -1 {
+			typ = strings.ToUpper(typ[:i]) + typ[i:]
+		} else {
+			typ = strings.ToUpper(typ)
+		}
+		types[name] = typ
+	}
+
+	if len(cols) == 0 {
+		return nil, nil, fmt.Errorf("table %q does not exist; pass columns to register it", table)
+	}
+
+	return cols, types, nil
+}
+
+func lookupWebhookSource(id string) (*WebhookSource, error) {
+
+	webhookMu.Lock()
+	src, ok := webhookSources[id]
+	webhookMu.Unlock()
+
+	if ok {
+		return src, nil
+	}
+
+	var table, cols, types string
+	err := db.QueryRow(`
+	SELECT table_name, columns_json, types_json
+	FROM webhook_sources WHERE id=?`, id).Scan(&table, &cols, &types)
+	if err != nil {
+		return nil, fmt.Errorf("unknown webhook source %q", id)
+	}
+
+	src = &WebhookSource{ID: id, Table: table}
+	json.Unmarshal([]byte(cols), &src.Columns)
+	json.Unmarshal([]byte(types), &src.Types)
+
+	webhookMu.Lock()
+	webhookSources[id] = src
+	webhookMu.Unlock()
+
+	return src, nil
+}
+
+// webhookHandler accepts a JSON object, an array of objects or NDJSON.
+// Keys are normalized like column names; unknown keys are ignored.
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if rejectWhenBusy(w) {
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/webhook/"), "/")
+
+	src, err := lookupWebhookSource(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 16<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if bytes.HasPrefix(body, []byte("{")) && !bytes.Contains(body, []byte("\n")) {
+		body = append(append([]byte("["), body...), ']')
+	}
+
+	var header []string
+	var stream rowStream
+
+	if bytes.HasPrefix(body, []byte("[")) {
+		header, stream, err = openJSONStream(io.NopCloser(bytes.NewReader(body)))
+	} else {
+		header, stream, err = openNDJSONStream(io.NopCloser(bytes.NewReader(body)))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pushed, err := drainRows(stream, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Reorder each pushed row into the registered column order.
+	position := map[string]int{}
+	for i, c := range normalizeColumns(header) {
+		position[c] = i
+	}
+
+	rows := make([][]string, len(pushed))
+	for i, p := range pushed {
+		row := make([]string, len(src.Columns))
+		for j, c := range src.Columns {
+			if k, ok := position[c]; ok && k < len(p) {
+				row[j] = p[k]
+			}
+		}
+		rows[i] = row
+	}
+
+	bufferWebhookRows(src, rows)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"accepted": len(rows)})
+}
+
+func bufferWebhookRows(src *WebhookSource, rows [][]string) {
+
+	webhookMu.Lock()
+
+	buf, ok := webhookBuffers[src.ID]
+	if !ok {
+		buf = &webhookBuffer{source: src}
+		webhookBuffers[src.ID] = buf
+	}
+
+	buf.rows = append(buf.rows, rows...)
+
+	var ready [][]string
+	if len(buf.rows) >= webhookBatchRows {
+		ready, buf.rows = buf.rows, nil
+	}
+
+	webhookMu.Unlock()
+
+	if ready != nil {
+		flushWebhookRows(src, ready)
+	}
+}
+
+// startWebhookFlusher publishes whatever is buffered every interval.
+func startWebhookFlusher() {
+
+	for range time.Tick(webhookFlushInterval) {
+
+		webhookMu.Lock()
+		var pending []*webhookBuffer
+		for _, buf := range webhookBuffers {
+			if len(buf.rows) > 0 {
+				pending = append(pending, &webhookBuffer{source: buf.source, rows: buf.rows})
+				buf.rows = nil
+			}
+		}
+		webhookMu.Unlock()
+
+		for _, p := range pending {
+			flushWebhookRows(p.source, p.rows)
+		}
+	}
+}
+
+func flushWebhookRows(src *WebhookSource, rows [][]string) {
+
+	p := Preview{
+		Columns: src.Columns,
+		Types:   src.Types,
+		Rows:    rows,
+	}
+
+	jobID := startJob(p, IngestRequest{Table: src.Table, Mode: "append"})
+	fmt.Printf("🪝 Webhook %s: %d rows → job %s\n", src.ID, len(rows), jobID)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// COLUMN WIDENING /////////////////////
+///////////////////////////////////////////////////////////
+
+// schemaWidening is on unless SCHEMA_WIDENING=off. When off, appends
+// keep the existing column types and the conflicts are only logged.
+var schemaWidening = os.Getenv("SCHEMA_WIDENING") != "off"
+
+// intDigits is how many decimal digits each integer type holds.
+var intDigits = map[string]int{
+	"TINYINT": 3, "SMALLINT": 5, "MEDIUMINT": 8, "INT": 10, "INTEGER": 10, "BIGINT": 19,
+}
+
+// widenedType returns the type a column of type cur must become to hold
+// data inferred as next without losing what it holds, or false when cur
+// already holds it or no wider type holds both.
+func widenedType(cur, next string) (string, bool) {
+
+	if widerInt(cur, next) || widerString(cur, next) {
+		return next, true
+	}
+
+	if union, ok := widerEnum(cur, next); ok {
+		return union, true
+	}
+
+	if union, ok := widerDecimal(cur, next); ok {
+		return union, true
+	}
+
+	c, n := strings.ToUpper(strings.TrimSpace(cur)), strings.ToUpper(strings.TrimSpace(next))
+
+	switch {
+	case c == "FLOAT" && n == "DOUBLE", c == "DATE" && n == "DATETIME":
+		return n, true
+	case intDigits[intBase(c)] > 0 && (n == "FLOAT" || n == "DOUBLE"):
+		return n, true
+	case intDigits[intBase(c)] > 0 && strings.HasPrefix(n, "DECIMAL"):
+		// The existing integers must still fit in front of the point.
+		return widerDecimal(fmt.Sprintf("DECIMAL(%d,0)", intDigits[intBase(c)]), n)
+	}
+
+	return "", false
+}
+
+// widenColumns widens columns of an existing table whose new data no
+// longer fits before an append: an INT column receiving values above
+// 2^31 or decimals, a VARCHAR column receiving longer strings, a DATE
+// column receiving times, an ENUM column receiving new values, or a NOT
+// NULL column receiving empty cells. Conflicts no wider type resolves
+// are logged on the job.
+func widenColumns(table string, p Preview, jobID string) {
+
+	_, existing, err := existingTableSchema(table)
+	if err != nil {
+		return
+	}
+
+	required := notNullInTable(table)
+
+	incomingNotNull := map[string]bool{}
+	for _, c := range p.NotNull {
+		incomingNotNull[c] = true
+	}
+
+	for _, c := range p.Columns {
+
+		cur, ok := existing[c]
+		if !ok {
+			continue
+		}
+
+		next := cur
+		if t, ok := widenedType(cur, p.Types[c]); ok {
+			next = t
+		} else if reconcileType(cur, p.Types[c]) != cur {
+			logJob(jobID, fmt.Sprintf("column %s is %s but the new data reads as %s; rows that do not fit will fail", c, cur, p.Types[c]))
+		}
+
+		keepNotNull := required[c] && incomingNotNull[c]
+		if next == cur && keepNotNull == required[c] {
+			continue
+		}
+
+		if !schemaWidening {
+			if next != cur {
+				logJob(jobID, fmt.Sprintf("column %s needs %s (is %s); SCHEMA_WIDENING is off", c, next, cur))
+			} else {
+				logJob(jobID, fmt.Sprintf("column %s is NOT NULL but the new data has empty cells; SCHEMA_WIDENING is off", c))
+			}
+			continue
+		}
+
+		// SQLite stores any value in any column, but cannot drop a NOT
+		// NULL without rebuilding the table.
+		if dbDriver == dbSQLite {
+			if keepNotNull != required[c] {
+				logJob(jobID, fmt.Sprintf("column %s is NOT NULL but the new data has empty cells; SQLite cannot relax it", c))
+			}
+			continue
+		}
+
+		def := next
+		if keepNotNull {
+			def += " NOT NULL"
+		}
+
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN %s %s", table, c, def)); err != nil {
+			fmt.Printf("⚠️  Failed to widen %s.%s to %s: %v\n", table, c, def, err)
+			logJob(jobID, fmt.Sprintf("failed to widen %s to %s: %v", c, def, err))
+			continue
+		}
+
+		fmt.Printf("↔️  Widened %s.%s from %s to %s\n", table, c, cur, def)
+		logJob(jobID, fmt.Sprintf("widened %s from %s to %s", c, cur, def))
+	}
+}
+
+// notNullInTable lists the NOT NULL columns of an existing table.
+func notNullInTable(table string) map[string]bool {
+
+	cols := map[string]bool{}
+
+	query := `
+	SELECT column_name
+	FROM information_schema.columns
+	WHERE table_schema = DATABASE() AND table_name = ? AND is_nullable = 'NO'`
+	if dbDriver == dbSQLite {
+		query = `SELECT name FROM pragma_table_info(?) WHERE "notnull" = 1`
+	}
+
+	rows, err := db.Query(query, table)
+	if err != nil {
+		return cols
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		cols[name] = true
+	}
+
+	return cols
+}
+
+// sampledTypes loosens types inferred from a sample of a streamed source,
+// whose later rows may hold larger values or longer strings.
+func sampledTypes(types map[string]string) {
+
+	for c, t := range types {
+		if t == "TINYINT" {
+			types[c] = "INT"
+		}
+		if _, ok := varcharLength(t); ok {
+			types[c] = "VARCHAR(255)"
+		}
+	}
+}
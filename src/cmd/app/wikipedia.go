@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// WIKIPEDIA PROFILE ///////////////////
+///////////////////////////////////////////////////////////
+
+// wikipediaNoise is markup that renders as clutter in cell text:
+// footnote markers, hidden sort keys, edit links and flag icons.
+const wikipediaNoise = `sup.reference, sup.noprint, .mw-ref, .sortkey, .mw-editsection,
+	span.flagicon, style, [style*="display:none"], [style*="display: none"]`
+
+// extractWikipediaTable reads a wikitable: noise is stripped, spans are
+// expanded, stacked header rows are joined per column, and rows that
+// start with a row-header <th> are kept as data. Totals rows marked
+// sortbottom are treated as footer rows.
+func extractWikipediaTable(table *goquery.Selection, req IngestRequest) ([]string, [][]string, error) {
+
+	table.Find(wikipediaNoise).Remove()
+	table.Find("br").ReplaceWithHtml(" ")
+
+	grid := tableGrid(table, func(c *goquery.Selection) string {
+		return strings.Join(strings.Fields(c.Text()), " ")
+	})
+
+	cols, rows := gridTable(grid, req)
+	if len(cols) == 0 {
+		return nil, nil, fmt.Errorf("no header row found in wikitable")
+	}
+
+	return cols, rows, nil
+}
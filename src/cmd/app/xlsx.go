@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// EXCEL SOURCE ////////////////////////
+///////////////////////////////////////////////////////////
+
+// parseXLSX reads one worksheet of a workbook. sheet may be a sheet name
+// or a zero-based index; empty selects the first sheet.
+func parseXLSX(body []byte, sheet string, layout gridLayout) (Preview, error) {
+
+	f, err := excelize.OpenReader(bytes.NewReader(body))
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to open workbook: %w", err)
+	}
+	defer f.Close()
+
+	name, err := resolveSheet(f.GetSheetList(), sheet)
+	if err != nil {
+		return Preview{}, err
+	}
+
+	all, err := f.GetRows(name)
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to read sheet %q: %w", name, err)
+	}
+
+	cols, rows := splitHeader(all, layout)
+	return buildPreview(cols, rows)
+}
+
+func resolveSheet(sheets []string, sheet string) (string, error) {
+
+	if len(sheets) == 0 {
+		return "", fmt.Errorf("workbook has no sheets")
+	}
+
+	if sheet == "" {
+		return sheets[0], nil
+	}
+
+	for _, s := range sheets {
+		if strings.EqualFold(s, sheet) {
+			return s, nil
+		}
+	}
+
+	if i, err := strconv.Atoi(sheet); err == nil && i >= 0 && i < len(sheets) {
+		return sheets[i], nil
+	}
+
+	return "", fmt.Errorf("sheet %q not found (available: %s)", sheet, strings.Join(sheets, ", "))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+///////////////////////////////////////////////////////////
+//////////////////// XML SOURCE //////////////////////////
+///////////////////////////////////////////////////////////
+
+// parseXML selects repeating records with an XPath expression (e.g.
+// "//item") and maps each record to a row. With explicit fields every
+// column comes from its relative XPath; otherwise the record's
+// attributes and child elements become the columns, in document order.
+func parseXML(body []byte, rowPath string, fields []FieldSelector) (Preview, error) {
+
+	if rowPath == "" {
+		return Preview{}, fmt.Errorf("row_selector is required for XML sources")
+	}
+
+	doc, err := xmlquery.Parse(bytes.NewReader(body))
+	if err != nil {
+		return Preview{}, fmt.Errorf("failed to parse XML: %w", err)
+	}
+
+	records, err := xmlquery.QueryAll(doc, rowPath)
+	if err != nil {
+		return Preview{}, fmt.Errorf("invalid row selector %q: %w", rowPath, err)
+	}
+
+	if len(records) == 0 {
+		return Preview{}, fmt.Errorf("no records matched %q", rowPath)
+	}
+
+	if len(fields) > 0 {
+		return parseXMLFields(records, fields)
+	}
+
+	var cols []string
+	index := map[string]int{}
+	var flat []map[string]string
+
+	add := func(row map[string]string, name, value string) {
+		if _, ok := index[name]; !ok {
+			index[name] = len(cols)
+			cols = append(cols, name)
+		}
+		row[name] = strings.TrimSpace(value)
+	}
+
+	for _, rec := range records {
+
+		row := map[string]string{}
+
+		for _, a := range rec.Attr {
+			add(row, a.Name.Local, a.Value)
+		}
+
+		for c := rec.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == xmlquery.ElementNode {
+				add(row, c.Data, c.InnerText())
+			}
+		}
+
+		flat = append(flat, row)
+	}
+
+	rows := make([][]string, len(flat))
+	for i, m := range flat {
+		row := make([]string, len(cols))
+		for j, c := range cols {
+			row[j] = m[c]
+		}
+		rows[i] = row
+	}
+
+	return buildPreview(cols, rows)
+}
+
+func parseXMLFields(records []*xmlquery.Node, fields []FieldSelector) (Preview, error) {
+
+	cols := make([]string, len(fields))
+	for i, f := range fields {
+		cols[i] = f.Name
+	}
+
+	var rows [][]string
+
+	for _, rec := range records {
+
+		row := make([]string, len(fields))
+
+		for i, f := range fields {
+
+			n, err := xmlquery.Query(rec, f.Path)
+			if err != nil {
+				return Preview{}, fmt.Errorf("invalid path %q for %q: %w", f.Path, f.Name, err)
+			}
+			if n == nil {
+				continue
+			}
+
+			if f.Attr != "" {
+				row[i] = strings.TrimSpace(n.SelectAttr(f.Attr))
+			} else {
+				row[i] = strings.TrimSpace(n.InnerText())
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return buildPreview(cols, rows)
+}
@@ -25,11 +25,24 @@ async function preview() {
 
     let url = document.getElementById("url").value;
 
-    let res = await fetch("/preview", {
-        method: "POST",
-        headers: {"Content-Type": "application/json"},
-        body: JSON.stringify({url})
-    });
+    let res;
+    if (selectedFile()) {
+        let form = uploadForm();
+        form.append("preview", "true");
+        res = await fetch("/upload", {method: "POST", body: form});
+    } else {
+        let content = document.getElementById("content").value;
+        res = await fetch("/preview", {
+            method: "POST",
+            headers: {"Content-Type": "application/json"},
+            body: JSON.stringify({url, content})
+        });
+    }
+
+    if (!res.ok) {
+        setStatus("Preview failed: " + await res.text());
+        return;
+    }
 
     let data = await res.json();
 
@@ -47,7 +60,24 @@ function showSchema(data) {
     box.innerText = "";
 
     for (let c of data.columns) {
-        box.innerText += c + " : " + data.types[c] + "\n";
+        let line = c + " : " + data.types[c];
+        if (data.semantic && data.semantic[c]) {
+            line += " [" + data.semantic[c] + "]";
+        }
+        let st = data.stats && data.stats[c];
+
+        if (st) {
+            line += " (" + Math.round(st.confidence * 100) + "% confident";
+            if (st.null_ratio > 0) {
+                line += ", " + Math.round(st.null_ratio * 100) + "% empty";
+            }
+            line += ")";
+            if (st.warning) {
+                line += " ⚠️ " + st.warning;
+            }
+        }
+
+        box.innerText += line + "\n";
     }
 }
 
@@ -100,14 +130,20 @@ async function ingest() {
         url: document.getElementById("url").value,
         table: document.getElementById("table").value,
         mode: document.getElementById("mode").value,
-        dedup: document.getElementById("dedup").checked
+        dedup: document.getElementById("dedup").checked,
+        content: document.getElementById("content").value
     };
 
-    let res = await fetch("/ingest", {
-        method: "POST",
-        headers: {"Content-Type": "application/json"},
-        body: JSON.stringify(payload)
-    });
+    let res;
+    if (selectedFile()) {
+        res = await fetch("/upload", {method: "POST", body: uploadForm()});
+    } else {
+        res = await fetch("/ingest", {
+            method: "POST",
+            headers: {"Content-Type": "application/json"},
+            body: JSON.stringify(payload)
+        });
+    }
 
     currentJob = await res.text();
 
@@ -115,6 +151,23 @@ async function ingest() {
     pollLogs();
 }
 
+/*
+File upload helpers
+*/
+function selectedFile() {
+    let input = document.getElementById("file");
+    return input && input.files.length > 0 ? input.files[0] : null;
+}
+
+function uploadForm() {
+    let form = new FormData();
+    form.append("file", selectedFile());
+    form.append("table", document.getElementById("table").value);
+    form.append("mode", document.getElementById("mode").value);
+    form.append("dedup", document.getElementById("dedup").checked);
+    return form;
+}
+
 /*
 Poll ingestion progress
 */